@@ -0,0 +1,9 @@
+package main
+
+import "embed"
+
+// assets are the templates and static files, built into the binary so the
+// server can run from any directory
+//
+//go:embed tmpl static
+var assets embed.FS
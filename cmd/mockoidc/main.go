@@ -0,0 +1,230 @@
+// Command mockoidc is an OpenID Connect issuer for trying single sign-on
+// locally. It signs in whoever types an email address, so must never be
+// exposed.
+//
+//	go run ./cmd/mockoidc -addr :9000
+//	OIDC_ISSUER=http://localhost:9000 OIDC_CLIENT_ID=news OIDC_CLIENT_SECRET=secret ./news
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"html/template"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+var (
+	addr         = flag.String("addr", ":9000", "address to listen on")
+	issuer       = flag.String("issuer", "http://localhost:9000", "issuer URL, as the app and browser reach it")
+	clientID     = flag.String("client-id", "news", "the only client ID accepted")
+	clientSecret = flag.String("client-secret", "secret", "the client's secret")
+	unverified   = flag.Bool("unverified", false, "send email_verified false")
+)
+
+const keyID = "mock"
+
+// grant is an authorization code waiting to be swapped for tokens
+type grant struct {
+	email       string
+	nonce       string
+	redirectURI string
+	challenge   string
+	expires     time.Time
+}
+
+type issuerServer struct {
+	key *rsa.PrivateKey
+
+	mu     sync.Mutex
+	grants map[string]grant
+}
+
+func main() {
+	flag.Parse()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatal(err)
+	}
+	s := &issuerServer{key: key, grants: make(map[string]grant)}
+
+	http.HandleFunc("/.well-known/openid-configuration", s.discovery)
+	http.HandleFunc("/authorize", s.authorize)
+	http.HandleFunc("/token", s.token)
+	http.HandleFunc("/jwks", s.jwks)
+	log.Printf("mock OIDC issuer %s listening on %s", *issuer, *addr)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
+
+func (s *issuerServer) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]interface{}{
+		"issuer":                                *issuer,
+		"authorization_endpoint":                *issuer + "/authorize",
+		"token_endpoint":                        *issuer + "/token",
+		"jwks_uri":                              *issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"scopes_supported":                      []string{"openid", "email", "profile"},
+	})
+}
+
+var authorizePage = template.Must(template.New("authorize").Parse(`<!doctype html>
+<title>Mock sign in</title>
+<form method="post">
+<p>Sign in to {{.ClientID}} as</p>
+<input type="email" name="email" value="reader@example.com" autofocus>
+{{range $k, $v := .Query}}<input type="hidden" name="{{$k}}" value="{{index $v 0}}">{{end}}
+<button>Sign in</button> <button name="deny" value="1">Deny</button>
+</form>`))
+
+// authorize shows a form asking for an email address, then sends the
+// browser back with a code
+func (s *issuerServer) authorize(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	q := r.Form
+	if q.Get("client_id") != *clientID {
+		http.Error(w, "unknown client", http.StatusBadRequest)
+		return
+	}
+	if q.Get("response_type") != "code" || q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		http.Error(w, "only the code flow with S256 PKCE is supported", http.StatusBadRequest)
+		return
+	}
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || redirect.Host == "" {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		query := url.Values{}
+		for _, k := range []string{"client_id", "response_type", "redirect_uri", "state", "nonce", "code_challenge", "code_challenge_method", "scope"} {
+			query.Set(k, q.Get(k))
+		}
+		authorizePage.Execute(w, map[string]interface{}{"ClientID": *clientID, "Query": query})
+		return
+	}
+
+	back := redirect.Query()
+	back.Set("state", q.Get("state"))
+	if q.Get("deny") != "" {
+		back.Set("error", "access_denied")
+	} else {
+		code := randomHex()
+		s.mu.Lock()
+		s.grants[code] = grant{
+			email:       q.Get("email"),
+			nonce:       q.Get("nonce"),
+			redirectURI: q.Get("redirect_uri"),
+			challenge:   q.Get("code_challenge"),
+			expires:     time.Now().Add(time.Minute),
+		}
+		s.mu.Unlock()
+		back.Set("code", code)
+	}
+	redirect.RawQuery = back.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+// token swaps a code for an ID token, checking the client and the PKCE
+// verifier
+func (s *issuerServer) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	id, secret, ok := r.BasicAuth()
+	if !ok {
+		id, secret = r.Form.Get("client_id"), r.Form.Get("client_secret")
+	}
+	if id != *clientID || subtle.ConstantTimeCompare([]byte(secret), []byte(*clientSecret)) != 1 {
+		tokenError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+	if r.Form.Get("grant_type") != "authorization_code" {
+		tokenError(w, http.StatusBadRequest, "unsupported_grant_type")
+		return
+	}
+
+	s.mu.Lock()
+	g, ok := s.grants[r.Form.Get("code")]
+	delete(s.grants, r.Form.Get("code"))
+	s.mu.Unlock()
+	if !ok || time.Now().After(g.expires) || g.redirectURI != r.Form.Get("redirect_uri") {
+		tokenError(w, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+	sum := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != g.challenge {
+		tokenError(w, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+
+	now := time.Now()
+	sub := sha256.Sum256([]byte(g.email))
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":            *issuer,
+		"sub":            hex.EncodeToString(sub[:8]),
+		"aud":            *clientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Hour).Unix(),
+		"nonce":          g.nonce,
+		"email":          g.email,
+		"email_verified": !*unverified,
+	})
+	idToken.Header["kid"] = keyID
+	signed, err := idToken.SignedString(s.key)
+	if err != nil {
+		tokenError(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"access_token": randomHex(),
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     signed,
+	})
+}
+
+func (s *issuerServer) jwks(w http.ResponseWriter, r *http.Request) {
+	pub := s.key.PublicKey
+	writeJSON(w, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"alg": "RS256",
+			"use": "sig",
+			"kid": keyID,
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+func tokenError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": code})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(v)
+}
+
+func randomHex() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/RusticPotatoes/news/dao"
+)
+
+// runDB migrates or backs up the database, it's opened without the setup
+// the server does since that needs an up to date schema
+func runDB(ctx context.Context, args []string) error {
+	sub, args := subcommand(args)
+	switch sub {
+	case "migrate":
+		if _, err := parse(flags("db migrate"), args, 0); err != nil {
+			return err
+		}
+		from, err := dao.SchemaVersion(ctx)
+		if err != nil {
+			return err
+		}
+		applied, err := dao.Migrate(ctx)
+		for i, name := range applied {
+			fmt.Printf("applied %d: %s\n", from+i+1, name)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("schema is at version %d\n", dao.LatestSchemaVersion())
+		return nil
+
+	case "backup":
+		rest, err := parse(flags("db backup"), args, 1)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stat(rest[0]); err == nil {
+			return fmt.Errorf("%s already exists", rest[0])
+		}
+		if err := dao.Backup(ctx, rest[0]); err != nil {
+			return err
+		}
+		fmt.Printf("backed up to %s\n", rest[0])
+		return nil
+	}
+	return errUsage
+}
+
+// runPrune deletes what the server's nightly cleanup does
+func runPrune(ctx context.Context, args []string) error {
+	fs := flags("prune")
+	age := fs.Duration("age", 30*24*time.Hour, "delete login attempts and fetch errors older than this")
+	if _, err := parse(fs, args, 0); err != nil {
+		return err
+	}
+	if err := dao.DeleteExpiredSessions(ctx); err != nil {
+		return err
+	}
+	if err := dao.DeleteLoginAttempts(ctx, *age); err != nil {
+		return err
+	}
+	if err := dao.DeleteFetchErrors(ctx, *age); err != nil {
+		return err
+	}
+	fmt.Printf("deleted expired sessions, and login attempts and fetch errors older than %s\n", *age)
+	return nil
+}
+
+// runSearch searches recent articles the way the site's search does
+func runSearch(ctx context.Context, args []string) error {
+	rest, err := parse(flags("search"), args, 1)
+	if err != nil {
+		return err
+	}
+	articles, err := dao.SearchInCache(ctx, rest[0])
+	if err != nil {
+		return err
+	}
+	for _, a := range articles {
+		fmt.Printf("%s\n  %s\n", a.Title, a.Link)
+	}
+	fmt.Printf("%d results\n", len(articles))
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/RusticPotatoes/news/dao"
+	"github.com/RusticPotatoes/news/pkg/jobs"
+)
+
+func runEdition(ctx context.Context, args []string) error {
+	sub, args := subcommand(args)
+	switch sub {
+	case "generate":
+		fs := flags("edition generate")
+		force := fs.Bool("force", false, "make a new edition even if the current one is still in its window")
+		if _, err := parse(fs, args, 0); err != nil {
+			return err
+		}
+		e, err := jobs.GenerateEdition(ctx, *force)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("edition %s, %s, with %d articles\n", e.ID, e.Name, len(e.Articles))
+		return nil
+
+	case "list":
+		fs := flags("edition list")
+		n := fs.Int("n", 20, "how many editions to list")
+		if _, err := parse(fs, args, 0); err != nil {
+			return err
+		}
+		editions, err := dao.GetEditions(ctx, *n)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME\tDATE\tCREATED\tARTICLES")
+		for _, e := range editions {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\n", e.ID, e.Name, e.Date, e.Created.Format("2006-01-02 15:04"), len(e.Articles))
+		}
+		return w.Flush()
+
+	case "show":
+		rest, err := parse(flags("edition show"), args, 1)
+		if err != nil {
+			return err
+		}
+		e, err := dao.GetEdition(ctx, rest[0])
+		if err != nil {
+			return err
+		}
+		if e == nil {
+			return fmt.Errorf("no edition %s", rest[0])
+		}
+		fmt.Printf("%s, %s\n", e.Name, e.Date)
+		fmt.Printf("Articles from %s to %s, created %s\n\n",
+			e.StartTime.Format("2006-01-02 15:04"), e.EndTime.Format("2006-01-02 15:04"), e.Created.Format("2006-01-02 15:04"))
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "SOURCE\tTITLE\tLINK")
+		for _, a := range e.Articles {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", a.Source.Name, a.Title, a.Link)
+		}
+		return w.Flush()
+	}
+	return errUsage
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/RusticPotatoes/news/dao"
+	"github.com/RusticPotatoes/news/domain"
+	"github.com/RusticPotatoes/news/pkg/articles"
+	"github.com/RusticPotatoes/news/pkg/jobs"
+)
+
+// runFetch fetches one source, or every owner's sources like the scheduled
+// fetch does
+func runFetch(ctx context.Context, args []string) error {
+	fs := flags("fetch")
+	sourceID := fs.String("source", "", "only fetch the source with this id")
+	if _, err := parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	if *sourceID != "" {
+		source, err := dao.GetSource(ctx, *sourceID)
+		if err != nil {
+			return err
+		}
+		if source == nil {
+			return fmt.Errorf("no source %s", *sourceID)
+		}
+		if err := articles.FetchSource(ctx, *source); err != nil {
+			return err
+		}
+		fmt.Printf("fetched %s\n", source.Name)
+		return nil
+	}
+
+	result, err := jobs.FetchAllSources(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("fetched %s\n", result)
+	return nil
+}
+
+// runExtract fetches and extracts an article without storing it, to check
+// how a page will come out
+func runExtract(ctx context.Context, args []string) error {
+	fs := flags("extract")
+	owner := fs.String("owner", "admin", "tag the article with this owner's classifier")
+	text := fs.Bool("text", false, "print the article's full text")
+	rest, err := parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	if u, err := url.Parse(rest[0]); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("%q isn't an http or https URL", rest[0])
+	}
+
+	a, err := articles.Preview(ctx, *owner, rest[0])
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Title:     %s\n", a.Title)
+	fmt.Printf("Author:    %s\n", a.Author)
+	if !a.Timestamp.IsZero() {
+		fmt.Printf("Published: %s\n", a.Timestamp.Format("2006-01-02 15:04 MST"))
+	}
+	fmt.Printf("Image:     %s\n", a.ImageURL)
+	fmt.Printf("Words:     %d\n", len(strings.Fields(a.Content.TextContent)))
+	fmt.Printf("Tags:      %s\n", strings.Join(a.Tags, ", "))
+	fmt.Printf("\n%s\n", a.Description)
+	for _, n := range domain.SummaryLengths {
+		if s, ok := a.Summaries[n]; ok {
+			fmt.Printf("\nSummary, %d sentences:\n%s\n", n, s)
+		}
+	}
+	if *text {
+		fmt.Printf("\n%s\n", strings.TrimSpace(a.Content.TextContent))
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+// Command newsctl runs admin tasks against the server's database, using the
+// same code the server does. It reads the same config file and environment.
+//
+//	newsctl [-config file] <command> [flags] [args]
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/monzo/slog"
+
+	"github.com/RusticPotatoes/news/dao"
+	"github.com/RusticPotatoes/news/domain"
+	"github.com/RusticPotatoes/news/pkg/util"
+)
+
+// command is a newsctl command, or a group of them
+type command struct {
+	usage string
+	run   func(ctx context.Context, args []string) error
+	// raw commands open the database themselves, the rest get it set up
+	// the way the server does
+	raw bool
+}
+
+var commands = map[string]command{
+	"fetch":   {usage: "fetch [-source id]", run: runFetch},
+	"edition": {usage: "edition generate [-force] | list [-n count] | show <id>", run: runEdition},
+	"source":  {usage: "source add | list | disable | enable | import-opml", run: runSource},
+	"user":    {usage: "user create [-admin] <name> | reset-password <name> | promote [-demote] <name>", run: runUser},
+	"search":  {usage: "search <query>", run: runSearch},
+	"prune":   {usage: "prune [-age duration]", run: runPrune},
+	"db":      {usage: "db migrate | backup <path>", run: runDB, raw: true},
+	"extract": {usage: "extract [-owner name] [-text] <url>", run: runExtract},
+}
+
+// errUsage means the arguments were wrong, usage is printed for it
+var errUsage = errors.New("usage")
+
+func main() {
+	// logs go to stderr so output can be piped
+	var logger slog.Logger
+	logger = util.ColourLogger{Writer: os.Stderr}
+	slog.SetDefaultLogger(logger)
+
+	configPath := flag.String("config", os.Getenv("NEWS_CONFIG"), "JSON config file, see config.example.json")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "newsctl: unknown command %q\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+
+	// Ctrl-C stops fetches between articles rather than mid-write
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// commands don't serve anything, so the config is loaded without
+	// insisting on the settings only the server needs
+	cfg, err := domain.LoadConfig(*configPath)
+	if err != nil {
+		fatal(err)
+	}
+	domain.SetConfig(cfg)
+
+	if cmd.raw {
+		err = dao.Open(cfg.DatabasePath())
+	} else {
+		err = dao.Init(ctx, cfg.DatabasePath())
+	}
+	if err != nil {
+		fatal(fmt.Errorf("error opening database, if it's from an older version run newsctl db migrate: %w", err))
+	}
+	defer dao.Close()
+
+	err = cmd.run(ctx, flag.Args()[1:])
+	if errors.Is(err, errUsage) {
+		fmt.Fprintf(os.Stderr, "usage: newsctl %s\n", cmd.usage)
+		dao.Close()
+		os.Exit(2)
+	}
+	if err != nil {
+		dao.Close()
+		fatal(err)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: newsctl [-config file] <command> [flags] [args]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "commands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %s\n", commands[name].usage)
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "newsctl: %s\n", err)
+	os.Exit(1)
+}
+
+// subcommand splits args into a subcommand of a group and its arguments
+func subcommand(args []string) (string, []string) {
+	if len(args) == 0 {
+		return "", nil
+	}
+	return args[0], args[1:]
+}
+
+// flags returns a flag set for a command that reports errors rather than
+// exiting, so the database is closed
+func flags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {}
+	return fs
+}
+
+// parse parses args into fs and returns the positional arguments, which
+// have to number n
+func parse(fs *flag.FlagSet, args []string, n int) ([]string, error) {
+	if err := fs.Parse(args); err != nil {
+		return nil, errUsage
+	}
+	if fs.NArg() != n {
+		return nil, errUsage
+	}
+	return fs.Args(), nil
+}
+
+// splitList splits a comma separated list, dropping empty items
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/RusticPotatoes/news/dao"
+	"github.com/RusticPotatoes/news/domain"
+	"github.com/RusticPotatoes/news/pkg/opml"
+)
+
+func runSource(ctx context.Context, args []string) error {
+	sub, args := subcommand(args)
+	switch sub {
+	case "add":
+		return sourceAdd(ctx, args)
+	case "list":
+		return sourceList(ctx, args)
+	case "disable", "enable":
+		rest, err := parse(flags("source "+sub), args, 1)
+		if err != nil {
+			return err
+		}
+		return sourceSetDisabled(ctx, rest[0], sub == "disable")
+	case "import-opml":
+		return sourceImport(ctx, args)
+	}
+	return errUsage
+}
+
+func sourceAdd(ctx context.Context, args []string) error {
+	fs := flags("source add")
+	owner := fs.String("owner", "admin", "the reader the source is for")
+	name := fs.String("name", "", "the source's name")
+	feedURL := fs.String("feed", "", "the RSS or Atom feed")
+	siteURL := fs.String("url", "", "the source's homepage, the feed if it's not set")
+	categories := fs.String("categories", "", "comma separated categories")
+	if _, err := parse(fs, args, 0); err != nil {
+		return err
+	}
+	if *siteURL == "" {
+		*siteURL = *feedURL
+	}
+
+	src := domain.Source{
+		OwnerID:    *owner,
+		Name:       strings.TrimSpace(*name),
+		URL:        *siteURL,
+		FeedURL:    *feedURL,
+		Categories: splitList(*categories),
+	}
+	if err := checkSource(src); err != nil {
+		return err
+	}
+	if err := dao.SetSource(ctx, &src); err != nil {
+		return err
+	}
+	fmt.Printf("added %s for %s\n", src.Name, src.OwnerID)
+	return nil
+}
+
+// checkSource applies the same rules as adding a source from the API
+func checkSource(s domain.Source) error {
+	if s.Name == "" {
+		return fmt.Errorf("sources need a name")
+	}
+	for _, v := range []string{s.URL, s.FeedURL} {
+		if u, err := url.Parse(v); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%q isn't an http or https URL", v)
+		}
+	}
+	return nil
+}
+
+func sourceList(ctx context.Context, args []string) error {
+	fs := flags("source list")
+	owner := fs.String("owner", "", "only list this reader's sources")
+	if _, err := parse(fs, args, 0); err != nil {
+		return err
+	}
+	sources, err := dao.GetAllSources(ctx)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tOWNER\tNAME\tFEED\tFETCH")
+	for _, s := range sources {
+		if *owner != "" && s.OwnerID != *owner {
+			continue
+		}
+		fetch := "on"
+		if s.DisableFetch {
+			fetch = "off"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", s.ID, s.OwnerID, s.Name, s.FeedURL, fetch)
+	}
+	return w.Flush()
+}
+
+func sourceSetDisabled(ctx context.Context, id string, disabled bool) error {
+	s, err := dao.GetSource(ctx, id)
+	if err != nil {
+		return err
+	}
+	if s == nil {
+		return fmt.Errorf("no source %s", id)
+	}
+	s.DisableFetch = disabled
+	if err := dao.SetSource(ctx, s); err != nil {
+		return err
+	}
+	if disabled {
+		fmt.Printf("%s won't be fetched\n", s.Name)
+	} else {
+		fmt.Printf("%s will be fetched\n", s.Name)
+	}
+	return nil
+}
+
+// sourceImport adds every feed in an OPML file, or stdin for -. Feeds that
+// are already sources are updated.
+func sourceImport(ctx context.Context, args []string) error {
+	fs := flags("source import-opml")
+	owner := fs.String("owner", "admin", "the reader the sources are for")
+	rest, err := parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	var r io.Reader = os.Stdin
+	if rest[0] != "-" {
+		f, err := os.Open(rest[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	doc, err := opml.Read(r)
+	if err != nil {
+		return err
+	}
+
+	var added, skipped int
+	for _, o := range doc.Feeds() {
+		src := domain.Source{
+			OwnerID:    *owner,
+			Name:       strings.TrimSpace(o.Name()),
+			URL:        o.HTMLURL,
+			FeedURL:    o.XMLURL,
+			Categories: splitList(o.Category),
+		}
+		if src.URL == "" {
+			src.URL = src.FeedURL
+		}
+		if err := checkSource(src); err != nil {
+			fmt.Fprintf(os.Stderr, "skipping %s: %s\n", src.FeedURL, err)
+			skipped++
+			continue
+		}
+		if err := dao.SetSource(ctx, &src); err != nil {
+			return err
+		}
+		added++
+	}
+	fmt.Printf("imported %d sources for %s, skipped %d\n", added, *owner, skipped)
+	return nil
+}
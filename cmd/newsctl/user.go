@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+
+	"github.com/RusticPotatoes/news/dao"
+	"github.com/RusticPotatoes/news/domain"
+	"github.com/RusticPotatoes/news/pkg/jobs"
+)
+
+func runUser(ctx context.Context, args []string) error {
+	sub, args := subcommand(args)
+	switch sub {
+	case "create":
+		fs := flags("user create")
+		admin := fs.Bool("admin", false, "make the user an admin")
+		rest, err := parse(fs, args, 1)
+		if err != nil {
+			return err
+		}
+		return userCreate(ctx, rest[0], *admin)
+	case "reset-password":
+		rest, err := parse(flags("user reset-password"), args, 1)
+		if err != nil {
+			return err
+		}
+		return userResetPassword(ctx, rest[0])
+	case "promote":
+		fs := flags("user promote")
+		demote := fs.Bool("demote", false, "take admin away instead")
+		rest, err := parse(fs, args, 1)
+		if err != nil {
+			return err
+		}
+		return userPromote(ctx, rest[0], !*demote)
+	}
+	return errUsage
+}
+
+func userCreate(ctx context.Context, name string, admin bool) error {
+	name = strings.TrimSpace(name)
+	if name == domain.GuestName {
+		return fmt.Errorf("%s is reserved", domain.GuestName)
+	}
+	u, err := dao.GetUserByName(ctx, name)
+	if err != nil {
+		return err
+	}
+	if u != nil {
+		return fmt.Errorf("%s already has an account", name)
+	}
+	password, err := readPassword()
+	if err != nil {
+		return err
+	}
+	// accounts made here skip the signup mode, they're made by an admin
+	u = domain.NewUser(ctx, name, password, admin)
+	if err := jobs.CreateUser(ctx, u); err != nil {
+		return err
+	}
+	fmt.Printf("created %s\n", name)
+	return nil
+}
+
+func userResetPassword(ctx context.Context, name string) error {
+	u, err := userByName(ctx, name)
+	if err != nil {
+		return err
+	}
+	password, err := readPassword()
+	if err != nil {
+		return err
+	}
+	if err := u.SetPassword(password); err != nil {
+		return err
+	}
+	if err := dao.SetUser(ctx, u); err != nil {
+		return err
+	}
+	// anyone using the old password is logged out
+	if err := dao.RevokeSessions(ctx, u.ID); err != nil {
+		return err
+	}
+	fmt.Printf("reset %s's password and logged them out everywhere\n", u.Name)
+	return nil
+}
+
+func userPromote(ctx context.Context, name string, admin bool) error {
+	u, err := userByName(ctx, name)
+	if err != nil {
+		return err
+	}
+	u.IsAdmin = admin
+	if err := dao.SetUser(ctx, u); err != nil {
+		return err
+	}
+	if admin {
+		fmt.Printf("%s is an admin\n", u.Name)
+	} else {
+		fmt.Printf("%s isn't an admin\n", u.Name)
+	}
+	return nil
+}
+
+func userByName(ctx context.Context, name string) (*domain.User, error) {
+	u, err := dao.GetUserByName(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		return nil, fmt.Errorf("no user %s", name)
+	}
+	return u, nil
+}
+
+// readPassword reads a password from stdin, so it doesn't end up in shell
+// history or the process list. At a terminal it isn't echoed, otherwise
+// it's the first line of what's piped in.
+func readPassword() (string, error) {
+	fmt.Fprint(os.Stderr, "Password: ")
+	var password string
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		b, err := term.ReadPassword(fd)
+		// the enter wasn't echoed either
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", fmt.Errorf("error reading password: %w", err)
+		}
+		password = string(b)
+	} else {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return "", fmt.Errorf("error reading password: %w", err)
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+	if len(password) < domain.MinPasswordLength {
+		return "", fmt.Errorf("passwords must be at least %d characters", domain.MinPasswordLength)
+	}
+	return password, nil
+}
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/RusticPotatoes/news/dao"
+	"github.com/RusticPotatoes/news/domain"
 	"github.com/RusticPotatoes/news/pkg/util"
 	"github.com/monzo/slog"
 	// "github.com/pacedotdev/firesearch-sdk/clients/go/firesearch"
@@ -20,7 +21,12 @@ func main() {
 	logger = util.ColourLogger{Writer: os.Stdout}
 	slog.SetDefaultLogger(logger)
 
-	err := dao.Init(ctx)
+	cfg, err := domain.LoadConfig(os.Getenv("NEWS_CONFIG"))
+	if err != nil {
+		slog.Critical(ctx, "Error loading config: %s", err)
+		return
+	}
+	err = dao.Init(ctx, cfg.DatabasePath())
 	if err != nil {
 		slog.Critical(ctx, "Error setting up dao: %s", err)
 		return
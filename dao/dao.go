@@ -6,12 +6,12 @@ import (
 	"fmt"
 	"log"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/pkg/errors"
-	"golang.org/x/sync/errgroup"
 
 	"database/sql"
 
@@ -22,19 +22,25 @@ import (
 )
 
 var (
-	db           *sql.DB
+	db           *instrumentedDB
 	mu           sync.RWMutex
 	ArticleCache *feedCache
 )
 
-func Init(ctx context.Context) error {
-	var err error
-	db, err = sql.Open("sqlite3", "./data/news.db")
+// Init opens the database at path, adding the admin user if there isn't
+// one yet
+func Init(ctx context.Context, path string) error {
+	err := Open(path)
 	if err != nil {
 		_, file, line, _ := runtime.Caller(1)
 		return fmt.Errorf("error in %s:%d: %v", file, line, err)
 	}
+	return Setup(ctx)
+}
 
+// Setup adds the admin user to an open database if there isn't one yet,
+// the schema has to be up to date
+func Setup(ctx context.Context) error {
 	admin, err := GetUserByName(ctx, "admin")
 	if err != nil {
 		log.Printf("Error getting admin user: %s", err)
@@ -54,9 +60,21 @@ func Init(ctx context.Context) error {
 
 	return nil
 }
+
+// Open opens the database without setting anything up, for tools that work
+// on databases the server can't use yet, like ones that need migrating
+func Open(path string) error {
+	sqlDB, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return err
+	}
+	db = &instrumentedDB{sqlDB}
+	return nil
+}
+
 func initNewUsers(ctx context.Context, username, password string, isAdmin bool) error {
 	// Prevent the creation of a guest user
-	if username == "guest" {
+	if username == domain.GuestName {
 		log.Printf("Error: guest user cannot be created")
 		return errors.New("guest user is not allowed")
 	}
@@ -195,6 +213,9 @@ type storedSource struct {
 	DisableFetch 	bool
 	LastFetchTime 	time.Time
 	LayoutID    	string
+	Weight      	float64
+	MaxArticles 	int
+	PinSection  	string
 }
 
 type User struct {
@@ -222,7 +243,7 @@ func Close() {
 }
 
 func Client() *sql.DB {
-    return db
+    return db.DB
 }
 
 func HashPassword(password string) ([]byte, error) {
@@ -280,7 +301,7 @@ func InitSources(ctx context.Context) error {
 }
 
 func GetEditionForTime(ctx context.Context, t time.Time, allowRecent bool) (*domain.Edition, error) {
-	rows, err := db.Query("SELECT * FROM edition WHERE EndTime > ? ORDER BY EndTime DESC", t)
+	rows, err := db.Query("SELECT id, name, date, start_time, end_time, created, sources, articles, categories, metadata FROM edition WHERE end_time > ? ORDER BY end_time DESC", t)
 	if err != nil {
 		return nil, err
 	}
@@ -373,6 +394,13 @@ func SetEdition(ctx context.Context, e *domain.Edition) error {
 		tx.Rollback()
 		return err
 	}
+	if e.ID == "" {
+		err = tx.QueryRow("SELECT id FROM edition WHERE name = ? AND date = ?", stored.Name, stored.Date).Scan(&e.ID)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
 	return tx.Commit()
 }
 
@@ -455,11 +483,10 @@ func editionFromStored(ctx context.Context, s storedEdition) (*domain.Edition, e
 }
 
 func GetEdition(ctx context.Context, id string) (*domain.Edition, error) {
-	row := db.QueryRow("SELECT * FROM edition WHERE ID = ?", id)
+	row := db.QueryRow("SELECT id, name, date, start_time, end_time, created, sources, articles, categories, metadata FROM edition WHERE id = ?", id)
 
 	var s storedEdition
-	var sources, articles, categories, metadata string
-	err := row.Scan(&s.ID, &s.Name, &s.Date, &s.StartTime, &s.EndTime, &s.Created, &sources, &articles, &categories, &metadata)
+	err := row.Scan(&s.ID, &s.Name, &s.Date, &s.StartTime, &s.EndTime, &s.Created, &s.Sources, &s.Articles, &s.Categories, &s.Metadata)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			// No matching edition found
@@ -468,61 +495,33 @@ func GetEdition(ctx context.Context, id string) (*domain.Edition, error) {
 		return nil, err
 	}
 
-	var srcs []domain.Source
-	err = json.Unmarshal([]byte(sources), &srcs)
-	if err != nil {
-		return nil, err
-	}
-
-	var arts []string
-	err = json.Unmarshal([]byte(articles), &arts)
-	if err != nil {
-		return nil, err
-	}
-
-	var cats []string
-	err = json.Unmarshal([]byte(categories), &cats)
-	if err != nil {
-		return nil, err
-	}
+	// editions store a snapshot of their articles, so there's
+	// no need to go back to the articles table for them
+	return editionFromStored(ctx, s)
+}
 
-	var meta map[string]string
-	err = json.Unmarshal([]byte(metadata), &meta)
+// GetEditions returns the most recently created editions, newest first
+func GetEditions(ctx context.Context, limit int) ([]domain.Edition, error) {
+	rows, err := db.QueryContext(ctx, "SELECT id, name, date, start_time, end_time, created, sources, articles, categories, metadata FROM edition ORDER BY created DESC LIMIT ?", limit)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	e := domain.Edition{
-		ID:         s.ID,
-		Name:       s.Name,
-		Date:       s.Date,
-		Sources:    srcs,
-		StartTime:  s.StartTime,
-		EndTime:    s.EndTime,
-		Created:    s.Created,
-		Categories: cats,
-		Metadata:   meta,
-	}
-
-	e.Articles = make([]domain.Article, len(arts))
-	g := errgroup.Group{}
-	for i, id := range arts {
-		i, id := i, id
-		g.Go(func() error {
-			a, err := GetArticle(ctx, id)
-			if err != nil {
-				return err
-			}
-			e.Articles[i] = *a
-			return nil
-		})
-	}
-	err = g.Wait()
-	if err != nil {
-		return nil, err
+	editions := []domain.Edition{}
+	for rows.Next() {
+		var s storedEdition
+		err = rows.Scan(&s.ID, &s.Name, &s.Date, &s.StartTime, &s.EndTime, &s.Created, &s.Sources, &s.Articles, &s.Categories, &s.Metadata)
+		if err != nil {
+			return nil, err
+		}
+		e, err := editionFromStored(ctx, s)
+		if err != nil {
+			return nil, err
+		}
+		editions = append(editions, *e)
 	}
-
-	return &e, nil
+	return editions, rows.Err()
 }
 
 func SetArticle(ctx context.Context, a *domain.Article) error {
@@ -531,9 +530,15 @@ func SetArticle(ctx context.Context, a *domain.Article) error {
 		return err
 	}
 
+	summaries, err := summariesToStored(a.Summaries)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	_, err = tx.Exec(`
-		INSERT INTO articles (title, description, compressed_content, image_url, link, author, source_id, timestamp, ts, layout_id) 
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?) 
+		INSERT INTO articles (title, description, compressed_content, image_url, link, author, source_id, timestamp, ts, layout_id, summaries, tags) 
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) 
 		ON CONFLICT(link) DO UPDATE SET 
 			title = excluded.title, 
 			description = excluded.description, 
@@ -544,7 +549,9 @@ func SetArticle(ctx context.Context, a *domain.Article) error {
 			source_id = excluded.source_id, 
 			timestamp = excluded.timestamp, 
 			ts = excluded.ts, 
-			layout_id = excluded.layout_id
+			layout_id = excluded.layout_id,
+			summaries = excluded.summaries,
+			tags = excluded.tags
 	`, 
 		a.Title, 
 		a.Description, 
@@ -556,11 +563,21 @@ func SetArticle(ctx context.Context, a *domain.Article) error {
 		a.Timestamp, 
 		a.TS, 
 		a.LayoutID, // Assuming a.LayoutID holds the layout ID
+		summaries,
+		strings.Join(a.Tags, ","),
 	)
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
+	if a.ID == "" {
+		// fill in the ID so callers can link to the article they stored
+		err = tx.QueryRow("SELECT id FROM articles WHERE link = ?", a.Link).Scan(&a.ID)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
 
 	mu.Lock()
 	delete(ArticleCache.items, a.ID)
@@ -568,6 +585,43 @@ func SetArticle(ctx context.Context, a *domain.Article) error {
 	return tx.Commit()
 }
 
+// HasArticleImage reports whether url is the image of a stored article
+func HasArticleImage(ctx context.Context, url string) (bool, error) {
+	var exists bool
+	err := db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM articles WHERE image_url = ?)", url).Scan(&exists)
+	return exists, err
+}
+
+func summariesToStored(summaries map[int]string) (string, error) {
+	if len(summaries) == 0 {
+		return "", nil
+	}
+	buf, err := json.Marshal(summaries)
+	if err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+func summariesFromStored(s sql.NullString) (map[int]string, error) {
+	if !s.Valid || s.String == "" {
+		return nil, nil
+	}
+	summaries := make(map[int]string)
+	err := json.Unmarshal([]byte(s.String), &summaries)
+	if err != nil {
+		return nil, err
+	}
+	return summaries, nil
+}
+
+func tagsFromStored(s sql.NullString) []string {
+	if !s.Valid || s.String == "" {
+		return nil
+	}
+	return strings.Split(s.String, ",")
+}
+
 func GetArticle(ctx context.Context, id string) (*domain.Article, error) {
 	mu.RLock()
 	a, ok := ArticleCache.items[id]
@@ -577,10 +631,11 @@ func GetArticle(ctx context.Context, id string) (*domain.Article, error) {
 	}
 	mu.RUnlock()
 
-	row := db.QueryRow("SELECT id, title, description, compressed_content, image_url, link, author, timestamp, ts FROM articles WHERE ID = ?", id)
+	row := db.QueryRow("SELECT id, title, description, compressed_content, image_url, link, author, timestamp, ts, summaries, tags FROM articles WHERE ID = ?", id)
 
 	a = domain.Article{}
-	err := row.Scan(&a.ID, &a.Title, &a.Description, &a.CompressedContent, &a.ImageURL, &a.Link, &a.Author, &a.Timestamp, &a.TS)
+	var summaries, tags sql.NullString
+	err := row.Scan(&a.ID, &a.Title, &a.Description, &a.CompressedContent, &a.ImageURL, &a.Link, &a.Author, &a.Timestamp, &a.TS, &summaries, &tags)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			// No matching article found
@@ -588,6 +643,11 @@ func GetArticle(ctx context.Context, id string) (*domain.Article, error) {
 		}
 		return nil, err
 	}
+	a.Summaries, err = summariesFromStored(summaries)
+	if err != nil {
+		return nil, err
+	}
+	a.Tags = tagsFromStored(tags)
 
 	mu.Lock()
 	ArticleCache.items[a.ID] = a
@@ -704,36 +764,114 @@ func SearchInSQLite(ctx context.Context, query string) ([]domain.Article, error)
 	return articles, nil
 }
 
-func GetUser(ctx context.Context, id string) (*domain.User, error) {
-	row := db.QueryRow("SELECT id, name, password_hash FROM users WHERE ID = ?", id)
+const userColumns = "id, name, created, password_hash, is_admin, last_login, pending, email"
 
-	u := domain.User{}
-	err := row.Scan(&u.ID, &u.Name, &u.PasswordHash)
+func scanUser(row interface{ Scan(...interface{}) error }) (*domain.User, error) {
+	var (
+		u                  domain.User
+		created, lastLogin sql.NullTime
+		isAdmin, pending   sql.NullBool
+		email              sql.NullString
+	)
+	err := row.Scan(&u.ID, &u.Name, &created, &u.PasswordHash, &isAdmin, &lastLogin, &pending, &email)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			// No matching user found
-			return nil, nil
-		}
 		return nil, err
 	}
-
+	u.Created, u.IsAdmin, u.LastLogin, u.Pending = created.Time, isAdmin.Bool, lastLogin.Time, pending.Bool
+	u.Email = email.String
 	return &u, nil
 }
 
+func GetUser(ctx context.Context, id string) (*domain.User, error) {
+	row := db.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE ID = ?", id)
+
+	u, err := scanUser(row)
+	if err == sql.ErrNoRows {
+		// No matching user found
+		return nil, nil
+	}
+	return u, err
+}
+
 func GetUserByName(ctx context.Context, name string) (*domain.User, error) {
-	row := db.QueryRow("SELECT id, name, password_hash FROM users WHERE Name = ?", name)
+	row := db.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE Name = ?", name)
 
-	u := domain.User{}
-	err := row.Scan(&u.ID, &u.Name, &u.PasswordHash)
+	u, err := scanUser(row)
+	if err == sql.ErrNoRows {
+		// No matching user found
+		return nil, nil
+	}
+	return u, err
+}
+
+// GetUserByEmail returns the user with a single sign-on address, or nil
+func GetUserByEmail(ctx context.Context, email string) (*domain.User, error) {
+	row := db.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE email = ?", email)
+
+	u, err := scanUser(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return u, err
+}
+
+// GetUsers returns every user, by name
+func GetUsers(ctx context.Context) ([]domain.User, error) {
+	rows, err := db.QueryContext(ctx, "SELECT "+userColumns+" FROM users ORDER BY name")
 	if err != nil {
-		if err == sql.ErrNoRows {
-			// No matching user found
-			return nil, nil
-		}
 		return nil, err
 	}
+	defer rows.Close()
+
+	users := []domain.User{}
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *u)
+	}
+	return users, rows.Err()
+}
 
-	return &u, nil
+// SetUserLastLogin records when the user last logged in
+func SetUserLastLogin(ctx context.Context, id string, t time.Time) error {
+	_, err := db.ExecContext(ctx, "UPDATE users SET last_login = ? WHERE id = ?", t, id)
+	return err
+}
+
+// DeleteUser removes a user along with their sources, the articles and
+// fetch errors of those, and their settings, sessions and API tokens.
+// Editions keep their own copy of the articles they were built from.
+func DeleteUser(ctx context.Context, u *domain.User) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	stmts := []struct {
+		query string
+		arg   string
+	}{
+		{"DELETE FROM webhook_deliveries WHERE owner_id = ?", u.ID},
+		{"DELETE FROM webhooks WHERE owner_id = ?", u.ID},
+		{"DELETE FROM deliveries WHERE owner_id = ?", u.ID},
+		{"DELETE FROM rules WHERE owner_id = ?", u.ID},
+		{"DELETE FROM sessions WHERE user_id = ?", u.ID},
+		{"DELETE FROM api_tokens WHERE user_id = ?", u.ID},
+		// sources are keyed by name, and foreign keys aren't enforced
+		{"DELETE FROM articles WHERE source_id IN (SELECT id FROM sources WHERE owner_id = ?)", u.Name},
+		{"DELETE FROM fetch_errors WHERE source_id IN (SELECT id FROM sources WHERE owner_id = ?)", u.Name},
+		{"DELETE FROM sources WHERE owner_id = ?", u.Name},
+		{"DELETE FROM users WHERE id = ?", u.ID},
+	}
+	for _, st := range stmts {
+		_, err = tx.ExecContext(ctx, st.query, st.arg)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
 }
 
 func SetUser(ctx context.Context, u *domain.User) error {
@@ -742,17 +880,28 @@ func SetUser(ctx context.Context, u *domain.User) error {
 		return err
 	}
 
+	// the address is unique, so accounts without one have to store NULL
+	var email interface{}
+	if u.Email != "" {
+		email = u.Email
+	}
+
 	_, err = tx.Exec(`
-		INSERT INTO users (name, password_hash, is_admin) 
-		VALUES (?, ?, ?) 
+		INSERT INTO users (name, created, password_hash, is_admin, pending, email) 
+		VALUES (?, ?, ?, ?, ?, ?) 
 		ON CONFLICT(name) DO UPDATE SET 
 		name = excluded.name, 
 		password_hash = excluded.password_hash, 
-		is_admin = excluded.is_admin
+		is_admin = excluded.is_admin,
+		pending = excluded.pending,
+		email = excluded.email
 	`, 
 		u.Name, 
+		u.Created, 
 		u.PasswordHash, 
 		u.IsAdmin,
+		u.Pending,
+		email,
 	)
 	if err != nil {
 		tx.Rollback()
@@ -763,10 +912,10 @@ func SetUser(ctx context.Context, u *domain.User) error {
 }
 
 func GetSource(ctx context.Context, id string) (*domain.Source, error) {
-	row := db.QueryRow("SELECT id, owner_id, name, url, feed_url, categories, disable_fetch FROM sources WHERE ID = ?", id)
+	row := db.QueryRow("SELECT id, owner_id, name, url, feed_url, categories, disable_fetch, weight, max_articles, pin_section FROM sources WHERE ID = ?", id)
 
 	var s storedSource
-	err := row.Scan(&s.ID, &s.OwnerID, &s.Name, &s.URL, &s.FeedURL, &s.Categories, &s.DisableFetch)
+	err := row.Scan(&s.ID, &s.OwnerID, &s.Name, &s.URL, &s.FeedURL, &s.Categories, &s.DisableFetch, &s.Weight, &s.MaxArticles, &s.PinSection)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			// No matching source found
@@ -783,6 +932,9 @@ func GetSource(ctx context.Context, id string) (*domain.Source, error) {
 		FeedURL:      s.FeedURL,
 		Categories:   strings.Split(s.Categories, ","),
 		DisableFetch: s.DisableFetch,
+		Weight:       s.Weight,
+		MaxArticles:  s.MaxArticles,
+		PinSection:   s.PinSection,
 	}
 
 	return &source, nil
@@ -806,16 +958,19 @@ func SetSource(ctx context.Context, s *domain.Source) error {
 		// s.OwnerID, s.Name, s.URL, s.FeedURL, categories, s.DisableFetch)
 
 	_, err = tx.Exec(`
-		INSERT INTO sources (owner_id, name, url, feed_url, categories, disable_fetch) 
-		VALUES (?, ?, ?, ?, ?, ?) 
+		INSERT INTO sources (owner_id, name, url, feed_url, categories, disable_fetch, weight, max_articles, pin_section) 
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?) 
 		ON CONFLICT(owner_id, url) DO UPDATE SET 
 		owner_id = excluded.owner_id, 
 		name = excluded.name, 
 		url = excluded.url, 
 		feed_url = excluded.feed_url, 
 		categories = excluded.categories, 
-		disable_fetch = excluded.disable_fetch
-	`, s.OwnerID, s.Name, s.URL, s.FeedURL, categories, s.DisableFetch)
+		disable_fetch = excluded.disable_fetch,
+		weight = excluded.weight,
+		max_articles = excluded.max_articles,
+		pin_section = excluded.pin_section
+	`, s.OwnerID, s.Name, s.URL, s.FeedURL, categories, s.DisableFetch, s.EffectiveWeight(), s.MaxArticles, s.PinSection)
 	if err != nil {
 		log.Printf("Error inserting into sources: %v", err)
 		tx.Rollback()
@@ -831,24 +986,16 @@ func DeleteSource(ctx context.Context, id string) error {
 }
 
 func GetSources(ctx context.Context, ownerID string) ([]domain.Source, error) {
-	sqlQuery := fmt.Sprintf("SELECT id, owner_id, name, url, feed_url, categories, disable_fetch FROM sources WHERE owner_id = '%s'", ownerID)
-	fmt.Println(sqlQuery)
-
-	rows, err := db.Query(sqlQuery)
+	rows, err := db.Query("SELECT id, owner_id, name, url, feed_url, categories, disable_fetch, weight, max_articles, pin_section FROM sources WHERE owner_id = ?", ownerID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	if rows == nil {
-		fmt.Println("rows is nil")
-		return nil, errors.New("rows is nil")
-	}
-	
-	fmt.Println(rows)
+
 	sources := []domain.Source{}
 	for rows.Next() {
 		var s storedSource
-		err = rows.Scan(&s.ID, &s.OwnerID, &s.Name, &s.URL, &s.FeedURL, &s.Categories, &s.DisableFetch)
+		err = rows.Scan(&s.ID, &s.OwnerID, &s.Name, &s.URL, &s.FeedURL, &s.Categories, &s.DisableFetch, &s.Weight, &s.MaxArticles, &s.PinSection)
 		if err != nil {
 			return nil, err
 		}
@@ -861,6 +1008,9 @@ func GetSources(ctx context.Context, ownerID string) ([]domain.Source, error) {
 			FeedURL:     s.FeedURL,
 			Categories:  strings.Split(s.Categories, ","),
 			DisableFetch: s.DisableFetch,
+			Weight:      s.Weight,
+			MaxArticles: s.MaxArticles,
+			PinSection:  s.PinSection,
 		}
 
 		sources = append(sources, source)
@@ -874,7 +1024,7 @@ func GetSources(ctx context.Context, ownerID string) ([]domain.Source, error) {
 }
 
 func GetAllSources(ctx context.Context) ([]domain.Source, error) {
-	rows, err := db.Query("SELECT id, owner_id, name, url, feed_url, categories, disable_fetch, last_fetch_time FROM sources")
+	rows, err := db.Query("SELECT id, owner_id, name, url, feed_url, categories, disable_fetch, last_fetch_time, weight, max_articles, pin_section FROM sources")
 	if err != nil {
 		return nil, err
 	}
@@ -883,7 +1033,7 @@ func GetAllSources(ctx context.Context) ([]domain.Source, error) {
 	sources := []domain.Source{}
 	for rows.Next() {
 		var s storedSource
-		err = rows.Scan(&s.ID, &s.OwnerID, &s.Name, &s.URL, &s.FeedURL, &s.Categories, &s.DisableFetch, &s.LastFetchTime)
+		err = rows.Scan(&s.ID, &s.OwnerID, &s.Name, &s.URL, &s.FeedURL, &s.Categories, &s.DisableFetch, &s.LastFetchTime, &s.Weight, &s.MaxArticles, &s.PinSection)
 		if err != nil {
 			return nil, err
 		}
@@ -897,6 +1047,9 @@ func GetAllSources(ctx context.Context) ([]domain.Source, error) {
 			Categories:    strings.Split(s.Categories, ","),
 			DisableFetch:  s.DisableFetch,
 			LastFetchTime: s.LastFetchTime,
+			Weight:        s.Weight,
+			MaxArticles:   s.MaxArticles,
+			PinSection:    s.PinSection,
 		}
 
 		sources = append(sources, source)
@@ -910,7 +1063,7 @@ func GetAllSources(ctx context.Context) ([]domain.Source, error) {
 }
 
 func GetAllSourcesForOwner(ctx context.Context, ownerID string) ([]domain.Source, error) {
-	query := "SELECT id, owner_id, name, url, feed_url, categories, disable_fetch, last_fetch_time FROM sources WHERE owner_id = ?"
+	query := "SELECT id, owner_id, name, url, feed_url, categories, disable_fetch, last_fetch_time, weight, max_articles, pin_section FROM sources WHERE owner_id = ?"
 	rows, err := db.QueryContext(ctx, query, ownerID)
 	if err != nil {
 		return nil, err
@@ -920,7 +1073,7 @@ func GetAllSourcesForOwner(ctx context.Context, ownerID string) ([]domain.Source
 	sources := []domain.Source{}
 	for rows.Next() {
 		var s storedSource
-		err = rows.Scan(&s.ID, &s.OwnerID, &s.Name, &s.URL, &s.FeedURL, &s.Categories, &s.DisableFetch, &s.LastFetchTime)
+		err = rows.Scan(&s.ID, &s.OwnerID, &s.Name, &s.URL, &s.FeedURL, &s.Categories, &s.DisableFetch, &s.LastFetchTime, &s.Weight, &s.MaxArticles, &s.PinSection)
 		if err != nil {
 			return nil, err
 		}
@@ -934,6 +1087,9 @@ func GetAllSourcesForOwner(ctx context.Context, ownerID string) ([]domain.Source
 			Categories:    strings.Split(s.Categories, ","),
 			DisableFetch:  s.DisableFetch,
 			LastFetchTime: s.LastFetchTime,
+			Weight:        s.Weight,
+			MaxArticles:   s.MaxArticles,
+			PinSection:    s.PinSection,
 		}
 
 		sources = append(sources, source)
@@ -946,6 +1102,570 @@ func GetAllSourcesForOwner(ctx context.Context, ownerID string) ([]domain.Source
 	return sources, nil
 }
 
+func GetRules(ctx context.Context, ownerID string) ([]domain.Rule, error) {
+	rows, err := db.QueryContext(ctx, "SELECT id, owner_id, field, pattern, action, created FROM rules WHERE owner_id = ? ORDER BY created", ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []domain.Rule{}
+	for rows.Next() {
+		var r domain.Rule
+		err = rows.Scan(&r.ID, &r.OwnerID, &r.Field, &r.Pattern, &r.Action, &r.Created)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+func SetRule(ctx context.Context, r *domain.Rule) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO rules (owner_id, field, pattern, action, created) 
+		VALUES (?, ?, ?, ?, ?)
+	`, r.OwnerID, r.Field, r.Pattern, r.Action, r.Created)
+	return err
+}
+
+func DeleteRule(ctx context.Context, ownerID, id string) error {
+	_, err := db.ExecContext(ctx, "DELETE FROM rules WHERE id = ? AND owner_id = ?", id, ownerID)
+	return err
+}
+
+const deliveryColumns = "owner_id, enabled, email, kindle_email, format, send_time, last_sent, email_sent, kindle_sent, failures, retry_at, last_error, email_confirmed, kindle_confirmed"
+
+// GetDelivery returns the reader's email delivery settings, or nil if they
+// haven't set any up
+func GetDelivery(ctx context.Context, ownerID string) (*domain.Delivery, error) {
+	row := db.QueryRowContext(ctx, "SELECT "+deliveryColumns+" FROM deliveries WHERE owner_id = ?", ownerID)
+	d, err := scanDelivery(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return d, err
+}
+
+// GetEnabledDeliveries returns everyone who has opted in to email delivery
+func GetEnabledDeliveries(ctx context.Context) ([]domain.Delivery, error) {
+	rows, err := db.QueryContext(ctx, "SELECT "+deliveryColumns+" FROM deliveries WHERE enabled = 1")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []domain.Delivery{}
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, *d)
+	}
+	return deliveries, rows.Err()
+}
+
+func scanDelivery(row interface{ Scan(...interface{}) error }) (*domain.Delivery, error) {
+	var (
+		d                                        domain.Delivery
+		lastSent, emailSent, kindleSent, retryAt sql.NullTime
+	)
+	err := row.Scan(&d.OwnerID, &d.Enabled, &d.Email, &d.KindleEmail, &d.Format, &d.SendTime, &lastSent, &emailSent, &kindleSent, &d.Failures, &retryAt, &d.LastError, &d.EmailConfirmed, &d.KindleConfirmed)
+	if err != nil {
+		return nil, err
+	}
+	d.LastSent = lastSent.Time
+	d.EmailSent = emailSent.Time
+	d.KindleSent = kindleSent.Time
+	d.RetryAt = retryAt.Time
+	return &d, nil
+}
+
+// SetDelivery stores a reader's delivery settings, saving them clears any
+// failures so delivery starts again after it was turned off
+func SetDelivery(ctx context.Context, d *domain.Delivery) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO deliveries (owner_id, enabled, email, kindle_email, format, send_time)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(owner_id) DO UPDATE SET
+		enabled = excluded.enabled,
+		email = excluded.email,
+		kindle_email = excluded.kindle_email,
+		format = excluded.format,
+		send_time = excluded.send_time,
+		failures = 0,
+		retry_at = NULL,
+		last_error = ''
+	`, d.OwnerID, d.Enabled, d.Email, d.KindleEmail, d.Format, d.SendTime)
+	return err
+}
+
+// SetDeliverySent records when an edition was last sent to all of the
+// reader's targets, clearing any failures
+func SetDeliverySent(ctx context.Context, ownerID string, t time.Time) error {
+	_, err := db.ExecContext(ctx, "UPDATE deliveries SET last_sent = ?, failures = 0, retry_at = NULL, last_error = '' WHERE owner_id = ?", t, ownerID)
+	return err
+}
+
+// SetDeliveryTargetSent records when an edition was sent to one of the
+// reader's targets, so it isn't sent again if another target fails
+func SetDeliveryTargetSent(ctx context.Context, ownerID, target string, t time.Time) error {
+	column := "email_sent"
+	if target == domain.DeliveryTargetKindle {
+		column = "kindle_sent"
+	}
+	_, err := db.ExecContext(ctx, "UPDATE deliveries SET "+column+" = ? WHERE owner_id = ?", t, ownerID)
+	return err
+}
+
+// SetDeliveryFailed records a failed send, and turns delivery off if
+// Delivery.Failed did
+func SetDeliveryFailed(ctx context.Context, d *domain.Delivery) error {
+	_, err := db.ExecContext(ctx, "UPDATE deliveries SET enabled = ?, failures = ?, retry_at = ?, last_error = ? WHERE owner_id = ?", d.Enabled, d.Failures, d.RetryAt, d.LastError, d.OwnerID)
+	return err
+}
+
+// SetDeliveryConfirmed records that the reader confirmed address for
+// target. It returns false if target's address has changed since.
+func SetDeliveryConfirmed(ctx context.Context, c *domain.DeliveryConfirmation) (bool, error) {
+	query := "UPDATE deliveries SET email_confirmed = ? WHERE owner_id = ? AND email = ?"
+	if c.Target == domain.DeliveryTargetKindle {
+		query = "UPDATE deliveries SET kindle_confirmed = ? WHERE owner_id = ? AND kindle_email = ?"
+	}
+	res, err := db.ExecContext(ctx, query, c.Address, c.OwnerID, c.Address)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// SetDeliveryEnabled opts a reader in or out of email delivery
+func SetDeliveryEnabled(ctx context.Context, ownerID string, enabled bool) error {
+	_, err := db.ExecContext(ctx, "UPDATE deliveries SET enabled = ? WHERE owner_id = ?", enabled, ownerID)
+	return err
+}
+
+const sessionColumns = "id, user_id, created, expires, last_seen, user_agent, revoked"
+
+// GetSession returns a stored session, or nil if there isn't one
+func GetSession(ctx context.Context, id string) (*domain.Session, error) {
+	sessions, err := querySessions(ctx, "SELECT "+sessionColumns+" FROM sessions WHERE id = ?", id)
+	if err != nil || len(sessions) == 0 {
+		return nil, err
+	}
+	return &sessions[0], nil
+}
+
+// GetSessions returns a user's sessions that are still live, most recently
+// used first
+func GetSessions(ctx context.Context, userID string, now time.Time) ([]domain.Session, error) {
+	return querySessions(ctx, "SELECT "+sessionColumns+" FROM sessions WHERE user_id = ? AND revoked = 0 AND expires > ? ORDER BY last_seen DESC", userID, now)
+}
+
+func querySessions(ctx context.Context, query string, args ...interface{}) ([]domain.Session, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []domain.Session{}
+	for rows.Next() {
+		var s domain.Session
+		err = rows.Scan(&s.ID, &s.UserID, &s.Created, &s.Expires, &s.LastSeen, &s.UserAgent, &s.Revoked)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+func SetSession(ctx context.Context, s *domain.Session) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO sessions (id, user_id, created, expires, last_seen, user_agent, revoked)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET
+		expires = excluded.expires,
+		last_seen = excluded.last_seen,
+		revoked = excluded.revoked
+	`, s.ID, s.UserID, s.Created, s.Expires, s.LastSeen, s.UserAgent, s.Revoked)
+	return err
+}
+
+// RevokeSession ends one of a user's sessions
+func RevokeSession(ctx context.Context, userID, id string) error {
+	_, err := db.ExecContext(ctx, "UPDATE sessions SET revoked = 1 WHERE id = ? AND user_id = ?", id, userID)
+	return err
+}
+
+// RevokeSessions ends all of a user's sessions, logging them out everywhere
+func RevokeSessions(ctx context.Context, userID string) error {
+	_, err := db.ExecContext(ctx, "UPDATE sessions SET revoked = 1 WHERE user_id = ?", userID)
+	return err
+}
+
+// RevokeOtherSessions ends all of a user's sessions but one, logging them
+// out everywhere else
+func RevokeOtherSessions(ctx context.Context, userID, keepID string) error {
+	_, err := db.ExecContext(ctx, "UPDATE sessions SET revoked = 1 WHERE user_id = ? AND id != ?", userID, keepID)
+	return err
+}
+
+// DeleteExpiredSessions removes sessions that can no longer be used
+func DeleteExpiredSessions(ctx context.Context) error {
+	_, err := db.ExecContext(ctx, "DELETE FROM sessions WHERE revoked = 1 OR expires < ?", time.Now())
+	return err
+}
+
+const apiTokenColumns = "id, user_id, name, hash, scopes, created, last_used, expires, revoked"
+
+// GetAPIToken returns a token by its ID, or nil if there isn't one
+func GetAPIToken(ctx context.Context, id string) (*domain.APIToken, error) {
+	tokens, err := queryAPITokens(ctx, "SELECT "+apiTokenColumns+" FROM api_tokens WHERE id = ?", id)
+	if err != nil || len(tokens) == 0 {
+		return nil, err
+	}
+	return &tokens[0], nil
+}
+
+// GetAPITokens returns a user's tokens that haven't been revoked, newest
+// first
+func GetAPITokens(ctx context.Context, userID string) ([]domain.APIToken, error) {
+	return queryAPITokens(ctx, "SELECT "+apiTokenColumns+" FROM api_tokens WHERE user_id = ? AND revoked = 0 ORDER BY created DESC", userID)
+}
+
+func queryAPITokens(ctx context.Context, query string, args ...interface{}) ([]domain.APIToken, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []domain.APIToken{}
+	for rows.Next() {
+		var (
+			t                 domain.APIToken
+			scopes            string
+			lastUsed, expires sql.NullTime
+		)
+		err = rows.Scan(&t.ID, &t.UserID, &t.Name, &t.Hash, &scopes, &t.Created, &lastUsed, &expires, &t.Revoked)
+		if err != nil {
+			return nil, err
+		}
+		t.Scopes = strings.Split(scopes, ",")
+		t.LastUsed, t.Expires = lastUsed.Time, expires.Time
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+func AddAPIToken(ctx context.Context, t *domain.APIToken) error {
+	var expires interface{}
+	if !t.Expires.IsZero() {
+		expires = t.Expires
+	}
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO api_tokens (id, user_id, name, hash, scopes, created, expires, revoked)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, t.ID, t.UserID, t.Name, t.Hash, strings.Join(t.Scopes, ","), t.Created, expires, t.Revoked)
+	return err
+}
+
+// SetAPITokenLastUsed records when a token was last used
+func SetAPITokenLastUsed(ctx context.Context, id string, t time.Time) error {
+	_, err := db.ExecContext(ctx, "UPDATE api_tokens SET last_used = ? WHERE id = ?", t, id)
+	return err
+}
+
+// RevokeAPIToken stops one of a user's tokens working
+func RevokeAPIToken(ctx context.Context, userID, id string) error {
+	_, err := db.ExecContext(ctx, "UPDATE api_tokens SET revoked = 1 WHERE id = ? AND user_id = ?", id, userID)
+	return err
+}
+
+func AddLoginAttempt(ctx context.Context, a *domain.LoginAttempt) error {
+	_, err := db.ExecContext(ctx, "INSERT INTO login_attempts (username, ip, success, created) VALUES (?, ?, ?, ?)", a.Username, a.IP, a.Success, a.Created)
+	return err
+}
+
+// maxCountedLogins bounds how many failures are read back, it's far more
+// than any lockout needs
+const maxCountedLogins = 1000
+
+// FailedLoginsForUser counts failed logins for a username since the later
+// of since and their last successful login, and returns the time of the
+// most recent
+func FailedLoginsForUser(ctx context.Context, username string, since time.Time) (int, time.Time, error) {
+	var lastSuccess time.Time
+	err := db.QueryRowContext(ctx, "SELECT created FROM login_attempts WHERE username = ? AND success = 1 ORDER BY created DESC LIMIT 1", username).Scan(&lastSuccess)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, time.Time{}, err
+	}
+	if lastSuccess.After(since) {
+		since = lastSuccess
+	}
+	return countFailedLogins(ctx, "SELECT created FROM login_attempts WHERE username = ? AND success = 0 AND created > ? ORDER BY created DESC LIMIT ?", username, since, maxCountedLogins)
+}
+
+// FailedLoginsForIP counts failed logins from an address since since, and
+// returns the time of the most recent. Successes don't reset the count,
+// anyone can log in to their own account.
+func FailedLoginsForIP(ctx context.Context, ip string, since time.Time) (int, time.Time, error) {
+	return countFailedLogins(ctx, "SELECT created FROM login_attempts WHERE ip = ? AND success = 0 AND created > ? ORDER BY created DESC LIMIT ?", ip, since, maxCountedLogins)
+}
+
+func countFailedLogins(ctx context.Context, query string, args ...interface{}) (int, time.Time, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	defer rows.Close()
+
+	var (
+		n    int
+		last time.Time
+	)
+	for rows.Next() {
+		var t time.Time
+		if err := rows.Scan(&t); err != nil {
+			return 0, time.Time{}, err
+		}
+		if n == 0 {
+			last = t
+		}
+		n++
+	}
+	return n, last, rows.Err()
+}
+
+// GetFailedLogins returns the most recent failed logins, newest first
+func GetFailedLogins(ctx context.Context, limit int) ([]domain.LoginAttempt, error) {
+	rows, err := db.QueryContext(ctx, "SELECT id, username, ip, success, created FROM login_attempts WHERE success = 0 ORDER BY created DESC LIMIT ?", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attempts := []domain.LoginAttempt{}
+	for rows.Next() {
+		var a domain.LoginAttempt
+		if err := rows.Scan(&a.ID, &a.Username, &a.IP, &a.Success, &a.Created); err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, a)
+	}
+	return attempts, rows.Err()
+}
+
+// ClearFailedLogins forgets a username's failed logins, lifting any
+// lockout on the account
+func ClearFailedLogins(ctx context.Context, username string) error {
+	_, err := db.ExecContext(ctx, "DELETE FROM login_attempts WHERE username = ? AND success = 0", username)
+	return err
+}
+
+// DeleteLoginAttempts removes login attempts older than age
+func DeleteLoginAttempts(ctx context.Context, age time.Duration) error {
+	_, err := db.ExecContext(ctx, "DELETE FROM login_attempts WHERE created < ?", time.Now().Add(-age))
+	return err
+}
+
+const inviteColumns = "code, created_by, created, expires, max_uses, uses"
+
+// GetInvites returns every invite, newest first
+func GetInvites(ctx context.Context) ([]domain.Invite, error) {
+	rows, err := db.QueryContext(ctx, "SELECT "+inviteColumns+" FROM invites ORDER BY created DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invites := []domain.Invite{}
+	for rows.Next() {
+		var (
+			i       domain.Invite
+			expires sql.NullTime
+		)
+		err = rows.Scan(&i.Code, &i.CreatedBy, &i.Created, &expires, &i.MaxUses, &i.Uses)
+		if err != nil {
+			return nil, err
+		}
+		i.Expires = expires.Time
+		invites = append(invites, i)
+	}
+	return invites, rows.Err()
+}
+
+func SetInvite(ctx context.Context, i *domain.Invite) error {
+	var expires interface{}
+	if !i.Expires.IsZero() {
+		expires = i.Expires
+	}
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO invites (code, created_by, created, expires, max_uses, uses)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, i.Code, i.CreatedBy, i.Created, expires, i.MaxUses, i.Uses)
+	return err
+}
+
+func DeleteInvite(ctx context.Context, code string) error {
+	_, err := db.ExecContext(ctx, "DELETE FROM invites WHERE code = ?", code)
+	return err
+}
+
+// UseInvite counts a use of an invite, it returns false if the invite
+// doesn't exist, has expired or has been used up. The check and the count
+// are one statement so an invite can't be used more than it allows.
+func UseInvite(ctx context.Context, code string, now time.Time) (bool, error) {
+	res, err := db.ExecContext(ctx, `
+		UPDATE invites SET uses = uses + 1
+		WHERE code = ?
+		AND (expires IS NULL OR expires > ?)
+		AND (max_uses = 0 OR uses < max_uses)
+	`, code, now)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+const webhookColumns = "id, owner_id, url, secret, event, field, pattern, created"
+
+func GetWebhooks(ctx context.Context, ownerID string) ([]domain.Webhook, error) {
+	return queryWebhooks(ctx, "SELECT "+webhookColumns+" FROM webhooks WHERE owner_id = ? ORDER BY created", ownerID)
+}
+
+// GetWebhooksForEvent returns every reader's webhooks for an event
+func GetWebhooksForEvent(ctx context.Context, event string) ([]domain.Webhook, error) {
+	return queryWebhooks(ctx, "SELECT "+webhookColumns+" FROM webhooks WHERE event = ?", event)
+}
+
+func GetWebhook(ctx context.Context, id string) (*domain.Webhook, error) {
+	hooks, err := queryWebhooks(ctx, "SELECT "+webhookColumns+" FROM webhooks WHERE id = ?", id)
+	if err != nil || len(hooks) == 0 {
+		return nil, err
+	}
+	return &hooks[0], nil
+}
+
+func queryWebhooks(ctx context.Context, query string, args ...interface{}) ([]domain.Webhook, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hooks := []domain.Webhook{}
+	for rows.Next() {
+		var h domain.Webhook
+		err = rows.Scan(&h.ID, &h.OwnerID, &h.URL, &h.Secret, &h.Event, &h.Field, &h.Pattern, &h.Created)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, h)
+	}
+	return hooks, rows.Err()
+}
+
+func SetWebhook(ctx context.Context, h *domain.Webhook) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO webhooks (owner_id, url, secret, event, field, pattern, created)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, h.OwnerID, h.URL, h.Secret, h.Event, h.Field, h.Pattern, h.Created)
+	return err
+}
+
+func DeleteWebhook(ctx context.Context, ownerID, id string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	res, err := tx.ExecContext(ctx, "DELETE FROM webhooks WHERE id = ? AND owner_id = ?", id, ownerID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		_, err = tx.ExecContext(ctx, "DELETE FROM webhook_deliveries WHERE webhook_id = ?", id)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+const webhookDeliveryColumns = "id, webhook_id, owner_id, event, payload, status, attempts, status_code, error, next_attempt, created, updated"
+
+// SetWebhookDelivery stores a new delivery, filling in its ID, or updates
+// an existing one after an attempt
+func SetWebhookDelivery(ctx context.Context, d *domain.WebhookDelivery) error {
+	if d.ID != "" {
+		_, err := db.ExecContext(ctx, `
+			UPDATE webhook_deliveries SET status = ?, attempts = ?, status_code = ?, error = ?, next_attempt = ?, updated = ?
+			WHERE id = ?
+		`, d.Status, d.Attempts, d.StatusCode, d.Error, d.NextAttempt, d.Updated, d.ID)
+		return err
+	}
+	res, err := db.ExecContext(ctx, `
+		INSERT INTO webhook_deliveries (webhook_id, owner_id, event, payload, status, attempts, status_code, error, next_attempt, created, updated)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, d.WebhookID, d.OwnerID, d.Event, d.Payload, d.Status, d.Attempts, d.StatusCode, d.Error, d.NextAttempt, d.Created, d.Updated)
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	d.ID = strconv.FormatInt(id, 10)
+	return nil
+}
+
+// GetDueWebhookDeliveries returns pending deliveries whose next attempt is
+// due
+func GetDueWebhookDeliveries(ctx context.Context, now time.Time) ([]domain.WebhookDelivery, error) {
+	return queryWebhookDeliveries(ctx, "SELECT "+webhookDeliveryColumns+" FROM webhook_deliveries WHERE status = ? AND next_attempt <= ? ORDER BY next_attempt", domain.WebhookPending, now)
+}
+
+// GetWebhookDeliveries returns a reader's most recent deliveries, for the
+// delivery log
+func GetWebhookDeliveries(ctx context.Context, ownerID string, limit int) ([]domain.WebhookDelivery, error) {
+	return queryWebhookDeliveries(ctx, "SELECT "+webhookDeliveryColumns+" FROM webhook_deliveries WHERE owner_id = ? ORDER BY created DESC, id DESC LIMIT ?", ownerID, limit)
+}
+
+func queryWebhookDeliveries(ctx context.Context, query string, args ...interface{}) ([]domain.WebhookDelivery, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []domain.WebhookDelivery{}
+	for rows.Next() {
+		var (
+			d           domain.WebhookDelivery
+			nextAttempt sql.NullTime
+		)
+		err = rows.Scan(&d.ID, &d.WebhookID, &d.OwnerID, &d.Event, &d.Payload, &d.Status, &d.Attempts, &d.StatusCode, &d.Error, &nextAttempt, &d.Created, &d.Updated)
+		if err != nil {
+			return nil, err
+		}
+		d.NextAttempt = nextAttempt.Time
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
 func GetArticlesForOwner(ctx context.Context, ownerID string, start, end time.Time) ([]domain.Article, []domain.Source, error) {
 	var (
 		sources []domain.Source
@@ -965,7 +1685,7 @@ func GetArticlesForOwner(ctx context.Context, ownerID string, start, end time.Ti
 
 	out := []domain.Article{}
     for _, s := range sources {
-		sqlStatement := fmt.Sprintf("SELECT id, title, description, compressed_content, link, image_url, source_id, timestamp FROM articles WHERE source_id = '%s' AND timestamp > '%s' AND timestamp < '%s' ORDER BY timestamp", s.ID, start.Format(time.RFC3339), end.Format(time.RFC3339))
+		sqlStatement := fmt.Sprintf("SELECT id, title, description, compressed_content, link, image_url, source_id, timestamp, summaries, tags FROM articles WHERE source_id = '%s' AND timestamp > '%s' AND timestamp < '%s' ORDER BY timestamp", s.ID, start.Format(time.RFC3339), end.Format(time.RFC3339))
 		// log.Println(sqlStatement)
 	
 		rows, err := db.Query(sqlStatement)
@@ -978,11 +1698,17 @@ func GetArticlesForOwner(ctx context.Context, ownerID string, start, end time.Ti
 		// var rarticles readability.Article
 		for rows.Next() {
 			var a domain.Article
-			err = rows.Scan(&a.ID, &a.Title, &a.Description, &a.CompressedContent, &a.Link, &a.ImageURL, &a.SourceID, &a.Timestamp)
+			var summaries, tags sql.NullString
+			err = rows.Scan(&a.ID, &a.Title, &a.Description, &a.CompressedContent, &a.Link, &a.ImageURL, &a.SourceID, &a.Timestamp, &summaries, &tags)
 			if err != nil {
 				return nil, nil, err
 			}
 			a.Source = s
+			a.Summaries, err = summariesFromStored(summaries)
+			if err != nil {
+				return nil, nil, err
+			}
+			a.Tags = tagsFromStored(tags)
 
 			// Uncompress the content and add it to the Content field
 			uncompressedContent, err:=  domain.DecompressContent(a.CompressedContent)
@@ -1009,6 +1735,74 @@ func GetArticlesForOwner(ctx context.Context, ownerID string, start, end time.Ti
 }
 
 // GetLastFetchTimeForSource fetches the last fetch time for a given source from the database
+// AddFetchError records a failure fetching a source
+func AddFetchError(ctx context.Context, e *domain.FetchError) error {
+	_, err := db.ExecContext(ctx, "INSERT INTO fetch_errors (source_id, link, error, created) VALUES (?, ?, ?, ?)", e.SourceID, e.Link, e.Error, e.Created)
+	return err
+}
+
+// GetFetchErrors returns the most recent fetch errors, newest first
+func GetFetchErrors(ctx context.Context, limit int) ([]domain.FetchError, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT e.id, e.source_id, COALESCE(s.name, ''), e.link, e.error, e.created
+		FROM fetch_errors e LEFT JOIN sources s ON s.id = e.source_id
+		ORDER BY e.created DESC LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	errs := []domain.FetchError{}
+	for rows.Next() {
+		var e domain.FetchError
+		err = rows.Scan(&e.ID, &e.SourceID, &e.SourceName, &e.Link, &e.Error, &e.Created)
+		if err != nil {
+			return nil, err
+		}
+		errs = append(errs, e)
+	}
+	return errs, rows.Err()
+}
+
+// DeleteFetchErrors removes fetch errors older than age
+func DeleteFetchErrors(ctx context.Context, age time.Duration) error {
+	_, err := db.ExecContext(ctx, "DELETE FROM fetch_errors WHERE created < ?", time.Now().Add(-age))
+	return err
+}
+
+// GetSourceHealth returns every owner's sources with how many articles
+// and errors they've had since
+func GetSourceHealth(ctx context.Context, since time.Time) ([]domain.SourceHealth, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT s.id, s.owner_id, s.name, s.url, s.feed_url, s.disable_fetch, s.last_fetch_time,
+		(SELECT COUNT(*) FROM articles a WHERE a.source_id = s.id AND a.timestamp > ?),
+		(SELECT COUNT(*) FROM fetch_errors e WHERE e.source_id = s.id AND e.created > ?),
+		COALESCE((SELECT e.error FROM fetch_errors e WHERE e.source_id = s.id ORDER BY e.created DESC LIMIT 1), '')
+		FROM sources s ORDER BY s.owner_id, s.name
+	`, since, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	health := []domain.SourceHealth{}
+	for rows.Next() {
+		var (
+			h            domain.SourceHealth
+			disableFetch sql.NullBool
+			lastFetch    sql.NullTime
+		)
+		err = rows.Scan(&h.ID, &h.OwnerID, &h.Name, &h.URL, &h.FeedURL, &disableFetch, &lastFetch, &h.Articles, &h.Errors, &h.LastError)
+		if err != nil {
+			return nil, err
+		}
+		h.DisableFetch, h.LastFetchTime = disableFetch.Bool, lastFetch.Time
+		health = append(health, h)
+	}
+	return health, rows.Err()
+}
+
 func GetLastFetchTimeForSource(ctx context.Context, sourceID int) (time.Time, error) {
 	// Prepare a query to select the last fetch time for the given source
 	query := "SELECT last_fetch_time FROM sources WHERE id = ?"
@@ -0,0 +1,117 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/RusticPotatoes/news/domain"
+	"github.com/RusticPotatoes/news/pkg/metrics"
+)
+
+// instrumentedDB times every query made outside a transaction, labelled
+// with the dao function that made it
+type instrumentedDB struct {
+	*sql.DB
+}
+
+func (d *instrumentedDB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	defer observeQuery(time.Now())
+	return d.DB.Exec(query, args...)
+}
+
+func (d *instrumentedDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	defer observeQuery(time.Now())
+	return d.DB.ExecContext(ctx, query, args...)
+}
+
+func (d *instrumentedDB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	defer observeQuery(time.Now())
+	return d.DB.Query(query, args...)
+}
+
+func (d *instrumentedDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	defer observeQuery(time.Now())
+	return d.DB.QueryContext(ctx, query, args...)
+}
+
+func (d *instrumentedDB) QueryRow(query string, args ...interface{}) *sql.Row {
+	defer observeQuery(time.Now())
+	return d.DB.QueryRow(query, args...)
+}
+
+func (d *instrumentedDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	defer observeQuery(time.Now())
+	return d.DB.QueryRowContext(ctx, query, args...)
+}
+
+// observeQuery records a query that started at start. It has to be
+// deferred straight from an instrumentedDB method, so it can find the
+// function that called the method.
+func observeQuery(start time.Time) {
+	name := "unknown"
+	// skip observeQuery and the method it was deferred in
+	if pc, _, _, ok := runtime.Caller(2); ok {
+		if fn := runtime.FuncForPC(pc); fn != nil {
+			name = fn.Name()
+			name = name[strings.LastIndex(name, "/")+1:]
+			name = strings.TrimPrefix(name, "dao.")
+		}
+	}
+	metrics.DBQueryDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+}
+
+// counted are the tables whose row counts are reported, and the metric
+// for each
+var counted = map[string]string{
+	"articles": "articles",
+	"edition":  "editions",
+	"sources":  "sources",
+	"users":    "users",
+}
+
+func init() {
+	for table, name := range counted {
+		table := table
+		promauto.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: "news",
+			Name:      name,
+			Help:      "Rows in the " + table + " table.",
+		}, func() float64 {
+			return countRows("SELECT COUNT(*) FROM " + table)
+		})
+	}
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "news",
+		Name:      "webhook_queue_depth",
+		Help:      "Webhook deliveries waiting to be sent or retried.",
+	}, func() float64 {
+		return countRows("SELECT COUNT(*) FROM webhook_deliveries WHERE status = ?", domain.WebhookPending)
+	})
+}
+
+// countRows runs a count query for a gauge, it's zero until the database
+// is open
+func countRows(query string, args ...interface{}) float64 {
+	if db == nil {
+		return 0
+	}
+	var n int64
+	if err := db.DB.QueryRow(query, args...).Scan(&n); err != nil {
+		return 0
+	}
+	return float64(n)
+}
+
+// Ping checks the database can be reached, for readiness checks
+func Ping(ctx context.Context) error {
+	if db == nil {
+		return sql.ErrConnDone
+	}
+	return db.PingContext(ctx)
+}
@@ -0,0 +1,328 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// migration brings a database made by an older sql/init.sql up to date.
+// Steps have to be safe to run on a database that already has them, since
+// databases made from the current init.sql start at version 0 too.
+type migration struct {
+	name    string
+	columns []column
+	stmts   []string
+}
+
+// column is added to an existing table if it's missing
+type column struct {
+	table, name, def string
+}
+
+// migrations are applied in order, a database's PRAGMA user_version is how
+// many it has had. Only ever append to this, and keep sql/init.sql in step.
+var migrations = []migration{
+	{
+		name: "article summaries and tags",
+		columns: []column{
+			{"articles", "summaries", "TEXT"},
+			{"articles", "tags", "TEXT"},
+		},
+	},
+	{
+		name: "rules",
+		stmts: []string{`CREATE TABLE IF NOT EXISTS rules (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			owner_id TEXT,
+			field TEXT,
+			pattern TEXT,
+			action TEXT,
+			created DATETIME
+		)`},
+	},
+	{
+		name: "source weights",
+		columns: []column{
+			{"sources", "weight", "REAL DEFAULT 1"},
+			{"sources", "max_articles", "INTEGER DEFAULT 0"},
+			{"sources", "pin_section", "TEXT DEFAULT ''"},
+		},
+	},
+	{
+		name: "deliveries",
+		stmts: []string{`CREATE TABLE IF NOT EXISTS deliveries (
+			owner_id TEXT PRIMARY KEY,
+			enabled BOOLEAN DEFAULT 0,
+			email TEXT DEFAULT '',
+			kindle_email TEXT DEFAULT '',
+			format TEXT DEFAULT 'html',
+			send_time TEXT DEFAULT '07:00',
+			last_sent DATETIME
+		)`},
+	},
+	{
+		name: "webhooks",
+		stmts: []string{
+			`CREATE TABLE IF NOT EXISTS webhooks (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				owner_id TEXT,
+				url TEXT,
+				secret TEXT,
+				event TEXT,
+				field TEXT DEFAULT '',
+				pattern TEXT DEFAULT '',
+				created DATETIME
+			)`,
+			`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				webhook_id INTEGER,
+				owner_id TEXT,
+				event TEXT,
+				payload BLOB,
+				status TEXT,
+				attempts INTEGER DEFAULT 0,
+				status_code INTEGER DEFAULT 0,
+				error TEXT DEFAULT '',
+				next_attempt DATETIME,
+				created DATETIME,
+				updated DATETIME,
+				FOREIGN KEY(webhook_id) REFERENCES webhooks(id) ON DELETE CASCADE
+			)`,
+			`CREATE INDEX IF NOT EXISTS webhook_deliveries_status ON webhook_deliveries (status, next_attempt)`,
+		},
+	},
+	{
+		name:  "article image index",
+		stmts: []string{`CREATE INDEX IF NOT EXISTS articles_image_url ON articles (image_url)`},
+	},
+	{
+		name: "sessions",
+		stmts: []string{
+			`CREATE TABLE IF NOT EXISTS sessions (
+				id TEXT PRIMARY KEY,
+				user_id TEXT,
+				created DATETIME,
+				expires DATETIME,
+				last_seen DATETIME,
+				user_agent TEXT DEFAULT '',
+				revoked BOOLEAN DEFAULT 0
+			)`,
+			`CREATE INDEX IF NOT EXISTS sessions_user_id ON sessions (user_id)`,
+		},
+	},
+	{
+		name: "fetch errors and last login",
+		columns: []column{
+			{"users", "last_login", "DATETIME"},
+		},
+		stmts: []string{
+			`CREATE TABLE IF NOT EXISTS fetch_errors (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				source_id INTEGER,
+				link TEXT,
+				error TEXT,
+				created DATETIME,
+				FOREIGN KEY(source_id) REFERENCES sources(id)
+			)`,
+			`CREATE INDEX IF NOT EXISTS fetch_errors_source ON fetch_errors (source_id, created)`,
+		},
+	},
+	{
+		name: "invites and pending users",
+		columns: []column{
+			{"users", "pending", "BOOLEAN"},
+		},
+		stmts: []string{`CREATE TABLE IF NOT EXISTS invites (
+			code TEXT PRIMARY KEY,
+			created_by INTEGER,
+			created DATETIME,
+			expires DATETIME,
+			max_uses INTEGER,
+			uses INTEGER DEFAULT 0
+		)`},
+	},
+	{
+		name: "login attempts",
+		stmts: []string{
+			`CREATE TABLE IF NOT EXISTS login_attempts (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				username TEXT,
+				ip TEXT,
+				success BOOLEAN,
+				created DATETIME
+			)`,
+			`CREATE INDEX IF NOT EXISTS login_attempts_username ON login_attempts (username, created)`,
+			`CREATE INDEX IF NOT EXISTS login_attempts_ip ON login_attempts (ip, created)`,
+		},
+	},
+	{
+		name: "user emails",
+		columns: []column{
+			{"users", "email", "TEXT"},
+		},
+		stmts: []string{`CREATE UNIQUE INDEX IF NOT EXISTS users_email ON users (email)`},
+	},
+	{
+		name: "api tokens",
+		stmts: []string{
+			`CREATE TABLE IF NOT EXISTS api_tokens (
+				id TEXT PRIMARY KEY,
+				user_id TEXT,
+				name TEXT,
+				hash BLOB,
+				scopes TEXT,
+				created DATETIME,
+				last_used DATETIME,
+				expires DATETIME,
+				revoked BOOLEAN DEFAULT 0
+			)`,
+			`CREATE INDEX IF NOT EXISTS api_tokens_user ON api_tokens (user_id)`,
+		},
+	},
+	{
+		name: "delivery targets and failures",
+		columns: []column{
+			{"deliveries", "email_sent", "DATETIME"},
+			{"deliveries", "kindle_sent", "DATETIME"},
+			{"deliveries", "failures", "INTEGER DEFAULT 0"},
+			{"deliveries", "retry_at", "DATETIME"},
+			{"deliveries", "last_error", "TEXT DEFAULT ''"},
+		},
+	},
+	{
+		// the source settings page used to store sources under the user's
+		// ID rather than their name, move those over and fold them into
+		// the name-keyed copy where there's one already
+		name: "key sources by owner name",
+		stmts: []string{
+			`CREATE TEMP TABLE source_owners AS
+				SELECT s.id AS id, u.name AS name, n.id AS dup
+				FROM sources s
+				JOIN users u ON s.owner_id = CAST(u.id AS TEXT)
+				LEFT JOIN sources n ON n.owner_id = u.name AND n.url = s.url
+				WHERE s.owner_id NOT IN (SELECT name FROM users)`,
+			`UPDATE articles SET source_id = (SELECT dup FROM source_owners WHERE id = articles.source_id)
+				WHERE source_id IN (SELECT id FROM source_owners WHERE dup IS NOT NULL)`,
+			`DELETE FROM fetch_errors WHERE source_id IN (SELECT id FROM source_owners WHERE dup IS NOT NULL)`,
+			`DELETE FROM sources WHERE id IN (SELECT id FROM source_owners WHERE dup IS NOT NULL)`,
+			`UPDATE sources SET owner_id = (SELECT name FROM source_owners WHERE id = sources.id)
+				WHERE id IN (SELECT id FROM source_owners WHERE dup IS NULL)`,
+			`DROP TABLE source_owners`,
+		},
+	},
+	{
+		name: "delivery confirmations",
+		columns: []column{
+			{"deliveries", "email_confirmed", "TEXT DEFAULT ''"},
+			{"deliveries", "kindle_confirmed", "TEXT DEFAULT ''"},
+		},
+	},
+}
+
+// errNoSchema is returned when there's nothing to migrate from
+var errNoSchema = errors.New("the database has no tables, create it from sql/init.sql first")
+
+// Migrate applies the migrations the database hasn't had yet, each in its
+// own transaction, and returns the names of the ones it applied
+func Migrate(ctx context.Context) ([]string, error) {
+	var exists int
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'articles'").Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if exists == 0 {
+		return nil, errNoSchema
+	}
+
+	version, err := SchemaVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var applied []string
+	for i := version; i < len(migrations); i++ {
+		m := migrations[i]
+		if err := applyMigration(ctx, i+1, m); err != nil {
+			return applied, fmt.Errorf("error applying migration %d, %s: %w", i+1, m.name, err)
+		}
+		applied = append(applied, m.name)
+	}
+	return applied, nil
+}
+
+// SchemaVersion is how many migrations the database has had
+func SchemaVersion(ctx context.Context) (int, error) {
+	var version int
+	err := db.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version)
+	return version, err
+}
+
+// LatestSchemaVersion is the version Migrate brings databases up to
+func LatestSchemaVersion() int {
+	return len(migrations)
+}
+
+func applyMigration(ctx context.Context, version int, m migration) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, c := range m.columns {
+		ok, err := hasColumn(ctx, tx, c.table, c.name)
+		if err != nil {
+			return err
+		}
+		if ok {
+			continue
+		}
+		_, err = tx.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", c.table, c.name, c.def))
+		if err != nil {
+			return err
+		}
+	}
+	for _, stmt := range m.stmts {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	// pragmas can't take parameters
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", version)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func hasColumn(ctx context.Context, tx *sql.Tx, table, name string) (bool, error) {
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			cid        int
+			colName    string
+			colType    string
+			notNull    bool
+			defaultVal sql.NullString
+			pk         int
+		)
+		if err := rows.Scan(&cid, &colName, &colType, &notNull, &defaultVal, &pk); err != nil {
+			return false, err
+		}
+		if colName == name {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+// Backup writes a consistent copy of the database to path, which mustn't
+// exist yet. It's safe to run while the server is using the database.
+func Backup(ctx context.Context, path string) error {
+	_, err := db.ExecContext(ctx, "VACUUM INTO ?", path)
+	return err
+}
@@ -0,0 +1,107 @@
+package dao
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// openBaseline opens a new database made with the first release's
+// sql/init.sql, with a source owned by user ID as well as by name
+func openBaseline(t *testing.T) {
+	t.Helper()
+	schema, err := os.ReadFile(filepath.Join("testdata", "baseline.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Open(filepath.Join(t.TempDir(), "news.db")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(Close)
+
+	stmts := []string{
+		string(schema),
+		`INSERT INTO users (id, name, is_admin) VALUES (1, 'admin', 1)`,
+		`INSERT INTO sources (id, owner_id, name, url) VALUES
+			(1, 'admin', 'A', 'https://a.example'),
+			(2, '1', 'A again', 'https://a.example'),
+			(3, '1', 'B', 'https://b.example')`,
+		`INSERT INTO articles (title, link, source_id) VALUES ('From A again', 'https://a.example/1', 2)`,
+	}
+	for _, s := range stmts {
+		if _, err := db.Exec(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	openBaseline(t)
+
+	tests := []struct {
+		name        string
+		wantApplied int
+	}{
+		{"baseline", LatestSchemaVersion()},
+		{"again", 0},
+	}
+	for _, tt := range tests {
+		applied, err := Migrate(ctx)
+		if err != nil {
+			t.Fatalf("%s: Migrate() error = %v", tt.name, err)
+		}
+		if len(applied) != tt.wantApplied {
+			t.Errorf("%s: Migrate() applied %d migrations %v, want %d", tt.name, len(applied), applied, tt.wantApplied)
+		}
+		version, err := SchemaVersion(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if version != LatestSchemaVersion() {
+			t.Errorf("%s: SchemaVersion() = %d, want %d", tt.name, version, LatestSchemaVersion())
+		}
+	}
+
+	// the source keyed by ID is folded into the one keyed by name, taking
+	// its articles with it
+	rows, err := db.Query("SELECT id, owner_id FROM sources ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var sources [][2]string
+	for rows.Next() {
+		var s [2]string
+		if err := rows.Scan(&s[0], &s[1]); err != nil {
+			t.Fatal(err)
+		}
+		sources = append(sources, s)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	want := [][2]string{{"1", "admin"}, {"3", "admin"}}
+	if !reflect.DeepEqual(sources, want) {
+		t.Errorf("sources after Migrate() = %v, want %v", sources, want)
+	}
+	var sourceID string
+	if err := db.QueryRow("SELECT source_id FROM articles").Scan(&sourceID); err != nil {
+		t.Fatal(err)
+	}
+	if sourceID != "1" {
+		t.Errorf("article source after Migrate() = %s, want 1", sourceID)
+	}
+}
+
+func TestMigrateNoSchema(t *testing.T) {
+	if err := Open(filepath.Join(t.TempDir(), "news.db")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(Close)
+	if _, err := Migrate(context.Background()); err != errNoSchema {
+		t.Errorf("Migrate() on an empty database error = %v, want %v", err, errNoSchema)
+	}
+}
@@ -32,7 +32,7 @@ func (e analyticsEvent) Save() (map[string]bigquery.Value, string, error) {
 }
 
 func AnalyticsMiddleware(h http.HandlerFunc) (http.HandlerFunc, error) {
-	projectID := "russellsaw"
+	projectID := config.GoogleProject
 	datasetID := "news"
 	tableID := "analytics_raw"
 	ctx := context.Background()
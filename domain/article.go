@@ -7,12 +7,20 @@ import (
 	"encoding/json"
 	"html/template"
 	"io/ioutil"
+	"sort"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/go-shiori/go-readability"
 	"github.com/monzo/slog"
+
+	"github.com/RusticPotatoes/news/pkg/summarize"
 )
 
+// SummaryLengths are the summary sizes, in sentences, stored for each article
+var SummaryLengths = []int{1, 3, 5}
+
 type Article struct {
 	ID                string
 	Title             string
@@ -28,6 +36,16 @@ type Article struct {
 	TS                string
 	LayoutID		  int64
 	Layout            Layout
+	// Summaries holds extractive summaries keyed by their length in sentences
+	Summaries         map[int]string
+	// Tags are topic categories inferred from the article itself
+	Tags              []string
+	// Highlight is set when one of the reader's rules highlights the article
+	Highlight         bool
+	// Demoted is set when one of the reader's rules demotes the article
+	Demoted           bool
+	// HiddenBy describes the reader's rule that hid the article, if any
+	HiddenBy          string
 
 	decompressed []byte
 }
@@ -42,6 +60,18 @@ func (a *Article) Size() int {
 	return n
 }
 
+// Priority orders articles by the reader's rules, highlighted articles
+// are picked before everything else and demoted articles after
+func (a *Article) Priority() int {
+	switch {
+	case a.Highlight:
+		return 1
+	case a.Demoted:
+		return -1
+	}
+	return 0
+}
+
 func (a *Article) Trim(size int) {
 	// Deduct a fixed amount from size for the Title
 	size -= 200
@@ -50,14 +80,63 @@ func (a *Article) Trim(size int) {
 	size -= len(a.Source.Name)
 
 	// Trim the Title if it's too long
-	if len(a.Title) > size {
-		a.Title = a.Title[:size]
+	if size > 0 && len(a.Title) > size {
+		a.Title = truncate(a.Title, size)
 	}
 
 	// Calculate the remaining size for the TextContent
-	if size > 0 && len(a.Content.TextContent) > size {
-		a.Content.TextContent = a.Content.TextContent[:size] + "..."
+	if size > 0 {
+		a.Content.TextContent = a.Summary(size)
+	}
+}
+
+// Summarize computes the stored summaries from the article's text content
+func (a *Article) Summarize() {
+	ranked := summarize.Rank(a.Content.TextContent)
+	a.Summaries = make(map[int]string, len(SummaryLengths))
+	for _, n := range SummaryLengths {
+		if s := ranked.Text(n); s != "" {
+			a.Summaries[n] = s
+		}
+	}
+}
+
+// Summary returns the longest stored summary that fits within maxChars,
+// falling back to the text content cut on a word boundary
+func (a *Article) Summary(maxChars int) string {
+	lengths := make([]int, 0, len(a.Summaries))
+	for n := range a.Summaries {
+		lengths = append(lengths, n)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(lengths)))
+	for _, n := range lengths {
+		if len(a.Summaries[n]) <= maxChars {
+			return a.Summaries[n]
+		}
+	}
+	if len(lengths) > 0 {
+		return truncate(a.Summaries[lengths[len(lengths)-1]], maxChars)
+	}
+	return truncate(a.Content.TextContent, maxChars)
+}
+
+// truncate cuts s to at most size bytes without splitting a rune, preferring
+// to break on a space, and marks the cut with an ellipsis
+func truncate(s string, size int) string {
+	if len(s) <= size {
+		return s
+	}
+	if size <= 0 {
+		return ""
+	}
+	cut := size
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	if i := strings.LastIndexByte(s[:cut], ' '); i > cut/2 {
+		cut = i
 	}
+	return strings.TrimSpace(s[:cut]) + "..."
 }
 
 func (a *Article) RawHTML() template.HTML {
@@ -0,0 +1,356 @@
+package domain
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/RusticPotatoes/news/pkg/mailer"
+)
+
+// minTokenSecret is the fewest random bytes a token secret is accepted
+// with, anything shorter can be brute forced from a single session token
+const minTokenSecret = 32
+
+// Config is how the server is set up. It's read from a JSON file, see
+// config.example.json, then environment variables override the file so a
+// container can be configured without one.
+type Config struct {
+	// Addr is the address the server listens on, NEWS_ADDR
+	Addr string `json:"addr"`
+	// Dev reads templates and static files from the working directory,
+	// picking up changes without a restart, NEWS_DEV
+	Dev bool `json:"dev"`
+	// BaseURL is where readers reach the site, it's used for links that
+	// leave the site in emails, webhooks and QR codes, NEWS_BASE_URL
+	BaseURL string `json:"base_url"`
+	// DataDir holds the database, the image cache and edition exports,
+	// NEWS_DATA_DIR
+	DataDir string `json:"data_dir"`
+	// Database is the SQLite database, it defaults to news.db in DataDir,
+	// NEWS_DATABASE
+	Database string `json:"database"`
+	// TokenSecret signs session, unsubscribe and image tokens, it's hex or
+	// base64 as openssl rand prints it, TOKEN_SECRET
+	TokenSecret string `json:"token_secret"`
+	// SignupMode is who can create an account, SIGNUP_MODE
+	SignupMode SignupMode `json:"signup_mode"`
+	// TrustProxy takes the client's address from X-Forwarded-For, only set
+	// it behind a reverse proxy, TRUST_PROXY
+	TrustProxy bool `json:"trust_proxy"`
+	// ReadabilityURL is a readability server used to refresh an article's
+	// text, refreshing is off without one, NEWS_READABILITY_URL
+	ReadabilityURL string `json:"readability_url"`
+	// GoogleProject is the Google Cloud project for traces, pubsub and
+	// analytics, NEWS_GOOGLE_PROJECT
+	GoogleProject string `json:"google_project"`
+	// FetchTimes are when feeds are fetched each day, as UTC 15:04 times,
+	// NEWS_FETCH_TIMES is a comma separated list
+	FetchTimes []string `json:"fetch_times"`
+	// ImageCacheSize is the most disk the dithered image cache uses, in
+	// megabytes, IMAGE_CACHE_SIZE
+	ImageCacheSize int64 `json:"image_cache_size"`
+	// ShutdownTimeout is how long, in seconds, requests and running jobs
+	// get to finish once the server is asked to stop, NEWS_SHUTDOWN_TIMEOUT
+	ShutdownTimeout int `json:"shutdown_timeout"`
+	// SMTP is the mail server editions are sent with, SMTP_HOST, SMTP_PORT,
+	// SMTP_USERNAME, SMTP_PASSWORD and SMTP_FROM
+	SMTP mailer.Config `json:"smtp"`
+	// OIDC is the single sign-on provider, OIDC_ISSUER, OIDC_CLIENT_ID,
+	// OIDC_CLIENT_SECRET, OIDC_REDIRECT_URL, OIDC_NAME,
+	// OIDC_ALLOWED_DOMAINS and OIDC_TRUST_EMAIL
+	OIDC OIDCConfig `json:"oidc"`
+}
+
+// OIDCConfig is the single sign-on provider. It's off unless the issuer and
+// client ID are set.
+type OIDCConfig struct {
+	Issuer       string `json:"issuer"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	// RedirectURL has to be registered with the provider, it defaults to
+	// the callback under BaseURL
+	RedirectURL string `json:"redirect_url"`
+	// Name labels the sign in button
+	Name string `json:"name"`
+	// AllowedDomains limits sign-on to addresses at these domains, any
+	// address the provider verified is accepted if it's empty
+	AllowedDomains []string `json:"allowed_domains"`
+	// TrustEmail accepts addresses from a provider that doesn't send
+	// email_verified. Only set it for providers that only hand out
+	// addresses they manage, otherwise anyone can claim any address.
+	TrustEmail bool `json:"trust_email"`
+}
+
+// DefaultConfig is used for anything the file and environment don't set
+func DefaultConfig() *Config {
+	return &Config{
+		Addr:            ":8080",
+		BaseURL:         "http://localhost:8080",
+		DataDir:         "./data",
+		SignupMode:      SignupOpen,
+		FetchTimes:      []string{"2:00", "10:00", "17:00"},
+		ImageCacheSize:  512,
+		ShutdownTimeout: 30,
+		SMTP: mailer.Config{
+			Port: "25",
+			From: "The Webpage <news@localhost>",
+		},
+		OIDC: OIDCConfig{
+			Name: "single sign-on",
+		},
+	}
+}
+
+// LoadConfig reads the config file at path over the defaults, then applies
+// the environment. A missing file is fine when path is empty.
+func LoadConfig(path string) (*Config, error) {
+	c := DefaultConfig()
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("error opening config: %w", err)
+		}
+		defer f.Close()
+		dec := json.NewDecoder(f)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(c); err != nil {
+			return nil, fmt.Errorf("error reading config %s: %w", path, err)
+		}
+	}
+	if err := c.applyEnv(os.LookupEnv); err != nil {
+		return nil, err
+	}
+	c.BaseURL = strings.TrimSuffix(c.BaseURL, "/")
+	c.OIDC.Issuer = strings.TrimSuffix(c.OIDC.Issuer, "/")
+	for i, d := range c.OIDC.AllowedDomains {
+		c.OIDC.AllowedDomains[i] = strings.ToLower(strings.TrimSpace(d))
+	}
+	c.SignupMode = SignupMode(strings.ToLower(string(c.SignupMode)))
+	return c, nil
+}
+
+func (c *Config) applyEnv(lookup func(string) (string, bool)) error {
+	str := func(key string, v *string) {
+		if s, ok := lookup(key); ok && s != "" {
+			*v = s
+		}
+	}
+	list := func(key string, v *[]string) {
+		if s, ok := lookup(key); ok && s != "" {
+			*v = nil
+			for _, part := range strings.Split(s, ",") {
+				if part = strings.TrimSpace(part); part != "" {
+					*v = append(*v, part)
+				}
+			}
+		}
+	}
+
+	// the debug port is kept so old setups don't clash
+	if env, _ := lookup("NEWS_ENV"); env == "debug" {
+		c.Addr = ":8081"
+		c.Dev = true
+	}
+	str("NEWS_ADDR", &c.Addr)
+	if s, ok := lookup("NEWS_DEV"); ok && s != "" {
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("NEWS_DEV must be true or false, not %q", s)
+		}
+		c.Dev = b
+	}
+	str("NEWS_BASE_URL", &c.BaseURL)
+	str("NEWS_DATA_DIR", &c.DataDir)
+	str("NEWS_DATABASE", &c.Database)
+	str("TOKEN_SECRET", &c.TokenSecret)
+	if s, ok := lookup("SIGNUP_MODE"); ok && s != "" {
+		c.SignupMode = SignupMode(s)
+	}
+	if s, ok := lookup("TRUST_PROXY"); ok && s != "" {
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("TRUST_PROXY must be true or false, not %q", s)
+		}
+		c.TrustProxy = b
+	}
+	str("NEWS_READABILITY_URL", &c.ReadabilityURL)
+	str("NEWS_GOOGLE_PROJECT", &c.GoogleProject)
+	list("NEWS_FETCH_TIMES", &c.FetchTimes)
+	if s, ok := lookup("IMAGE_CACHE_SIZE"); ok && s != "" {
+		mb, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return fmt.Errorf("IMAGE_CACHE_SIZE must be a number of megabytes, not %q", s)
+		}
+		c.ImageCacheSize = mb
+	}
+	if s, ok := lookup("NEWS_SHUTDOWN_TIMEOUT"); ok && s != "" {
+		secs, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("NEWS_SHUTDOWN_TIMEOUT must be a number of seconds, not %q", s)
+		}
+		c.ShutdownTimeout = secs
+	}
+	str("SMTP_HOST", &c.SMTP.Host)
+	str("SMTP_PORT", &c.SMTP.Port)
+	str("SMTP_USERNAME", &c.SMTP.Username)
+	str("SMTP_PASSWORD", &c.SMTP.Password)
+	str("SMTP_FROM", &c.SMTP.From)
+	str("OIDC_ISSUER", &c.OIDC.Issuer)
+	str("OIDC_CLIENT_ID", &c.OIDC.ClientID)
+	str("OIDC_CLIENT_SECRET", &c.OIDC.ClientSecret)
+	str("OIDC_REDIRECT_URL", &c.OIDC.RedirectURL)
+	str("OIDC_NAME", &c.OIDC.Name)
+	list("OIDC_ALLOWED_DOMAINS", &c.OIDC.AllowedDomains)
+	if s, ok := lookup("OIDC_TRUST_EMAIL"); ok && s != "" {
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("OIDC_TRUST_EMAIL must be true or false, not %q", s)
+		}
+		c.OIDC.TrustEmail = b
+	}
+	return nil
+}
+
+// Validate returns every problem with the config, the server shouldn't
+// start with any
+func (c *Config) Validate() error {
+	var errs []error
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if c.Addr == "" {
+		fail("addr must be set")
+	}
+	if !isHTTPURL(c.BaseURL) {
+		fail("base_url must be an http or https URL, not %q", c.BaseURL)
+	}
+	if c.DataDir == "" && c.Database == "" {
+		fail("data_dir or database must be set")
+	}
+	switch n, ok := secretBytes(c.TokenSecret); {
+	case c.TokenSecret == "":
+		fail("token_secret must be set, generate one with: openssl rand -hex %d", minTokenSecret)
+	case !ok:
+		fail("token_secret must be hex or base64, generate one with: openssl rand -hex %d", minTokenSecret)
+	case n < minTokenSecret:
+		fail("token_secret must be at least %d random bytes, it's %d", minTokenSecret, n)
+	}
+	switch c.SignupMode {
+	case SignupOpen, SignupInvite, SignupApproval, SignupDisabled:
+	default:
+		fail("unknown signup_mode %q, it must be open, invite, approval or disabled", c.SignupMode)
+	}
+	if c.ReadabilityURL != "" && !isHTTPURL(c.ReadabilityURL) {
+		fail("readability_url must be an http or https URL, not %q", c.ReadabilityURL)
+	}
+	if len(c.FetchTimes) == 0 {
+		fail("fetch_times needs at least one time")
+	}
+	for _, t := range c.FetchTimes {
+		if _, err := time.Parse("15:04", t); err != nil {
+			fail("fetch time %q must look like 15:04", t)
+		}
+	}
+	if c.ImageCacheSize <= 0 {
+		fail("image_cache_size must be more than 0")
+	}
+	if c.ShutdownTimeout <= 0 {
+		fail("shutdown_timeout must be more than 0")
+	}
+	if c.SMTP.Host != "" {
+		if _, err := strconv.Atoi(c.SMTP.Port); err != nil {
+			fail("smtp port must be a number, not %q", c.SMTP.Port)
+		}
+	}
+	if (c.OIDC.Issuer == "") != (c.OIDC.ClientID == "") {
+		fail("oidc needs both an issuer and a client_id")
+	}
+	if c.OIDC.Issuer != "" && !isHTTPURL(c.OIDC.Issuer) {
+		fail("oidc issuer must be an http or https URL, not %q", c.OIDC.Issuer)
+	}
+	// sign-on skips invite codes, so in invite mode the allowed domains are
+	// what limits who gets an account
+	if c.OIDC.Issuer != "" && c.SignupMode == SignupInvite && len(c.OIDC.AllowedDomains) == 0 {
+		fail("oidc needs allowed_domains when signup_mode is invite, or anyone at the provider can sign up")
+	}
+	return errors.Join(errs...)
+}
+
+// DatabasePath is where the SQLite database is
+func (c *Config) DatabasePath() string {
+	if c.Database != "" {
+		return c.Database
+	}
+	return filepath.Join(c.DataDir, "news.db")
+}
+
+// DrainTimeout is how long shutting down waits for work to finish
+func (c *Config) DrainTimeout() time.Duration {
+	return time.Duration(c.ShutdownTimeout) * time.Second
+}
+
+// LocalURL is how the server reaches itself, on its own listen address
+// rather than through whatever is in front of BaseURL
+func (c *Config) LocalURL() string {
+	host, port, err := net.SplitHostPort(c.Addr)
+	if err != nil {
+		return c.BaseURL
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "localhost"
+	}
+	return "http://" + net.JoinHostPort(host, port)
+}
+
+// ImageCacheDir is where dithered images are cached
+func (c *Config) ImageCacheDir() string {
+	return filepath.Join(c.DataDir, "images")
+}
+
+// ArtifactDir is where scheduled edition exports are written
+func (c *Config) ArtifactDir() string {
+	return filepath.Join(c.DataDir, "artifacts")
+}
+
+// secretBytes is how many bytes a hex or base64 secret decodes to, so a
+// long passphrase or a placeholder doesn't pass for a random one
+func secretBytes(s string) (int, bool) {
+	if b, err := hex.DecodeString(s); err == nil {
+		return len(b), true
+	}
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if b, err := enc.DecodeString(s); err == nil {
+			return len(b), true
+		}
+	}
+	return 0, false
+}
+
+func isHTTPURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// config is the running server's config, it's set once at startup
+var config = DefaultConfig()
+
+// SetConfig makes c the running config, call it before serving anything
+func SetConfig(c *Config) {
+	config = c
+}
+
+// CurrentConfig is the running config
+func CurrentConfig() *Config {
+	return config
+}
@@ -0,0 +1,271 @@
+package domain
+
+import (
+	"fmt"
+	"net/mail"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// Delivery formats, how an edition is sent by email
+const (
+	DeliveryHTML = "html"
+	DeliveryPDF  = "pdf"
+	DeliveryEPUB = "epub"
+)
+
+var DeliveryFormats = []string{DeliveryHTML, DeliveryPDF, DeliveryEPUB}
+
+// Delivery targets, the addresses an edition is sent to. Each records when
+// it was last sent so one failing doesn't resend to the other.
+const (
+	DeliveryTargetEmail  = "email"
+	DeliveryTargetKindle = "kindle"
+)
+
+const (
+	// MaxDeliveryFailures is how many sends in a row can fail before
+	// delivery is turned off, the reader has to save their settings again
+	MaxDeliveryFailures = 5
+	// deliveryRetry is how long to wait after the first failure, it
+	// doubles with each one after
+	deliveryRetry = 15 * time.Minute
+)
+
+// Delivery is a reader's choice of how and when their edition is emailed
+// to them
+type Delivery struct {
+	OwnerID string
+	Enabled bool
+	Email   string
+	// KindleEmail is a "send to Kindle" address, which is always sent the
+	// edition as an EPUB
+	KindleEmail string
+	Format      string
+	// SendTime is the time of day the edition is sent, as 15:04 in UTC
+	SendTime string
+	LastSent time.Time
+	// EmailSent and KindleSent are when each target was last sent an
+	// edition
+	EmailSent  time.Time
+	KindleSent time.Time
+	// Failures is how many sends in a row have failed, nothing is sent
+	// before RetryAt
+	Failures  int
+	RetryAt   time.Time
+	LastError string
+	// EmailConfirmed and KindleConfirmed are the addresses the reader has
+	// followed a confirmation link for. Nothing is sent to an address
+	// until it's confirmed, so the form can't be used to mail strangers.
+	EmailConfirmed  string
+	KindleConfirmed string
+}
+
+// Validate checks the addresses and send time are well formed
+func (d *Delivery) Validate() error {
+	if !contains(DeliveryFormats, d.Format) {
+		return fmt.Errorf("unknown delivery format: %s", d.Format)
+	}
+	if _, err := time.Parse("15:04", d.SendTime); err != nil {
+		return fmt.Errorf("invalid send time %q, use HH:MM", d.SendTime)
+	}
+	if d.Enabled && d.Email == "" && d.KindleEmail == "" {
+		return fmt.Errorf("an email or kindle address is needed to send editions")
+	}
+	for _, addr := range []string{d.Email, d.KindleEmail} {
+		if addr == "" {
+			continue
+		}
+		if _, err := mail.ParseAddress(addr); err != nil {
+			return fmt.Errorf("invalid email address %q", addr)
+		}
+	}
+	return nil
+}
+
+// Targets returns the targets the reader has an address for
+func (d *Delivery) Targets() []string {
+	var targets []string
+	if d.Email != "" {
+		targets = append(targets, DeliveryTargetEmail)
+	}
+	if d.KindleEmail != "" {
+		targets = append(targets, DeliveryTargetKindle)
+	}
+	return targets
+}
+
+// Address is where editions for target are sent
+func (d *Delivery) Address(target string) string {
+	if target == DeliveryTargetKindle {
+		return d.KindleEmail
+	}
+	return d.Email
+}
+
+// Confirmed is whether target's address is one the reader has confirmed
+func (d *Delivery) Confirmed(target string) bool {
+	confirmed := d.EmailConfirmed
+	if target == DeliveryTargetKindle {
+		confirmed = d.KindleConfirmed
+	}
+	return d.Address(target) != "" && d.Address(target) == confirmed
+}
+
+// ConfirmedTargets returns the targets that can be sent to
+func (d *Delivery) ConfirmedTargets() []string {
+	var targets []string
+	for _, t := range d.Targets() {
+		if d.Confirmed(t) {
+			targets = append(targets, t)
+		}
+	}
+	return targets
+}
+
+// UnconfirmedTargets returns the targets waiting for the reader to follow
+// the confirmation link
+func (d *Delivery) UnconfirmedTargets() []string {
+	var targets []string
+	for _, t := range d.Targets() {
+		if !d.Confirmed(t) {
+			targets = append(targets, t)
+		}
+	}
+	return targets
+}
+
+// DueTargets returns the targets today's edition should be sent to, that
+// is they're confirmed, it's past the send time and they haven't been sent
+// anything since. Nothing is due while backing off after a failure.
+func (d *Delivery) DueTargets(now time.Time) []string {
+	if !d.Enabled || now.Before(d.RetryAt) {
+		return nil
+	}
+	at, err := time.Parse("15:04", d.SendTime)
+	if err != nil {
+		return nil
+	}
+	now = now.UTC()
+	sendAt := time.Date(now.Year(), now.Month(), now.Day(), at.Hour(), at.Minute(), 0, 0, time.UTC)
+	if now.Before(sendAt) {
+		return nil
+	}
+	var due []string
+	for _, t := range d.ConfirmedTargets() {
+		sent := d.EmailSent
+		if t == DeliveryTargetKindle {
+			sent = d.KindleSent
+		}
+		if sent.Before(sendAt) {
+			due = append(due, t)
+		}
+	}
+	return due
+}
+
+// Failed records a failed send, backing off before the next one and
+// turning delivery off once too many have failed in a row
+func (d *Delivery) Failed(now time.Time, err error) {
+	d.Failures++
+	d.LastError = err.Error()
+	d.RetryAt = now.Add(deliveryRetry << (d.Failures - 1))
+	if d.Failures >= MaxDeliveryFailures {
+		d.Enabled = false
+	}
+}
+
+// unsubscribeTokenLifetime is how long the unsubscribe link in an email
+// works for, long enough for old editions to still be read
+const unsubscribeTokenLifetime = 90 * 24 * time.Hour
+
+// UnsubscribeToken is a signed token that lets the reader turn off
+// delivery from a link in the email, without logging in. It expires so a
+// leaked email can't be used forever.
+func (d *Delivery) UnsubscribeToken(now time.Time) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"unsubscribe": d.OwnerID,
+		"iat":         now.Unix(),
+		"exp":         now.Add(unsubscribeTokenLifetime).Unix(),
+	})
+	return token.SignedString(TokenSecret())
+}
+
+// ParseUnsubscribeToken returns the owner ID an unsubscribe token was
+// issued for
+func ParseUnsubscribeToken(tok string) (string, error) {
+	claims, err := parseDeliveryToken(tok)
+	if err != nil {
+		return "", err
+	}
+	ownerID, ok := claims["unsubscribe"].(string)
+	if !ok || ownerID == "" {
+		return "", fmt.Errorf("invalid token")
+	}
+	return ownerID, nil
+}
+
+// confirmTokenLifetime is how long the link to confirm an address works for
+const confirmTokenLifetime = 7 * 24 * time.Hour
+
+// DeliveryConfirmation is a reader confirming editions can be sent to one
+// of their addresses
+type DeliveryConfirmation struct {
+	OwnerID string
+	Target  string
+	Address string
+}
+
+// ConfirmToken is a signed token for the link that confirms target's
+// address. It names the address, so it can't confirm one the reader has
+// changed to since.
+func (d *Delivery) ConfirmToken(target string, now time.Time) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"confirm": d.OwnerID,
+		"target":  target,
+		"address": d.Address(target),
+		"iat":     now.Unix(),
+		"exp":     now.Add(confirmTokenLifetime).Unix(),
+	})
+	return token.SignedString(TokenSecret())
+}
+
+// ParseConfirmToken returns the address a confirmation token was issued
+// for
+func ParseConfirmToken(tok string) (*DeliveryConfirmation, error) {
+	claims, err := parseDeliveryToken(tok)
+	if err != nil {
+		return nil, err
+	}
+	ownerID, _ := claims["confirm"].(string)
+	target, _ := claims["target"].(string)
+	address, _ := claims["address"].(string)
+	if ownerID == "" || address == "" || (target != DeliveryTargetEmail && target != DeliveryTargetKindle) {
+		return nil, fmt.Errorf("invalid token")
+	}
+	return &DeliveryConfirmation{OwnerID: ownerID, Target: target, Address: address}, nil
+}
+
+// parseDeliveryToken checks the signature and expiry of a token from a
+// delivery email
+func parseDeliveryToken(tok string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(tok, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+		}
+		return TokenSecret(), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, fmt.Errorf("invalid token")
+	}
+	// tokens without an expiry were issued before they had one
+	if _, ok := claims["exp"]; !ok {
+		return nil, fmt.Errorf("token has no expiry")
+	}
+	return claims, nil
+}
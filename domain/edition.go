@@ -98,3 +98,13 @@ func NewEdition(ctx context.Context, now time.Time) (*Edition, error) {
 
 	return &e, nil
 }
+
+// ApplyRules returns a copy of the edition with a reader's rules applied to
+// its articles, editions are shared between readers so this is done as the
+// edition is rendered rather than when it's generated
+func (e *Edition) ApplyRules(rules []Rule) (*Edition, []Article) {
+	out := *e
+	shown, hidden := ApplyRules(rules, e.Articles)
+	out.Articles = shown
+	return &out, hidden
+}
@@ -0,0 +1,30 @@
+package domain
+
+import "time"
+
+// FetchError is a failure while fetching a source's feed or one of its
+// articles, kept so admins can see what's broken without reading logs
+type FetchError struct {
+	ID         string
+	SourceID   string
+	SourceName string
+	Link       string
+	Error      string
+	Created    time.Time
+}
+
+// SourceHealth is how fetching a source has been going
+type SourceHealth struct {
+	Source
+	// Articles is how many articles were fetched from it in the window
+	Articles int
+	// Errors is how many fetches failed in the window
+	Errors    int
+	LastError string
+}
+
+// Healthy is true when the source has been fetched recently without
+// errors
+func (h *SourceHealth) Healthy(now time.Time) bool {
+	return h.DisableFetch || (h.Errors == 0 && now.Sub(h.LastFetchTime) < 24*time.Hour)
+}
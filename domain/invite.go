@@ -0,0 +1,55 @@
+package domain
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"net/url"
+	"time"
+)
+
+// Invite lets people sign up when signup is invite only
+type Invite struct {
+	Code      string
+	CreatedBy string
+	Created   time.Time
+	// Expires is zero for invites that don't expire
+	Expires time.Time
+	// MaxUses is zero for invites that can be used any number of times
+	MaxUses int
+	Uses    int
+}
+
+// NewInvite makes an invite with a random code. validFor and maxUses of
+// zero make an invite that doesn't expire or run out.
+func NewInvite(createdBy *User, validFor time.Duration, maxUses int, now time.Time) (*Invite, error) {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	i := &Invite{
+		Code:      base32.StdEncoding.EncodeToString(b),
+		CreatedBy: createdBy.ID,
+		Created:   now,
+		MaxUses:   maxUses,
+	}
+	if validFor > 0 {
+		i.Expires = now.Add(validFor)
+	}
+	return i, nil
+}
+
+// Valid reports whether the invite can still be used
+func (i *Invite) Valid(now time.Time) bool {
+	if i == nil {
+		return false
+	}
+	if !i.Expires.IsZero() && !now.Before(i.Expires) {
+		return false
+	}
+	return i.MaxUses == 0 || i.Uses < i.MaxUses
+}
+
+// Link is the signup page with the code filled in
+func (i *Invite) Link() string {
+	return PublicURL() + "/login?invite=" + url.QueryEscape(i.Code)
+}
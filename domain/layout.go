@@ -31,6 +31,18 @@ var (
 	LayoutBar = Layout{0, 12, 0, 0, 0}
 )
 
+var layouts = []Layout{Layout1, Layout2, Layout3, Layout4, Layout5, Layout6}
+
+// LayoutForSize returns the article layout of the given size
+func LayoutForSize(size int) (Layout, bool) {
+	for _, l := range layouts {
+		if l.Size == size {
+			return l, true
+		}
+	}
+	return Layout{}, false
+}
+
 var layoutSequence = []Layout{
 	Layout3,
 	Layout6,
@@ -94,10 +106,21 @@ var layoutSequence = []Layout{
 }
 
 func LayoutArticles(aa []Article) []Article {
+	// apply each source's article cap, and pull out
+	// articles pinned to the front so they lead the page
+	var pinned []Article
+	capped := []Article{}
+	for _, a := range SelectArticles(aa) {
+		if a.Source.PinSection == PinFront {
+			pinned = append(pinned, a)
+			continue
+		}
+		capped = append(capped, a)
+	}
 	// first let's group articles by size
 	bySize := make([][]Article, 6)
-	for _, a := range aa {
-		s := 0
+	for _, a := range capped {
+		s := a.Size()
 		switch {
 		case s < 200:
 			bySize[0] = append(bySize[0], a)
@@ -124,6 +147,15 @@ func LayoutArticles(aa []Article) []Article {
 			}
 			var sizes []int
 			var picked *Article
+			// pinned articles take the first slots, whatever their size
+			if len(pinned) > 0 {
+				a := pinned[0]
+				pinned = pinned[1:]
+				a.Layout = l
+				a.Content.TextContent = a.Summary(l.MaxChars)
+				out = append(out, a)
+				continue
+			}
 			// which sizes of article fit in this layout?
 			switch l.Size {
 			case 1:
@@ -165,23 +197,9 @@ func LayoutArticles(aa []Article) []Article {
 				sort.Slice(o, func(i, j int) bool {
 					return o[i][0].Source.Name < o[j][0].Source.Name
 				})
-				sorted := []Article{}
-				for len(o) > 0 {
-					for i := range o {
-						// pop the first item off each
-						// source onto the output slice
-						sorted = append(sorted, o[i][0])
-						o[i] = o[i][1:]
-					}
-					// have we used all the articles from a source?
-					// we now delete all empty source slices
-					for i, rlen := 0, len(o); i < rlen; i++ {
-						j := i - (rlen - len(o))
-						if len(o[j]) == 0 {
-							o = append(o[:j], o[j+1:]...)
-						}
-					}
-				}
+				// interleave the sources, giving heavier
+				// weighted sources proportionally more slots
+				sorted := interleave(o)
 				// assign the sorted slice back to the bySize array
 				// so we can pop this article off the list and keep
 				// track of it, whilst avoiding re-sorting
@@ -196,8 +214,10 @@ func LayoutArticles(aa []Article) []Article {
 				fmt.Println(len(out))
 				return out
 			}
-			// set the layout on the picked article
+			// set the layout on the picked article, and cut
+			// its text down to a summary that fits the tile
 			picked.Layout = l
+			picked.Content.TextContent = picked.Summary(l.MaxChars)
 
 			out = append(out, *picked)
 		}
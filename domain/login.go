@@ -0,0 +1,64 @@
+package domain
+
+import "time"
+
+// LoginAttempt is a record of someone trying to log in, kept to throttle
+// password guessing and so admins can see it happening
+type LoginAttempt struct {
+	ID       string
+	Username string
+	IP       string
+	Success  bool
+	Created  time.Time
+}
+
+// LoginPolicy is how many failed logins are let through before each
+// further attempt has to wait, and when attempts are refused outright
+type LoginPolicy struct {
+	// Free is how many failures there can be before attempts are delayed
+	Free int
+	// Lockout is how many failures lock out further attempts until the
+	// window has passed since the last one
+	Lockout int
+}
+
+const (
+	// LoginWindow is how far back failed logins are counted, and how long
+	// a lockout lasts
+	LoginWindow = 15 * time.Minute
+	// loginBaseDelay is the wait after the first failure past the free
+	// ones, it doubles with each failure after that
+	loginBaseDelay = time.Second
+)
+
+var (
+	// AccountLoginPolicy applies to failures for one username, from
+	// anywhere
+	AccountLoginPolicy = LoginPolicy{Free: 3, Lockout: 10}
+	// IPLoginPolicy applies to failures from one address, for any username.
+	// It's looser as people share addresses.
+	IPLoginPolicy = LoginPolicy{Free: 10, Lockout: 50}
+)
+
+// Wait is how much longer the next attempt has to wait, given the number
+// of recent failures and when the last one was. It's zero if the attempt
+// can go ahead.
+func (p LoginPolicy) Wait(failures int, last, now time.Time) time.Duration {
+	if failures <= p.Free {
+		return 0
+	}
+	delay := LoginWindow
+	if failures < p.Lockout {
+		// capped well before the shift overflows
+		if n := failures - p.Free - 1; n < 20 {
+			delay = loginBaseDelay << n
+		}
+		if delay > LoginWindow {
+			delay = LoginWindow
+		}
+	}
+	if wait := last.Add(delay).Sub(now); wait > 0 {
+		return wait
+	}
+	return 0
+}
@@ -0,0 +1,49 @@
+package domain
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginPolicyWait(t *testing.T) {
+	last := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	p := LoginPolicy{Free: 3, Lockout: 10}
+
+	tests := []struct {
+		name     string
+		failures int
+		since    time.Duration
+		want     time.Duration
+	}{
+		{"no failures", 0, 0, 0},
+		{"last free failure", 3, 0, 0},
+		{"first delayed failure", 4, 0, time.Second},
+		{"delay doubles", 5, 0, 2 * time.Second},
+		{"delay partly waited", 5, time.Second, time.Second},
+		{"delay waited", 5, 2 * time.Second, 0},
+		{"just before lockout", 9, 0, 32 * time.Second},
+		{"lockout", 10, 0, LoginWindow},
+		{"lockout partly waited", 10, time.Minute, LoginWindow - time.Minute},
+		{"lockout waited", 10, LoginWindow, 0},
+		{"well past lockout", 100, 0, LoginWindow},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := p.Wait(tt.failures, last, last.Add(tt.since)); got != tt.want {
+				t.Errorf("Wait(%d, last, last+%s) = %s, want %s", tt.failures, tt.since, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoginPolicyWaitCapped(t *testing.T) {
+	last := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	// a lockout far enough away that the doubling delay passes the window,
+	// and then the shift would overflow
+	p := LoginPolicy{Free: 0, Lockout: 1000}
+	for _, failures := range []int{11, 21, 64, 999} {
+		if got := p.Wait(failures, last, last); got != LoginWindow {
+			t.Errorf("Wait(%d) = %s, want %s", failures, got, LoginWindow)
+		}
+	}
+}
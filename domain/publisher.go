@@ -22,7 +22,7 @@ type Publisher interface {
 }
 
 func NewPubSubPublisher(ctx context.Context) (Publisher, error) {
-	ps, err := pubsub.NewClient(ctx, "russellsaw")
+	ps, err := pubsub.NewClient(ctx, config.GoogleProject)
 	if err != nil {
 		return nil, err
 	}
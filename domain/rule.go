@@ -0,0 +1,156 @@
+package domain
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Rule fields, what part of an article a rule's pattern is matched against
+const (
+	RuleKeyword  = "keyword"
+	RuleRegex    = "regex"
+	RuleAuthor   = "author"
+	RuleSource   = "source"
+	RuleCategory = "category"
+)
+
+// Rule actions, what happens to an article that matches a rule
+const (
+	RuleHide      = "hide"
+	RuleDemote    = "demote"
+	RuleHighlight = "highlight"
+)
+
+var (
+	RuleFields  = []string{RuleKeyword, RuleRegex, RuleAuthor, RuleSource, RuleCategory}
+	RuleActions = []string{RuleHide, RuleDemote, RuleHighlight}
+)
+
+// Rule is a reader's mute or filter rule
+type Rule struct {
+	ID      string
+	OwnerID string
+	Field   string
+	Pattern string
+	Action  string
+	Created time.Time
+
+	re *regexp.Regexp
+}
+
+// Validate checks the rule is well formed, and that regex patterns compile
+func (r *Rule) Validate() error {
+	if strings.TrimSpace(r.Pattern) == "" {
+		return fmt.Errorf("rule pattern can't be empty")
+	}
+	if !contains(RuleFields, r.Field) {
+		return fmt.Errorf("unknown rule field: %s", r.Field)
+	}
+	if !contains(RuleActions, r.Action) {
+		return fmt.Errorf("unknown rule action: %s", r.Action)
+	}
+	if r.Field == RuleRegex {
+		re, err := regexp.Compile("(?i)" + r.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern: %s", err)
+		}
+		r.re = re
+	}
+	return nil
+}
+
+// Match reports whether the article is matched by the rule
+func (r *Rule) Match(a *Article) bool {
+	pattern := strings.ToLower(strings.TrimSpace(r.Pattern))
+	switch r.Field {
+	case RuleKeyword:
+		return strings.Contains(strings.ToLower(a.Title), pattern) ||
+			strings.Contains(strings.ToLower(a.Description), pattern) ||
+			strings.Contains(strings.ToLower(a.Content.TextContent), pattern)
+	case RuleRegex:
+		if r.re == nil && r.Validate() != nil {
+			return false
+		}
+		return r.re.MatchString(a.Title) || r.re.MatchString(a.Description)
+	case RuleAuthor:
+		return strings.Contains(strings.ToLower(a.Author), pattern) ||
+			strings.Contains(strings.ToLower(a.Content.Byline), pattern)
+	case RuleSource:
+		return strings.ToLower(a.Source.Name) == pattern
+	case RuleCategory:
+		return a.HasCategory(pattern)
+	}
+	return false
+}
+
+// String describes the rule, this is shown to readers to explain why an
+// article was hidden
+func (r Rule) String() string {
+	return fmt.Sprintf("%s %q", r.Field, r.Pattern)
+}
+
+// ApplyRules runs a reader's rules over a list of articles. Hidden articles
+// are removed from the returned list and handed back separately with the
+// rule that hid them, demoted articles are moved to the end and highlighted
+// articles are moved to the front. A hide rule always beats a demote, which
+// beats a highlight.
+func ApplyRules(rules []Rule, articles []Article) (shown, hidden []Article) {
+	if len(rules) == 0 {
+		return articles, nil
+	}
+	var highlighted, normal, demoted []Article
+	for _, a := range articles {
+		var matched *Rule
+		for i := range rules {
+			r := &rules[i]
+			if !r.Match(&a) {
+				continue
+			}
+			if matched == nil || actionRank(r.Action) < actionRank(matched.Action) {
+				matched = r
+			}
+		}
+		switch {
+		case matched == nil:
+			normal = append(normal, a)
+		case matched.Action == RuleHide:
+			a.HiddenBy = matched.String()
+			hidden = append(hidden, a)
+		case matched.Action == RuleDemote:
+			a.Demoted = true
+			demoted = append(demoted, a)
+		case matched.Action == RuleHighlight:
+			a.Highlight = true
+			highlighted = append(highlighted, a)
+		}
+	}
+	shown = append(highlighted, normal...)
+	shown = append(shown, demoted...)
+	sort.SliceStable(hidden, func(i, j int) bool {
+		return hidden[i].Timestamp.After(hidden[j].Timestamp)
+	})
+	return shown, hidden
+}
+
+func actionRank(action string) int {
+	switch action {
+	case RuleHide:
+		return 0
+	case RuleDemote:
+		return 1
+	default:
+		return 2
+	}
+}
+
+func contains(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
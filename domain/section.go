@@ -0,0 +1,86 @@
+package domain
+
+import (
+	"sort"
+)
+
+// SectionMore collects articles that don't belong to any known category
+const SectionMore = "more"
+
+// Section is a named group of articles, like the "Tech" or "Food" pages of
+// a printed paper
+type Section struct {
+	Name     string
+	Articles []Article
+}
+
+// BuildSections places each article into exactly one section. Articles from
+// a source pinned to a section always go there, otherwise an article's own
+// topic tags win over its source's categories since they're more specific.
+// Sections are ordered largest first.
+func BuildSections(articles []Article, categories []string) []Section {
+	known := make(map[string]bool, len(categories))
+	for _, c := range categories {
+		known[c] = true
+	}
+
+	bySection := make(map[string][]Article)
+	for _, a := range articles {
+		name := sectionFor(a, known)
+		bySection[name] = append(bySection[name], a)
+	}
+
+	sections := make([]Section, 0, len(bySection))
+	for name, as := range bySection {
+		sections = append(sections, Section{Name: name, Articles: as})
+	}
+	sort.Slice(sections, func(i, j int) bool {
+		// always leave the catch all section until last
+		if (sections[i].Name == SectionMore) != (sections[j].Name == SectionMore) {
+			return sections[j].Name == SectionMore
+		}
+		if len(sections[i].Articles) != len(sections[j].Articles) {
+			return len(sections[i].Articles) > len(sections[j].Articles)
+		}
+		return sections[i].Name < sections[j].Name
+	})
+	return sections
+}
+
+func sectionFor(a Article, known map[string]bool) string {
+	if known[a.Source.PinSection] {
+		return a.Source.PinSection
+	}
+	for _, t := range a.Tags {
+		if known[t] {
+			return t
+		}
+	}
+	for _, c := range a.Source.Categories {
+		if known[c] {
+			return c
+		}
+	}
+	return SectionMore
+}
+
+// Sections splits the edition's articles into its section fronts
+func (e *Edition) Sections() []Section {
+	return BuildSections(e.Articles, e.Categories)
+}
+
+// Section returns the named section front of the edition, or nil if the
+// edition has no articles in that section
+func (e *Edition) Section(name string) *Section {
+	return FindSection(e.Sections(), name)
+}
+
+// FindSection returns the named section, or nil if there isn't one
+func FindSection(sections []Section, name string) *Section {
+	for _, s := range sections {
+		if s.Name == name {
+			return &s
+		}
+	}
+	return nil
+}
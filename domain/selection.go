@@ -0,0 +1,89 @@
+package domain
+
+import (
+	"sort"
+)
+
+// PinFront pins a source's articles to the top of the front page
+const PinFront = "front"
+
+// SelectArticles interleaves articles from each source so that a source
+// with twice the weight of another gets roughly twice as many slots. Each
+// source's articles are taken in order of the reader's rules, then newest
+// first, so its article cap drops demoted articles before highlighted ones.
+// Articles from sources pinned to the front page are moved to the start,
+// and then highlighted articles lead and demoted ones trail, as with
+// ApplyRules. Rules have to be applied before selecting.
+func SelectArticles(articles []Article) []Article {
+	bySource := make(map[string][]Article)
+	for _, a := range articles {
+		bySource[a.Source.FeedURL] = append(bySource[a.Source.FeedURL], a)
+	}
+	keys := make([]string, 0, len(bySource))
+	for k, as := range bySource {
+		sort.SliceStable(as, func(i, j int) bool {
+			if as[i].Priority() != as[j].Priority() {
+				return as[i].Priority() > as[j].Priority()
+			}
+			return as[i].Timestamp.After(as[j].Timestamp)
+		})
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var pinned, groups [][]Article
+	for _, k := range keys {
+		as := capArticles(bySource[k])
+		if as[0].Source.PinSection == PinFront {
+			pinned = append(pinned, as)
+			continue
+		}
+		groups = append(groups, as)
+	}
+	out := append(interleave(pinned), interleave(groups)...)
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].Priority() > out[j].Priority()
+	})
+	return out
+}
+
+// capArticles trims a single source's articles down to its cap
+func capArticles(as []Article) []Article {
+	max := as[0].Source.MaxArticles
+	if max > 0 && len(as) > max {
+		return as[:max]
+	}
+	return as
+}
+
+// interleave merges groups of articles using smooth weighted round robin,
+// each group's weight is taken from the source of its first article
+func interleave(groups [][]Article) []Article {
+	var (
+		out     []Article
+		current = make([]float64, len(groups))
+	)
+	for {
+		var (
+			total float64
+			best  = -1
+		)
+		for i, g := range groups {
+			if len(g) == 0 {
+				continue
+			}
+			w := g[0].Source.EffectiveWeight()
+			current[i] += w
+			total += w
+			if best == -1 || current[i] > current[best] {
+				best = i
+			}
+		}
+		if best == -1 {
+			return out
+		}
+		current[best] -= total
+		out = append(out, groups[best][0])
+		groups[best] = groups[best][1:]
+	}
+}
@@ -0,0 +1,129 @@
+package domain
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+const (
+	// SessionIdleTimeout is how long a session lasts without being used
+	SessionIdleTimeout = 14 * 24 * time.Hour
+	// SessionMaxAge is the longest a session lasts however often it's
+	// used, after which the reader has to log in again
+	SessionMaxAge = 90 * 24 * time.Hour
+	// SessionRefresh is how often the token in the cookie is reissued,
+	// pushing back the session's expiry
+	SessionRefresh = 24 * time.Hour
+)
+
+// Session is a login on one device. The cookie holds a signed token
+// naming the session, so it can be revoked by marking the record here.
+type Session struct {
+	ID        string
+	UserID    string
+	Created   time.Time
+	Expires   time.Time
+	LastSeen  time.Time
+	UserAgent string
+	Revoked   bool
+}
+
+// NewSession starts a session for u
+func NewSession(u *User, userAgent string, now time.Time) (*Session, error) {
+	b := make([]byte, 18)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	s := &Session{
+		ID:        hex.EncodeToString(b),
+		UserID:    u.ID,
+		Created:   now,
+		UserAgent: userAgent,
+	}
+	s.Refresh(now)
+	return s, nil
+}
+
+// Refresh pushes back the session's expiry, up to its maximum age
+func (s *Session) Refresh(now time.Time) {
+	s.LastSeen = now
+	s.Expires = now.Add(SessionIdleTimeout)
+	if max := s.Created.Add(SessionMaxAge); s.Expires.After(max) {
+		s.Expires = max
+	}
+}
+
+// Valid reports whether the session can still be used
+func (s *Session) Valid(now time.Time) bool {
+	return s != nil && !s.Revoked && now.Before(s.Expires)
+}
+
+// Token is the signed token stored in the session cookie, it expires with
+// the session
+func (s *Session) Token(now time.Time) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user": s.UserID,
+		"sid":  s.ID,
+		"iat":  now.Unix(),
+		"exp":  s.Expires.Unix(),
+	})
+	return token.SignedString(TokenSecret())
+}
+
+// SessionClaims are what a session token says about itself, they have to
+// be checked against the stored session
+type SessionClaims struct {
+	UserID    string
+	SessionID string
+	Issued    time.Time
+}
+
+// ParseSessionToken checks a session token's signature and expiry
+func ParseSessionToken(tok string) (*SessionClaims, error) {
+	token, err := jwt.Parse(tok, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+		}
+		return TokenSecret(), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, fmt.Errorf("invalid token")
+	}
+	// tokens without an expiry were issued before sessions were stored
+	if _, ok := claims["exp"]; !ok {
+		return nil, fmt.Errorf("token has no expiry")
+	}
+	c := &SessionClaims{}
+	c.UserID, _ = claims["user"].(string)
+	c.SessionID, _ = claims["sid"].(string)
+	if c.UserID == "" || c.SessionID == "" {
+		return nil, fmt.Errorf("invalid token")
+	}
+	if iat, ok := claims["iat"].(float64); ok {
+		c.Issued = time.Unix(int64(iat), 0)
+	}
+	return c, nil
+}
+
+const sessionKey contextKey = "session"
+
+func WithSession(ctx context.Context, s *Session) context.Context {
+	return context.WithValue(ctx, sessionKey, s)
+}
+
+func SessionFromContext(ctx context.Context) *Session {
+	s, ok := ctx.Value(sessionKey).(*Session)
+	if !ok {
+		return nil
+	}
+	return s
+}
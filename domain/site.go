@@ -0,0 +1,39 @@
+package domain
+
+// PublicURL is where the site is served from, the config's base URL. It's
+// used for links that leave the site, in emails and webhooks.
+func PublicURL() string {
+	return config.BaseURL
+}
+
+// TokenSecret is the key that session, unsubscribe and image tokens are
+// signed with
+func TokenSecret() []byte {
+	return []byte(config.TokenSecret)
+}
+
+// SignupMode is who can create an account, set with signup_mode
+type SignupMode string
+
+const (
+	// SignupOpen lets anyone sign up
+	SignupOpen SignupMode = "open"
+	// SignupInvite needs an invite code made by an admin
+	SignupInvite SignupMode = "invite"
+	// SignupApproval lets anyone sign up, but they can't log in until an
+	// admin approves the account
+	SignupApproval SignupMode = "approval"
+	// SignupDisabled only lets admins add accounts
+	SignupDisabled SignupMode = "disabled"
+)
+
+// Signup returns the signup mode, it's open unless the config says
+// otherwise. An unknown mode is treated as disabled, though the server
+// won't start with one.
+func Signup() SignupMode {
+	switch m := config.SignupMode; m {
+	case SignupOpen, SignupInvite, SignupApproval, SignupDisabled:
+		return m
+	}
+	return SignupDisabled
+}
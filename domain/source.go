@@ -16,6 +16,26 @@ type Source struct {
     DisableFetch  bool
     LastFetchTime time.Time
 	LayoutID 	  string
+	// Weight scales how often the source is picked relative to others,
+	// zero is treated as the default weight of 1
+	Weight        float64
+	// MaxArticles caps how many of the source's articles are used in an
+	// edition or on the front page, zero means no cap
+	MaxArticles   int
+	// PinSection pins the source's articles to a section
+	PinSection    string
+}
+
+// MaxSourceWeight is the largest weight a source can have, past it one
+// source would crowd out all the others
+const MaxSourceWeight = 100
+
+// EffectiveWeight returns the source's weight, defaulting to 1
+func (s Source) EffectiveWeight() float64 {
+	if s.Weight <= 0 {
+		return 1
+	}
+	return s.Weight
 }
 
 var (
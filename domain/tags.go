@@ -0,0 +1,85 @@
+package domain
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/RusticPotatoes/news/pkg/classify"
+)
+
+// tagRules map a category onto keywords that are a strong enough signal on
+// their own to tag an article, regardless of what the classifier thinks
+var tagRules = map[string][]string{
+	"games":       {"video game", "videogame", "playstation", "xbox", "nintendo", "steam deck", "esports", "game pass"},
+	"food":        {"recipe", "restaurant", "cooking", "baking", "chef"},
+	"programming": {"programming", "compiler", "golang", "rust", "javascript", "python", "open source", "github"},
+	"tech":        {"smartphone", "iphone", "android", "startup", "artificial intelligence", "silicon valley"},
+	"electronics": {"gpu", "cpu", "processor", "semiconductor", "soldering", "raspberry pi", "arduino"},
+	"science":     {"scientists", "astronomers", "nasa", "physics", "climate change"},
+}
+
+// InferTags sets the article's topic tags from the classifier, keyword rules
+// and the tags supplied by the publisher.
+func (a *Article) InferTags(c *classify.Classifier, keywords []string) {
+	text := a.Title + " " + a.Description + " " + a.Content.TextContent
+
+	tags := make(map[string]struct{})
+	if c != nil {
+		for _, t := range c.Classify(text) {
+			tags[t] = struct{}{}
+		}
+	}
+
+	fields := strings.FieldsFunc(strings.ToLower(a.Title+" "+a.Description), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	lower := " " + strings.Join(fields, " ") + " "
+	for cat, words := range tagRules {
+		for _, w := range words {
+			if strings.Contains(lower, " "+w+" ") {
+				tags[cat] = struct{}{}
+				break
+			}
+		}
+	}
+
+	// publisher keywords only count when they name a category we know about
+	known := make(map[string]struct{})
+	for _, s := range sources {
+		for _, cat := range s.Categories {
+			known[cat] = struct{}{}
+		}
+	}
+	for cat := range tagRules {
+		known[cat] = struct{}{}
+	}
+	for _, k := range keywords {
+		k = strings.ToLower(strings.TrimSpace(k))
+		if _, ok := known[k]; ok {
+			tags[k] = struct{}{}
+		}
+	}
+
+	a.Tags = make([]string, 0, len(tags))
+	for t := range tags {
+		a.Tags = append(a.Tags, t)
+	}
+	sort.Strings(a.Tags)
+}
+
+// HasCategory reports whether the article belongs to a category, either via
+// its source or its own topic tags
+func (a *Article) HasCategory(cat string) bool {
+	for _, c := range a.Source.Categories {
+		if c == cat {
+			return true
+		}
+	}
+	for _, t := range a.Tags {
+		if t == cat {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,146 @@
+package domain
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Scopes limit what an API token can be used for
+const (
+	// ScopeReadArticles reads the front page, editions, articles and search
+	ScopeReadArticles = "articles:read"
+	// ScopeSources lists, adds and removes the reader's sources
+	ScopeSources = "sources:write"
+	// ScopeAdmin uses the admin console, for admins only
+	ScopeAdmin = "admin"
+	// ScopeMetrics scrapes /metrics and nothing else, so monitoring
+	// doesn't hold an admin token. It's for admins only too.
+	ScopeMetrics = "metrics:read"
+)
+
+var TokenScopes = []string{ScopeReadArticles, ScopeSources, ScopeAdmin, ScopeMetrics}
+
+// apiTokenPrefix starts every token, so leaked tokens are easy to spot
+const apiTokenPrefix = "news_"
+
+// APIToken lets scripts act as a reader without their password or session
+// cookie. Only a hash of the token is kept, it's shown once when made.
+type APIToken struct {
+	ID       string
+	UserID   string
+	Name     string
+	Hash     []byte `json:"-"`
+	Scopes   []string
+	Created  time.Time
+	LastUsed time.Time
+	// Expires is zero for tokens that don't expire
+	Expires time.Time
+	Revoked bool
+}
+
+// NewAPIToken makes a token for u, returning it along with the secret to
+// give the reader. validFor of zero makes a token that doesn't expire.
+func NewAPIToken(u *User, name string, scopes []string, validFor time.Duration, now time.Time) (*APIToken, string, error) {
+	err := ValidateScopes(u, scopes)
+	if err != nil {
+		return nil, "", err
+	}
+	id := make([]byte, 6)
+	secret := make([]byte, 24)
+	if _, err := rand.Read(id); err != nil {
+		return nil, "", err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return nil, "", err
+	}
+	t := &APIToken{
+		ID:      hex.EncodeToString(id),
+		UserID:  u.ID,
+		Name:    name,
+		Scopes:  scopes,
+		Created: now,
+	}
+	if validFor > 0 {
+		t.Expires = now.Add(validFor)
+	}
+	token := apiTokenPrefix + t.ID + "_" + base64.RawURLEncoding.EncodeToString(secret)
+	t.Hash = hashAPIToken(token)
+	return t, token, nil
+}
+
+// ValidateScopes checks scopes are known, and that only admins get the
+// admin and metrics scopes
+func ValidateScopes(u *User, scopes []string) error {
+	if len(scopes) == 0 {
+		return fmt.Errorf("tokens need at least one scope")
+	}
+	for _, s := range scopes {
+		if !contains(TokenScopes, s) {
+			return fmt.Errorf("unknown scope: %s", s)
+		}
+		if AdminScope(s) && !u.IsAdmin {
+			return fmt.Errorf("only admins can make %s tokens", s)
+		}
+	}
+	return nil
+}
+
+// AdminScope reports whether only admins can make tokens with scope
+func AdminScope(scope string) bool {
+	return scope == ScopeAdmin || scope == ScopeMetrics
+}
+
+// APITokenID is the ID in a token, so it can be looked up before the
+// secret is checked
+func APITokenID(token string) (string, bool) {
+	rest, ok := strings.CutPrefix(token, apiTokenPrefix)
+	if !ok {
+		return "", false
+	}
+	id, _, ok := strings.Cut(rest, "_")
+	return id, ok && id != ""
+}
+
+// Check reports whether token is this token's secret
+func (t *APIToken) Check(token string) bool {
+	return subtle.ConstantTimeCompare(hashAPIToken(token), t.Hash) == 1
+}
+
+// Valid reports whether the token can still be used
+func (t *APIToken) Valid(now time.Time) bool {
+	return t != nil && !t.Revoked && (t.Expires.IsZero() || now.Before(t.Expires))
+}
+
+func (t *APIToken) HasScope(scope string) bool {
+	return contains(t.Scopes, scope)
+}
+
+// tokens are random enough that a plain hash can't be brute forced, and
+// it's cheap enough to check on every request
+func hashAPIToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
+
+const apiTokenKey contextKey = "api_token"
+
+func WithAPIToken(ctx context.Context, t *APIToken) context.Context {
+	return context.WithValue(ctx, apiTokenKey, t)
+}
+
+// APITokenFromContext is the token the request was made with, it's nil for
+// requests from the browser
+func APITokenFromContext(ctx context.Context) *APIToken {
+	t, ok := ctx.Value(apiTokenKey).(*APIToken)
+	if !ok {
+		return nil
+	}
+	return t
+}
@@ -0,0 +1,98 @@
+package domain
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAPITokenID(t *testing.T) {
+	tests := []struct {
+		name   string
+		token  string
+		want   string
+		wantOK bool
+	}{
+		{"token", "news_0a1b2c_c2VjcmV0", "0a1b2c", true},
+		{"secret with underscores", "news_0a1b2c_c2V_jcmV0", "0a1b2c", true},
+		{"empty secret", "news_0a1b2c_", "0a1b2c", true},
+		{"no prefix", "0a1b2c_c2VjcmV0", "", false},
+		{"other prefix", "ghp_0a1b2c_c2VjcmV0", "", false},
+		{"no secret", "news_0a1b2c", "", false},
+		{"empty ID", "news__c2VjcmV0", "", false},
+		{"prefix only", "news_", "", false},
+		{"empty", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := APITokenID(tt.token)
+			if ok != tt.wantOK || (ok && got != tt.want) {
+				t.Errorf("APITokenID(%q) = %q, %v, want %q, %v", tt.token, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestAPITokenCheck(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	u := &User{ID: "1", Name: "reader"}
+	tok, secret, err := NewAPIToken(u, "script", []string{ScopeReadArticles}, 0, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, other, err := NewAPIToken(u, "other", []string{ScopeReadArticles}, 0, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id, ok := APITokenID(secret); !ok || id != tok.ID {
+		t.Errorf("APITokenID(new token) = %q, %v, want %q, true", id, ok, tok.ID)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		want  bool
+	}{
+		{"its secret", secret, true},
+		{"another token", other, false},
+		{"truncated", secret[:len(secret)-1], false},
+		{"extended", secret + "x", false},
+		{"empty", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tok.Check(tt.token); got != tt.want {
+				t.Errorf("Check(%q) = %v, want %v", tt.token, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewAPITokenScopes(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	reader := &User{ID: "1", Name: "reader"}
+	admin := &User{ID: "2", Name: "admin", IsAdmin: true}
+
+	tests := []struct {
+		name    string
+		user    *User
+		scopes  []string
+		wantErr bool
+	}{
+		{"reader scope", reader, []string{ScopeReadArticles, ScopeSources}, false},
+		{"admin scope for an admin", admin, []string{ScopeAdmin}, false},
+		{"metrics scope for an admin", admin, []string{ScopeMetrics}, false},
+		{"admin scope for a reader", reader, []string{ScopeAdmin}, true},
+		{"metrics scope for a reader", reader, []string{ScopeMetrics}, true},
+		{"unknown scope", admin, []string{"everything"}, true},
+		{"no scopes", admin, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := NewAPIToken(tt.user, "script", tt.scopes, 0, now)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewAPIToken(%v) error = %v, want error %v", tt.scopes, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -3,10 +3,8 @@ package domain
 import (
 	"context"
 	"log"
-	"os"
 	"time"
 
-	"github.com/dgrijalva/jwt-go"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -48,6 +46,36 @@ type User struct {
 	Created      time.Time
 	PasswordHash []byte `json:"-"`
 	IsAdmin      bool
+	LastLogin    time.Time
+	// Pending accounts are waiting for an admin to approve them, and
+	// can't log in
+	Pending bool
+	// Email is set for accounts that log in with single sign-on, it's the
+	// verified address the provider gave
+	Email string
+}
+
+// MinPasswordLength applies to every password set, when signing up or
+// after by the reader, an admin or newsctl
+const MinPasswordLength = 8
+
+// GuestName is reserved, nobody can sign up or be given an account with it
+const GuestName = "guest"
+
+// SetPassword replaces the user's password
+func (u *User) SetPassword(password string) error {
+	hashed, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	u.PasswordHash = hashed
+	return nil
+}
+
+// HasPassword is false for accounts made by single sign-on, until they
+// set one
+func (u *User) HasPassword() bool {
+	return len(u.PasswordHash) > 0
 }
 
 func (u *User) ValidatePassword(password string) bool {
@@ -58,14 +86,6 @@ func (u *User) ValidatePassword(password string) bool {
     return err == nil
 }
 
-func (u *User) Session() (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user": u.ID,
-	})
-
-	return token.SignedString([]byte(os.Getenv("TOKEN_SECRET")))
-}
-
 func hashPassword(pw string) ([]byte, error) {
     hashed, err := bcrypt.GenerateFromPassword([]byte(pw), bcrypt.DefaultCost)
     if err != nil {
@@ -0,0 +1,143 @@
+package domain
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/RusticPotatoes/news/pkg/safehttp"
+)
+
+// Webhook events
+const (
+	EventArticle = "article"
+	EventEdition = "edition"
+)
+
+var WebhookEvents = []string{EventArticle, EventEdition}
+
+// Webhook delivery statuses
+const (
+	WebhookPending   = "pending"
+	WebhookDelivered = "delivered"
+	WebhookFailed    = "failed"
+)
+
+// WebhookMaxAttempts is how many times a delivery is tried before it's
+// marked as failed
+const WebhookMaxAttempts = 6
+
+// Webhook posts events to a reader's URL. Article webhooks only fire for
+// articles matching their filter, which works like a rule's field and
+// pattern, an empty pattern matches every article.
+type Webhook struct {
+	ID      string
+	OwnerID string
+	URL     string
+	// Secret signs each request body, so the receiver can check it came
+	// from us
+	Secret  string
+	Event   string
+	Field   string
+	Pattern string
+	Created time.Time
+}
+
+// WebhookFields are the filters an article webhook can use
+var WebhookFields = []string{RuleSource, RuleCategory, RuleKeyword}
+
+// Validate checks the webhook is well formed, and doesn't point at the
+// server's own network
+func (w *Webhook) Validate() error {
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid webhook url: %s", w.URL)
+	}
+	if err := safehttp.CheckURL(u); err != nil {
+		return fmt.Errorf("invalid webhook url: %w", err)
+	}
+	if !contains(WebhookEvents, w.Event) {
+		return fmt.Errorf("unknown webhook event: %s", w.Event)
+	}
+	if w.Event == EventArticle && w.Pattern != "" && !contains(WebhookFields, w.Field) {
+		return fmt.Errorf("unknown webhook filter: %s", w.Field)
+	}
+	return nil
+}
+
+// Matches reports whether a new article should be sent to the webhook
+func (w *Webhook) Matches(a *Article) bool {
+	if w.Event != EventArticle {
+		return false
+	}
+	if w.Pattern == "" {
+		return true
+	}
+	r := Rule{Field: w.Field, Pattern: w.Pattern}
+	return r.Match(a)
+}
+
+// Sign returns the signature of a request body, sent in the
+// X-Webhook-Signature header
+func (w *Webhook) Sign(body []byte) string {
+	mac := hmac.New(sha256.New, []byte(w.Secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewWebhookSecret generates a random signing secret
+func NewWebhookSecret() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// WebhookDelivery is a single event sent, or being sent, to a webhook
+type WebhookDelivery struct {
+	ID          string
+	WebhookID   string
+	OwnerID     string
+	Event       string
+	Payload     []byte
+	Status      string
+	Attempts    int
+	StatusCode  int
+	Error       string
+	NextAttempt time.Time
+	Created     time.Time
+	Updated     time.Time
+}
+
+// Failed records a failed attempt, scheduling a retry with exponential
+// backoff until it runs out of attempts
+func (d *WebhookDelivery) Failed(now time.Time, statusCode int, err string) {
+	d.Attempts++
+	d.StatusCode = statusCode
+	d.Error = err
+	d.Updated = now
+	if d.Attempts >= WebhookMaxAttempts {
+		d.Status = WebhookFailed
+		return
+	}
+	backoff := 30 * time.Second << (d.Attempts - 1)
+	if backoff > time.Hour {
+		backoff = time.Hour
+	}
+	d.Status = WebhookPending
+	d.NextAttempt = now.Add(backoff)
+}
+
+// Delivered records a successful attempt
+func (d *WebhookDelivery) Delivered(now time.Time, statusCode int) {
+	d.Attempts++
+	d.StatusCode = statusCode
+	d.Error = ""
+	d.Status = WebhookDelivered
+	d.Updated = now
+}
@@ -0,0 +1,126 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/monzo/slog"
+
+	"github.com/RusticPotatoes/news/dao"
+	"github.com/RusticPotatoes/news/domain"
+)
+
+// apiTokenTouch is how stale a token's last used time can get before it's
+// written again, so busy scripts don't write on every request
+const apiTokenTouch = time.Minute
+
+// tokenScopes is the scope a token needs for each route, by its path
+// template. Tokens can't be used for anything else, such as changing the
+// reader's password or making more tokens.
+var tokenScopes = map[string]string{
+	"/":                                      domain.ScopeReadArticles,
+	"/article":                               domain.ScopeReadArticles,
+	"/section/{category}":                    domain.ScopeReadArticles,
+	"/edition/{id:[0-9]+}":                   domain.ScopeReadArticles,
+	"/edition/{id:[0-9]+}/section/{section}": domain.ScopeReadArticles,
+	"/edition/{id:[0-9]+}.pdf":               domain.ScopeReadArticles,
+	"/edition/{id:[0-9]+}.epub":              domain.ScopeReadArticles,
+	"/search":                                domain.ScopeReadArticles,
+	"/image":                                 domain.ScopeReadArticles,
+	"/api/articles":                          domain.ScopeReadArticles,
+	"/api/sources":                           domain.ScopeSources,
+	"/api/sources/{id}":                      domain.ScopeSources,
+	"/admin":                                 domain.ScopeAdmin,
+	"/admin/user":                            domain.ScopeAdmin,
+	"/admin/job":                             domain.ScopeAdmin,
+	"/admin/invite":                          domain.ScopeAdmin,
+	"/admin/edition/generate":                domain.ScopeAdmin,
+	"/metrics":                               domain.ScopeMetrics,
+}
+
+// bearerToken returns the request's bearer token, if its Authorization
+// header has one
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// tokenAuth authenticates a request by its bearer token instead of the
+// session cookie
+func tokenAuth(w http.ResponseWriter, r *http.Request, next http.Handler, token string) {
+	ctx := r.Context()
+	id, ok := domain.APITokenID(token)
+	if !ok {
+		tokenError(w, "malformed token")
+		return
+	}
+
+	now := time.Now()
+	t, err := dao.GetAPIToken(ctx, id)
+	if err != nil {
+		httpError(ctx, w, "error checking token", err)
+		return
+	}
+	if t == nil || !t.Check(token) || !t.Valid(now) {
+		slog.Info(ctx, "Rejected API token %s", id)
+		tokenError(w, "invalid, expired or revoked token")
+		return
+	}
+	u, err := dao.GetUser(ctx, t.UserID)
+	if err != nil {
+		httpError(ctx, w, "error checking token", err)
+		return
+	}
+	if u == nil || u.Pending {
+		tokenError(w, "invalid, expired or revoked token")
+		return
+	}
+
+	if now.Sub(t.LastUsed) > apiTokenTouch {
+		err = dao.SetAPITokenLastUsed(ctx, t.ID, now)
+		if err != nil {
+			slog.Error(ctx, "Error updating token last used: %s", err)
+		}
+	}
+
+	ctx = domain.WithUser(ctx, u)
+	ctx = domain.WithAPIToken(ctx, t)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+func tokenError(w http.ResponseWriter, msg string) {
+	w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+	http.Error(w, msg, http.StatusUnauthorized)
+}
+
+// tokenScopeMiddleware refuses requests made with a token that doesn't
+// have the route's scope, or to routes tokens can't be used for. It runs
+// after routing, so it can see which route matched.
+func tokenScopeMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t := domain.APITokenFromContext(r.Context())
+		if t == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		var scope string
+		if route := mux.CurrentRoute(r); route != nil {
+			tmpl, _ := route.GetPathTemplate()
+			scope = tokenScopes[tmpl]
+		}
+		if scope == "" {
+			http.Error(w, "API tokens can't be used here", http.StatusForbidden)
+			return
+		}
+		if !t.HasScope(scope) {
+			http.Error(w, "token needs the "+scope+" scope", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
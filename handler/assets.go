@@ -0,0 +1,205 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+	"time"
+)
+
+// assetMaxAge is how long browsers keep fingerprinted static files, their
+// URL changes whenever they do
+const assetMaxAge = 365 * 24 * time.Hour
+
+// templateSets are the files under tmpl/ each template is parsed from, by
+// the name handlers ask for. Pages are drawn inside frame.html.
+var templateSets = map[string][]string{
+	"frontpage-1.html":     {"frame.html", "meta.html", "frontpage-1.html", "section.html", "article-tile.html", "contents.html"},
+	"section-page.html":    {"frame.html", "meta.html", "section-page.html", "contents.html", "article-tile.html"},
+	"article.html":         {"frame.html", "meta.html", "article.html"},
+	"search.html":          {"frame.html", "meta.html", "search.html"},
+	"login.html":           {"frame.html", "meta.html", "login.html"},
+	"settings.html":        {"frame.html", "meta.html", "settings.html"},
+	"settings_source.html": {"frame.html", "meta.html", "settings_source.html"},
+	"account.html":         {"frame.html", "meta.html", "account.html"},
+	"admin.html":           {"frame.html", "meta.html", "admin.html"},
+	"unsubscribe.html":     {"frame.html", "meta.html", "unsubscribe.html"},
+	"confirm.html":         {"frame.html", "meta.html", "confirm.html"},
+	"email-edition.html":   {"email-edition.html"},
+	"email-edition.txt":    {"email-edition.txt"},
+	"email-confirm.txt":    {"email-confirm.txt"},
+}
+
+// templateFuncs can be used in any template
+var templateFuncs = map[string]interface{}{
+	"safeHTML":  safeHTML,
+	"asset":     assetURL,
+	"tileImage": tileImageURL,
+}
+
+var (
+	templates *templateRegistry
+	assets    *assetRegistry
+)
+
+// executor is a parsed html or text template
+type executor interface {
+	Execute(w io.Writer, data interface{}) error
+}
+
+// templateRegistry parses every template once. In dev mode a template is
+// parsed again when any of its files change.
+type templateRegistry struct {
+	fsys fs.FS
+	dev  bool
+
+	mu      sync.Mutex
+	parsed  map[string]executor
+	modTime map[string]time.Time
+}
+
+func newTemplateRegistry(fsys fs.FS, dev bool) (*templateRegistry, error) {
+	r := &templateRegistry{
+		fsys:    fsys,
+		dev:     dev,
+		parsed:  make(map[string]executor),
+		modTime: make(map[string]time.Time),
+	}
+	for name := range templateSets {
+		t, err := r.parse(name)
+		if err != nil {
+			return nil, err
+		}
+		r.parsed[name] = t
+		r.modTime[name] = r.newest(name)
+	}
+	return r, nil
+}
+
+// get returns the named template from templateSets
+func (r *templateRegistry) get(name string) (executor, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.parsed[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown template %s", name)
+	}
+	if !r.dev {
+		return t, nil
+	}
+	if newest := r.newest(name); newest.After(r.modTime[name]) {
+		t, err := r.parse(name)
+		if err != nil {
+			return nil, err
+		}
+		r.parsed[name] = t
+		r.modTime[name] = newest
+		return t, nil
+	}
+	return t, nil
+}
+
+func (r *templateRegistry) parse(name string) (executor, error) {
+	files := templateSets[name]
+	paths := make([]string, len(files))
+	for i, f := range files {
+		paths[i] = path.Join("tmpl", f)
+	}
+	if strings.HasSuffix(name, ".txt") {
+		t, err := texttemplate.New(files[0]).Funcs(texttemplate.FuncMap(templateFuncs)).ParseFS(r.fsys, paths...)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing %s: %w", name, err)
+		}
+		return t, nil
+	}
+	t, err := htmltemplate.New(files[0]).Funcs(htmltemplate.FuncMap(templateFuncs)).ParseFS(r.fsys, paths...)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", name, err)
+	}
+	return t, nil
+}
+
+// newest is the latest modification time of the template's files, embedded
+// files don't have one so it's only useful in dev mode
+func (r *templateRegistry) newest(name string) time.Time {
+	var newest time.Time
+	for _, f := range templateSets[name] {
+		info, err := fs.Stat(r.fsys, path.Join("tmpl", f))
+		if err == nil && info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+	}
+	return newest
+}
+
+// assetRegistry serves static/ and fingerprints each file by its content,
+// so it can be cached for a long time
+type assetRegistry struct {
+	files http.Handler
+	dev   bool
+	// versions are a hash of each file's content, by URL path
+	versions map[string]string
+}
+
+func newAssetRegistry(fsys fs.FS, dev bool) (*assetRegistry, error) {
+	static, err := fs.Sub(fsys, "static")
+	if err != nil {
+		return nil, err
+	}
+	a := &assetRegistry{
+		files:    http.StripPrefix("/static/", http.FileServer(http.FS(static))),
+		dev:      dev,
+		versions: make(map[string]string),
+	}
+	if dev {
+		return a, nil
+	}
+	err = fs.WalkDir(fsys, "static", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		b, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(b)
+		a.versions["/"+p] = hex.EncodeToString(sum[:])[:12]
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading static files: %w", err)
+	}
+	return a, nil
+}
+
+// assetURL is the fingerprinted URL of a static file, such as
+// /static/main.css
+func assetURL(p string) string {
+	if assets == nil {
+		return p
+	}
+	if v, ok := assets.versions[p]; ok {
+		return p + "?v=" + v
+	}
+	return p
+}
+
+// ServeHTTP serves static files, fingerprinted URLs are cached for a year
+// and anything else has to be checked again
+func (a *assetRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	v := r.URL.Query().Get("v")
+	if !a.dev && v != "" && v == a.versions[r.URL.Path] {
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d, immutable", int(assetMaxAge.Seconds())))
+	} else {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+	a.files.ServeHTTP(w, r)
+}
@@ -0,0 +1,113 @@
+package handler
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/monzo/slog"
+
+	"github.com/RusticPotatoes/news/domain"
+)
+
+const (
+	// csrfField is the form field, and csrfHeader the header for scripts,
+	// that state changing requests have to carry the token in
+	csrfField  = "csrf_token"
+	csrfHeader = "X-CSRF-Token"
+	// csrfCookie identifies readers who aren't logged in, so the login and
+	// signup forms are protected too
+	csrfCookie = "csrf"
+)
+
+// csrfExempt are paths that are posted to from outside the site, and
+// authenticate the request another way
+var csrfExempt = map[string]bool{
+	// one click unsubscribe is posted by mail clients, with a signed token
+	"/settings/delivery/unsubscribe": true,
+	// confirming an address can be done from wherever the email was read,
+	// and is posted with a signed token
+	"/settings/delivery/confirm": true,
+}
+
+type csrfKey struct{}
+
+// csrfToken is the token forms on the page have to include
+func csrfToken(ctx context.Context) string {
+	tok, _ := ctx.Value(csrfKey{}).(string)
+	return tok
+}
+
+// csrfFor derives the token from the session, or for readers who aren't
+// logged in from their csrf cookie, so it needn't be stored
+func csrfFor(id string) string {
+	mac := hmac.New(sha256.New, domain.TokenSecret())
+	mac.Write([]byte("csrf:" + id))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// csrfMiddleware rejects state changing requests that don't carry the
+// token for the reader's session, and adds the token to the context for
+// templates. It has to run inside sessionMiddleware.
+func csrfMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		// browsers don't send bearer tokens by themselves, so requests
+		// made with one can't be forged
+		if domain.APITokenFromContext(ctx) != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var id string
+		if sess := domain.SessionFromContext(ctx); sess != nil {
+			id = "session:" + sess.ID
+		} else {
+			cookie, err := r.Cookie(csrfCookie)
+			if err == nil && cookie.Value != "" {
+				id = "anon:" + cookie.Value
+			} else {
+				b := make([]byte, 16)
+				if _, err := rand.Read(b); err != nil {
+					slog.Error(ctx, "Error generating csrf cookie: %s", err)
+					http.Error(w, "error generating csrf token", 500)
+					return
+				}
+				value := hex.EncodeToString(b)
+				http.SetCookie(w, &http.Cookie{
+					Name:     csrfCookie,
+					Value:    value,
+					Path:     "/",
+					HttpOnly: true,
+					Secure:   secureRequest(r),
+					SameSite: http.SameSiteLaxMode,
+				})
+				id = "anon:" + value
+			}
+		}
+		expected := csrfFor(id)
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			if csrfExempt[r.URL.Path] {
+				break
+			}
+			got := r.Header.Get(csrfHeader)
+			if got == "" {
+				got = r.PostFormValue(csrfField)
+			}
+			if !hmac.Equal([]byte(got), []byte(expected)) {
+				slog.Warn(ctx, "Rejected %s %s with a missing or invalid csrf token", r.Method, r.URL.Path)
+				http.Error(w, "invalid csrf token, reload the page and try again", http.StatusForbidden)
+				return
+			}
+		}
+
+		ctx = context.WithValue(ctx, csrfKey{}, expected)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,302 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/monzo/slog"
+
+	"github.com/RusticPotatoes/news/dao"
+	"github.com/RusticPotatoes/news/domain"
+	"github.com/RusticPotatoes/news/pkg/mailer"
+)
+
+// emailSummaryLength is the length of each story's summary in emailed
+// editions
+const emailSummaryLength = 300
+
+// SendDeliveries emails the current edition to every reader whose send time
+// has passed today and who hasn't been sent it yet
+func SendDeliveries(ctx context.Context) error {
+	cfg := config.SMTP
+	if !cfg.Enabled() {
+		return nil
+	}
+	deliveries, err := dao.GetEnabledDeliveries(ctx)
+	if err != nil {
+		slog.Error(ctx, "Error getting deliveries: %s", err)
+		return err
+	}
+	now := time.Now()
+	for _, d := range deliveries {
+		targets := d.DueTargets(now)
+		if len(targets) == 0 {
+			continue
+		}
+		// each target is recorded as it's sent, so a failing kindle
+		// address doesn't mean the email is sent again on the retry
+		err := deliverEdition(ctx, cfg, &d, targets, func(target string) error {
+			return dao.SetDeliveryTargetSent(ctx, d.OwnerID, target, now)
+		})
+		if err != nil {
+			d.Failed(now, err)
+			if d.Enabled {
+				slog.Warn(ctx, "Error delivering edition to %s, retrying at %s: %s", d.OwnerID, d.RetryAt.Format(time.RFC3339), err)
+			} else {
+				slog.Error(ctx, "Error delivering edition to %s, turning delivery off after %d failures: %s", d.OwnerID, d.Failures, err)
+			}
+			if err := dao.SetDeliveryFailed(ctx, &d); err != nil {
+				slog.Error(ctx, "Error recording failed delivery to %s: %s", d.OwnerID, err)
+			}
+			continue
+		}
+		err = dao.SetDeliverySent(ctx, d.OwnerID, now)
+		if err != nil {
+			slog.Error(ctx, "Error recording delivery to %s: %s", d.OwnerID, err)
+		}
+	}
+	return nil
+}
+
+// deliverEdition sends the current edition, with the reader's rules
+// applied, to the given targets. sent, if it's set, is called after each
+// target is sent to.
+func deliverEdition(ctx context.Context, cfg mailer.Config, d *domain.Delivery, targets []string, sent func(target string) error) error {
+	u, err := dao.GetUser(ctx, d.OwnerID)
+	if err != nil {
+		return err
+	}
+	if u == nil {
+		return fmt.Errorf("no user %s", d.OwnerID)
+	}
+	current, err := dao.GetEditionForTime(ctx, time.Now(), true)
+	if err != nil {
+		return err
+	}
+	if current == nil {
+		return fmt.Errorf("no edition to send")
+	}
+	e, _, err := editionForUser(ctx, u, current.ID)
+	if err != nil {
+		return err
+	}
+
+	for _, target := range targets {
+		err := sendTarget(ctx, cfg, e, d, target)
+		if err != nil {
+			return fmt.Errorf("error sending to %s: %w", target, err)
+		}
+		if sent == nil {
+			continue
+		}
+		if err := sent(target); err != nil {
+			return err
+		}
+	}
+	slog.Info(ctx, "Delivered edition %s to %s", e.ID, d.OwnerID)
+	return nil
+}
+
+// sendTarget sends an edition to one of the reader's targets
+func sendTarget(ctx context.Context, cfg mailer.Config, e *domain.Edition, d *domain.Delivery, target string) error {
+	switch target {
+	case domain.DeliveryTargetEmail:
+		msg, err := editionEmail(ctx, e, d)
+		if err != nil {
+			return err
+		}
+		msg.To = []string{d.Email}
+		return cfg.Send(msg)
+	case domain.DeliveryTargetKindle:
+		// send to kindle converts the attachment and ignores the body
+		attachment, err := editionAttachment(ctx, e, domain.DeliveryEPUB)
+		if err != nil {
+			return err
+		}
+		return cfg.Send(&mailer.Message{
+			To:          []string{d.KindleEmail},
+			Subject:     e.Name + " - " + e.Date,
+			Text:        e.Name + " - " + e.Date,
+			Attachments: []mailer.Attachment{*attachment},
+		})
+	}
+	return fmt.Errorf("unknown delivery target: %s", target)
+}
+
+type emailConfirm struct {
+	Site       string
+	Address    string
+	Kindle     bool
+	ConfirmURL string
+}
+
+// sendConfirmation emails a link to confirm target's address. Kindles only
+// show attachments, so those get it as a text document too.
+func sendConfirmation(ctx context.Context, cfg mailer.Config, d *domain.Delivery, target string) error {
+	base := domain.PublicURL()
+	token, err := d.ConfirmToken(target, time.Now())
+	if err != nil {
+		return err
+	}
+	data := emailConfirm{
+		Site:       base,
+		Address:    d.Address(target),
+		Kindle:     target == domain.DeliveryTargetKindle,
+		ConfirmURL: fmt.Sprintf("%s/settings/delivery/confirm?token=%s", base, url.QueryEscape(token)),
+	}
+	var text bytes.Buffer
+	tt, err := templates.get("email-confirm.txt")
+	if err != nil {
+		return err
+	}
+	err = tt.Execute(&text, data)
+	if err != nil {
+		return err
+	}
+	msg := &mailer.Message{
+		To:      []string{data.Address},
+		Subject: "Confirm your address for The Webpage",
+		Text:    text.String(),
+	}
+	if data.Kindle {
+		msg.Attachments = []mailer.Attachment{{
+			Filename:    "confirm-delivery.txt",
+			ContentType: "text/plain; charset=utf-8",
+			Data:        text.Bytes(),
+		}}
+	}
+	if err := cfg.Send(msg); err != nil {
+		return err
+	}
+	slog.Info(ctx, "Sent delivery confirmation for %s to %s", d.OwnerID, target)
+	return nil
+}
+
+type emailEdition struct {
+	Edition  *domain.Edition
+	Sections []emailSection
+	// Attached is the format of the attached edition, when the email is
+	// only a table of contents
+	Attached       string
+	WebURL         string
+	UnsubscribeURL string
+}
+
+type emailSection struct {
+	Name     string
+	Articles []emailArticle
+}
+
+type emailArticle struct {
+	Title   string
+	URL     string
+	Byline  string
+	Summary string
+}
+
+// editionEmail builds the email for a reader's chosen format, always with a
+// plain text body for clients that don't show HTML
+func editionEmail(ctx context.Context, e *domain.Edition, d *domain.Delivery) (*mailer.Message, error) {
+	base := domain.PublicURL()
+	token, err := d.UnsubscribeToken(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	data := emailEdition{
+		Edition:        e,
+		WebURL:         fmt.Sprintf("%s/edition/%s", base, e.ID),
+		UnsubscribeURL: fmt.Sprintf("%s/settings/delivery/unsubscribe?token=%s", base, url.QueryEscape(token)),
+	}
+	if d.Format != domain.DeliveryHTML {
+		data.Attached = d.Format
+	}
+	for _, s := range e.Sections() {
+		es := emailSection{Name: s.Name}
+		for _, a := range s.Articles {
+			byline := a.Source.Name
+			if !a.Timestamp.IsZero() {
+				byline += " - " + a.Timestamp.Format("Mon Jan 2 15:04")
+			}
+			es.Articles = append(es.Articles, emailArticle{
+				Title:   a.Title,
+				URL:     fmt.Sprintf("%s/article?id=%s", base, url.QueryEscape(a.ID)),
+				Byline:  byline,
+				Summary: a.Summary(emailSummaryLength),
+			})
+		}
+		data.Sections = append(data.Sections, es)
+	}
+
+	msg := &mailer.Message{
+		Subject: e.Name + " - " + e.Date,
+		Headers: map[string]string{
+			"List-Unsubscribe":      "<" + data.UnsubscribeURL + ">",
+			"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+		},
+	}
+
+	var text bytes.Buffer
+	tt, err := templates.get("email-edition.txt")
+	if err != nil {
+		return nil, err
+	}
+	err = tt.Execute(&text, data)
+	if err != nil {
+		return nil, err
+	}
+	msg.Text = text.String()
+
+	var html bytes.Buffer
+	ht, err := templates.get("email-edition.html")
+	if err != nil {
+		return nil, err
+	}
+	err = ht.Execute(&html, data)
+	if err != nil {
+		return nil, err
+	}
+	msg.HTML = html.String()
+
+	if data.Attached != "" {
+		attachment, err := editionAttachment(ctx, e, data.Attached)
+		if err != nil {
+			return nil, err
+		}
+		msg.Attachments = []mailer.Attachment{*attachment}
+	}
+	return msg, nil
+}
+
+// editionAttachment renders an edition as a PDF or EPUB file
+func editionAttachment(ctx context.Context, e *domain.Edition, format string) (*mailer.Attachment, error) {
+	switch format {
+	case domain.DeliveryPDF:
+		fetchCtx, cancel := context.WithTimeout(ctx, 20*time.Second)
+		images := fetchEditionImages(fetchCtx, e.Articles)
+		cancel()
+		var buf bytes.Buffer
+		err := newEditionPDF(e, domain.PublicURL(), images).Render(&buf)
+		if err != nil {
+			return nil, err
+		}
+		return &mailer.Attachment{
+			Filename:    fmt.Sprintf("edition-%s.pdf", e.ID),
+			ContentType: "application/pdf",
+			Data:        buf.Bytes(),
+		}, nil
+	case domain.DeliveryEPUB:
+		buf, err := editionEPUB(ctx, e, epubOptions{GroupBy: "section"})
+		if err != nil {
+			return nil, err
+		}
+		return &mailer.Attachment{
+			Filename:    fmt.Sprintf("edition-%s.epub", e.ID),
+			ContentType: "application/epub+zip",
+			Data:        buf.Bytes(),
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown attachment format: %s", format)
+}
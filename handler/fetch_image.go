@@ -0,0 +1,156 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/RusticPotatoes/news/domain"
+	"github.com/RusticPotatoes/news/pkg/safehttp"
+)
+
+// Limits on remote images, which are fetched on behalf of readers so have
+// to be treated as hostile
+const (
+	maxImageBytes  = 10 << 20
+	maxImagePixels = 40000000
+	maxImageWidth  = 2000
+	imageTimeout   = 15 * time.Second
+)
+
+var (
+	errImageForbidden = errors.New("image url not allowed")
+	errImageTooLarge  = errors.New("image too large")
+	errNotImage       = errors.New("not an image")
+)
+
+// imageClient refuses to connect to addresses that aren't public
+var imageClient = safehttp.NewClient(imageTimeout)
+
+// checkImageURL only allows public http and https URLs
+func checkImageURL(u *url.URL) error {
+	if err := safehttp.CheckURL(u); err != nil {
+		return fmt.Errorf("%w: %s", errImageForbidden, err)
+	}
+	return nil
+}
+
+// fetchImage downloads and decodes an image, refusing anything that isn't
+// an image, is over the byte or pixel limits, or is on a private network
+func fetchImage(ctx context.Context, rawURL string) (image.Image, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errImageForbidden, err)
+	}
+	if err := checkImageURL(u); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "image/*")
+	res, err := imageClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", res.Status)
+	}
+	if res.ContentLength > maxImageBytes {
+		return nil, fmt.Errorf("%w: %d bytes", errImageTooLarge, res.ContentLength)
+	}
+	buf, err := io.ReadAll(io.LimitReader(res.Body, maxImageBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) > maxImageBytes {
+		return nil, fmt.Errorf("%w: over %d bytes", errImageTooLarge, maxImageBytes)
+	}
+
+	// some servers don't bother with a content type, so sniff those
+	contentType := res.Header.Get("Content-Type")
+	if contentType == "" || strings.HasPrefix(contentType, "application/octet-stream") {
+		contentType = http.DetectContentType(buf)
+	}
+	if !strings.HasPrefix(contentType, "image/") {
+		return nil, fmt.Errorf("%w: %s", errNotImage, contentType)
+	}
+
+	// check the dimensions before decoding, a small file can still
+	// decompress to an enormous image
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(buf))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errNotImage, err)
+	}
+	if cfg.Width*cfg.Height > maxImagePixels {
+		return nil, fmt.Errorf("%w: %dx%d", errImageTooLarge, cfg.Width, cfg.Height)
+	}
+	img, _, err := image.Decode(bytes.NewReader(buf))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errNotImage, err)
+	}
+	return img, nil
+}
+
+// imageErrorStatus picks the status code to send for a failed fetch
+func imageErrorStatus(err error) int {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, errImageForbidden), errors.Is(err, safehttp.ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, errImageTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, errNotImage):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusBadGateway
+}
+
+// tileImageURL is where pages load an article's image from, dithered with
+// the defaults for its tile. It's signed so /image serves it without
+// looking the image up, and it's what WarmImageCache warms.
+func tileImageURL(imageURL string, l domain.Layout) string {
+	if imageURL == "" {
+		return ""
+	}
+	q := url.Values{}
+	q.Set("url", imageURL)
+	q.Set("sig", signImageURL(imageURL))
+	if l.Size > 0 {
+		q.Set("tile", strconv.Itoa(l.Size))
+	}
+	return "/image?" + q.Encode()
+}
+
+// signImageURL signs an image URL so the dither endpoint will fetch it even
+// if it isn't an article's image
+func signImageURL(u string) string {
+	mac := hmac.New(sha256.New, domain.TokenSecret())
+	mac.Write([]byte("image:" + u))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func validImageSignature(u, sig string) bool {
+	if sig == "" {
+		return false
+	}
+	return hmac.Equal([]byte(signImageURL(u)), []byte(sig))
+}
@@ -11,6 +11,10 @@ import (
 
 func handleAddSource(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
 	r.ParseForm()
 
 	u := domain.UserFromContext(ctx)
@@ -25,7 +29,7 @@ func handleAddSource(w http.ResponseWriter, r *http.Request) {
 
 	src := domain.Source{
 		// ID:         idgen.New("src"),
-		OwnerID:    u.ID,
+		OwnerID:    u.Name,
 		Name:       name,
 		URL:        homepage,
 		FeedURL:    feedURL,
@@ -37,5 +41,5 @@ func handleAddSource(w http.ResponseWriter, r *http.Request) {
 		slog.Error(ctx, "Error storing source: %s", err)
 		http.Error(w, "error storing source", 500)
 	}
-	http.Redirect(w, r, "/settings", 307)
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
 }
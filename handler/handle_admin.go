@@ -0,0 +1,361 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/monzo/slog"
+
+	"github.com/RusticPotatoes/news/dao"
+	"github.com/RusticPotatoes/news/domain"
+	"github.com/RusticPotatoes/news/pkg/articles"
+	"github.com/RusticPotatoes/news/pkg/jobs"
+	"github.com/RusticPotatoes/news/pkg/lifecycle"
+)
+
+const (
+	// adminHealthWindow is how far back source health is counted
+	adminHealthWindow = 24 * time.Hour
+	// adminErrorLogLength is how many fetch errors, and failed logins, the
+	// console shows
+	adminErrorLogLength = 50
+	// maxInviteUses stops an invite being made that's as good as open
+	// signup by mistake, invites with no limit are still allowed
+	maxInviteUses = 1000
+)
+
+type adminPage struct {
+	Users   []domain.User
+	Sources []domain.SourceHealth
+	Errors  []domain.FetchError
+	Jobs    []adminJob
+	Invites []domain.Invite
+	Signup  domain.SignupMode
+	// FailedLogins are the most recent failed logins, newest first
+	FailedLogins  []domain.LoginAttempt
+	AccountPolicy domain.LoginPolicy
+	IPPolicy      domain.LoginPolicy
+	LoginWindow   time.Duration
+	Now           time.Time
+	base
+}
+
+// adminOnly hides a handler from everyone but admins
+func adminOnly(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u := domain.UserFromContext(r.Context())
+		if u == nil {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+		if !u.IsAdmin {
+			http.NotFound(w, r)
+			return
+		}
+		next(w, r)
+	})
+}
+
+func handleAdmin(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	t, err := templates.get("admin.html")
+	if err != nil {
+		slog.Error(ctx, "Error parsing template: %s", err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	now := time.Now()
+	users, err := dao.GetUsers(ctx)
+	if err != nil {
+		httpError(ctx, w, "Couldn't get users", err)
+		return
+	}
+	sources, err := dao.GetSourceHealth(ctx, now.Add(-adminHealthWindow))
+	if err != nil {
+		httpError(ctx, w, "Couldn't get sources", err)
+		return
+	}
+	fetchErrors, err := dao.GetFetchErrors(ctx, adminErrorLogLength)
+	if err != nil {
+		httpError(ctx, w, "Couldn't get fetch errors", err)
+		return
+	}
+	invites, err := dao.GetInvites(ctx)
+	if err != nil {
+		httpError(ctx, w, "Couldn't get invites", err)
+		return
+	}
+	failedLogins, err := dao.GetFailedLogins(ctx, adminErrorLogLength)
+	if err != nil {
+		httpError(ctx, w, "Couldn't get failed logins", err)
+		return
+	}
+
+	p := adminPage{
+		Users:         users,
+		Sources:       sources,
+		Errors:        fetchErrors,
+		Jobs:          adminJobs.list(),
+		Invites:       invites,
+		Signup:        domain.Signup(),
+		FailedLogins:  failedLogins,
+		AccountPolicy: domain.AccountLoginPolicy,
+		IPPolicy:      domain.IPLoginPolicy,
+		LoginWindow:   domain.LoginWindow,
+		Now:           now,
+		base: base{
+			ID:        "Admin",
+			User:      domain.UserFromContext(ctx),
+			CSRFToken: csrfToken(ctx),
+			Error:     r.URL.Query().Get("error"),
+		},
+	}
+	err = t.Execute(w, &p)
+	if err != nil {
+		slog.Error(ctx, "Error executing template: %s", err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+}
+
+// handleAdminUser resets a user's password, approves them, lifts a login
+// lockout, changes whether they're an admin, or deletes them
+func handleAdminUser(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	r.ParseForm()
+
+	admin := domain.UserFromContext(ctx)
+	u, err := dao.GetUser(ctx, r.Form.Get("id"))
+	if err != nil {
+		httpError(ctx, w, "error getting user", err)
+		return
+	}
+	if u == nil {
+		http.Error(w, "no such user", http.StatusNotFound)
+		return
+	}
+
+	action := r.Form.Get("action")
+	// admins can't lock themselves out
+	if u.ID == admin.ID && (action == "demote" || action == "delete") {
+		adminRedirect(w, r, "you can't demote or delete yourself")
+		return
+	}
+
+	switch action {
+	case "password":
+		password := r.Form.Get("password")
+		if len(password) < domain.MinPasswordLength {
+			adminRedirect(w, r, fmt.Sprintf("passwords must be at least %d characters", domain.MinPasswordLength))
+			return
+		}
+		err = u.SetPassword(password)
+		if err == nil {
+			err = dao.SetUser(ctx, u)
+		}
+		if err == nil {
+			// anyone using the old password is logged out
+			err = dao.RevokeSessions(ctx, u.ID)
+		}
+	case "unlock":
+		err = dao.ClearFailedLogins(ctx, u.Name)
+	case "approve":
+		u.Pending = false
+		err = dao.SetUser(ctx, u)
+	case "promote", "demote":
+		u.IsAdmin = action == "promote"
+		err = dao.SetUser(ctx, u)
+	case "delete":
+		err = dao.DeleteUser(ctx, u)
+	default:
+		http.Error(w, "unknown action", 400)
+		return
+	}
+	if err != nil {
+		httpError(ctx, w, "error updating user", err)
+		return
+	}
+	slog.Info(ctx, "Admin %s did %s to user %s", admin.Name, action, u.Name)
+	adminRedirect(w, r, "")
+}
+
+// handleAdminJob starts a fetch or edition in the background
+func handleAdminJob(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	r.ParseForm()
+
+	var (
+		name string
+		run  func(ctx context.Context) (string, error)
+	)
+	switch r.Form.Get("job") {
+	case "fetch":
+		name, run = "fetch all sources", jobs.FetchAllSources
+	case "fetch_source":
+		source, err := dao.GetSource(ctx, r.Form.Get("id"))
+		if err != nil {
+			httpError(ctx, w, "error getting source", err)
+			return
+		}
+		if source == nil {
+			http.Error(w, "no such source", http.StatusNotFound)
+			return
+		}
+		name = fmt.Sprintf("fetch %s (%s)", source.Name, source.OwnerID)
+		run = func(ctx context.Context) (string, error) {
+			return "", articles.FetchSource(ctx, *source)
+		}
+	case "edition":
+		name = "generate edition"
+		run = func(ctx context.Context) (string, error) {
+			e, err := jobs.GenerateEdition(ctx, true)
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("edition %s with %d articles", e.ID, len(e.Articles)), nil
+		}
+	default:
+		http.Error(w, "unknown job", 400)
+		return
+	}
+
+	if !adminJobs.start(name, run) {
+		adminRedirect(w, r, name+" is already running")
+		return
+	}
+	adminRedirect(w, r, "")
+}
+
+// handleAdminInvite makes and deletes signup invites
+func handleAdminInvite(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	r.ParseForm()
+
+	switch r.Form.Get("action") {
+	case "create":
+		days, err := strconv.Atoi(r.Form.Get("days"))
+		if err != nil || days < 0 {
+			adminRedirect(w, r, "invalid expiry")
+			return
+		}
+		uses, err := strconv.Atoi(r.Form.Get("uses"))
+		if err != nil || uses < 0 || uses > maxInviteUses {
+			adminRedirect(w, r, fmt.Sprintf("invites can be used between 1 and %d times, or 0 for no limit", maxInviteUses))
+			return
+		}
+		invite, err := domain.NewInvite(domain.UserFromContext(ctx), time.Duration(days)*24*time.Hour, uses, time.Now())
+		if err == nil {
+			err = dao.SetInvite(ctx, invite)
+		}
+		if err != nil {
+			httpError(ctx, w, "error creating invite", err)
+			return
+		}
+	case "delete":
+		err := dao.DeleteInvite(ctx, r.Form.Get("code"))
+		if err != nil {
+			httpError(ctx, w, "error deleting invite", err)
+			return
+		}
+	default:
+		http.Error(w, "unknown action", 400)
+		return
+	}
+	adminRedirect(w, r, "")
+}
+
+func adminRedirect(w http.ResponseWriter, r *http.Request, msg string) {
+	to := "/admin"
+	if msg != "" {
+		to += "?error=" + url.QueryEscape(msg)
+	}
+	http.Redirect(w, r, to, http.StatusSeeOther)
+}
+
+// adminJob is a job started from the console
+type adminJob struct {
+	Name     string
+	Running  bool
+	Started  time.Time
+	Finished time.Time
+	Result   string
+	Error    string
+}
+
+type jobTracker struct {
+	mu   sync.Mutex
+	jobs map[string]*adminJob
+	// workers outlive the request that started them, shutting down waits
+	// for them
+	workers *lifecycle.Group
+}
+
+var adminJobs = &jobTracker{
+	jobs:    make(map[string]*adminJob),
+	workers: lifecycle.NewGroup(context.Background()),
+}
+
+// StopJobs cancels jobs started from the admin console and waits for them
+// to finish
+func StopJobs(ctx context.Context) error {
+	return adminJobs.workers.Stop(ctx)
+}
+
+// start runs fn in the background, unless a job with the same name is
+// already running
+func (t *jobTracker) start(name string, fn func(ctx context.Context) (string, error)) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if j, ok := t.jobs[name]; ok && j.Running {
+		return false
+	}
+	j := &adminJob{Name: name, Running: true, Started: time.Now()}
+	t.jobs[name] = j
+
+	// the request that started the job will be long gone
+	t.workers.Go(func(ctx context.Context) {
+		result, err := fn(ctx)
+		if err != nil {
+			slog.Error(ctx, "Admin job %s failed: %s", name, err)
+		}
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		j.Running, j.Finished, j.Result = false, time.Now(), result
+		if err != nil {
+			j.Error = err.Error()
+		}
+	})
+	return true
+}
+
+// list returns the jobs, most recently started first
+func (t *jobTracker) list() []adminJob {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	jobs := make([]adminJob, 0, len(t.jobs))
+	for _, j := range t.jobs {
+		jobs = append(jobs, *j)
+	}
+	sort.Slice(jobs, func(i, k int) bool { return jobs[i].Started.After(jobs[k].Started) })
+	return jobs
+}
@@ -0,0 +1,260 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/monzo/slog"
+
+	"github.com/RusticPotatoes/news/dao"
+	"github.com/RusticPotatoes/news/domain"
+)
+
+const (
+	// apiArticlesWindow is how far back /api/articles looks by default
+	apiArticlesWindow = 24 * time.Hour
+	// apiArticlesMaxWindow is the most /api/articles returns in one request
+	apiArticlesMaxWindow = 7 * 24 * time.Hour
+)
+
+type apiArticle struct {
+	ID          string    `json:"id"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	Link        string    `json:"link"`
+	ImageURL    string    `json:"image_url,omitempty"`
+	Author      string    `json:"author,omitempty"`
+	Source      string    `json:"source"`
+	Published   time.Time `json:"published"`
+	Tags        []string  `json:"tags,omitempty"`
+	Highlight   bool      `json:"highlight,omitempty"`
+	Demoted     bool      `json:"demoted,omitempty"`
+}
+
+type apiSource struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	URL        string   `json:"url"`
+	FeedURL    string   `json:"feed_url"`
+	Categories []string `json:"categories"`
+}
+
+// handleAPIArticles lists the reader's articles, with their rules applied,
+// published between since and until, which default to the last day
+func handleAPIArticles(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	u := domain.UserFromContext(ctx)
+	if u == nil {
+		apiError(w, http.StatusUnauthorized, "not logged in")
+		return
+	}
+
+	until := time.Now()
+	if v := r.URL.Query().Get("until"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			apiError(w, http.StatusBadRequest, "until must be an RFC 3339 time")
+			return
+		}
+		until = t
+	}
+	since := until.Add(-apiArticlesWindow)
+	if v := r.URL.Query().Get("since"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			apiError(w, http.StatusBadRequest, "since must be an RFC 3339 time")
+			return
+		}
+		since = t
+	}
+	if !since.Before(until) {
+		apiError(w, http.StatusBadRequest, "since must be before until")
+		return
+	}
+	if until.Sub(since) > apiArticlesMaxWindow {
+		apiError(w, http.StatusBadRequest, fmt.Sprintf("can't list more than %s of articles at once", apiArticlesMaxWindow))
+		return
+	}
+
+	articles, _, err := dao.GetArticlesForOwner(ctx, u.Name, since, until)
+	if err != nil {
+		slog.Error(ctx, "Error getting articles: %s", err)
+		apiError(w, http.StatusInternalServerError, "error getting articles")
+		return
+	}
+	rules, err := dao.GetRules(ctx, u.ID)
+	if err != nil {
+		slog.Error(ctx, "Error getting rules: %s", err)
+		apiError(w, http.StatusInternalServerError, "error getting rules")
+		return
+	}
+	articles, _ = domain.ApplyRules(rules, articles)
+
+	out := make([]apiArticle, 0, len(articles))
+	for _, a := range articles {
+		out = append(out, apiArticle{
+			ID:          a.ID,
+			Title:       a.Title,
+			Description: a.Description,
+			Link:        a.Link,
+			ImageURL:    a.ImageURL,
+			Author:      a.Author,
+			Source:      a.Source.Name,
+			Published:   a.Timestamp,
+			Tags:        a.Tags,
+			Highlight:   a.Highlight,
+			Demoted:     a.Demoted,
+		})
+	}
+	writeJSON(ctx, w, http.StatusOK, out)
+}
+
+// handleAPISources lists the reader's sources on a get, and adds one on a
+// post
+func handleAPISources(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	u := domain.UserFromContext(ctx)
+	if u == nil {
+		apiError(w, http.StatusUnauthorized, "not logged in")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		sources, err := dao.GetSources(ctx, u.Name)
+		if err != nil {
+			slog.Error(ctx, "Error getting sources: %s", err)
+			apiError(w, http.StatusInternalServerError, "error getting sources")
+			return
+		}
+		out := make([]apiSource, 0, len(sources))
+		for _, s := range sources {
+			out = append(out, toAPISource(s))
+		}
+		writeJSON(ctx, w, http.StatusOK, out)
+	case http.MethodPost:
+		var in apiSource
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			apiError(w, http.StatusBadRequest, "body must be a JSON source")
+			return
+		}
+		in.Name = strings.TrimSpace(in.Name)
+		if in.Name == "" {
+			apiError(w, http.StatusBadRequest, "name is required")
+			return
+		}
+		for _, v := range []string{in.URL, in.FeedURL} {
+			if pu, err := url.Parse(v); err != nil || (pu.Scheme != "http" && pu.Scheme != "https") || pu.Host == "" {
+				apiError(w, http.StatusBadRequest, "url and feed_url must be http or https URLs")
+				return
+			}
+		}
+		var categories []string
+		for _, c := range in.Categories {
+			if c = strings.TrimSpace(c); c != "" {
+				categories = append(categories, c)
+			}
+		}
+
+		src := domain.Source{
+			OwnerID:    u.Name,
+			Name:       in.Name,
+			URL:        in.URL,
+			FeedURL:    in.FeedURL,
+			Categories: categories,
+		}
+		if err := dao.SetSource(ctx, &src); err != nil {
+			slog.Error(ctx, "Error storing source: %s", err)
+			apiError(w, http.StatusInternalServerError, "error storing source")
+			return
+		}
+		// sources are keyed by owner and homepage, look it up again to
+		// get its id
+		sources, err := dao.GetSources(ctx, u.Name)
+		if err != nil {
+			slog.Error(ctx, "Error getting sources: %s", err)
+			apiError(w, http.StatusInternalServerError, "error getting sources")
+			return
+		}
+		for _, s := range sources {
+			if s.URL == src.URL {
+				src = s
+				break
+			}
+		}
+		writeJSON(ctx, w, http.StatusCreated, toAPISource(src))
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		apiError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// handleAPISource removes one of the reader's sources
+func handleAPISource(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	u := domain.UserFromContext(ctx)
+	if u == nil {
+		apiError(w, http.StatusUnauthorized, "not logged in")
+		return
+	}
+	if r.Method != http.MethodDelete {
+		w.Header().Set("Allow", "DELETE")
+		apiError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	src, err := dao.GetSource(ctx, mux.Vars(r)["id"])
+	if err != nil {
+		slog.Error(ctx, "Error getting source: %s", err)
+		apiError(w, http.StatusInternalServerError, "error getting source")
+		return
+	}
+	if src == nil || src.OwnerID != u.Name {
+		apiError(w, http.StatusNotFound, "no such source")
+		return
+	}
+	if err := dao.DeleteSource(ctx, src.ID); err != nil {
+		slog.Error(ctx, "Error deleting source: %s", err)
+		apiError(w, http.StatusInternalServerError, "error deleting source")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func toAPISource(s domain.Source) apiSource {
+	categories := []string{}
+	for _, c := range s.Categories {
+		if c != "" {
+			categories = append(categories, c)
+		}
+	}
+	return apiSource{
+		ID:         s.ID,
+		Name:       s.Name,
+		URL:        s.URL,
+		FeedURL:    s.FeedURL,
+		Categories: categories,
+	}
+}
+
+func writeJSON(ctx context.Context, w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error(ctx, "Error writing response: %s", err)
+	}
+}
+
+func apiError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+	}{msg})
+}
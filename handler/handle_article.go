@@ -14,10 +14,7 @@ import (
 func handleArticle(w http.ResponseWriter, r *http.Request) {
     ctx := r.Context()
 
-    t := template.New("frame.html").Funcs(template.FuncMap{
-        "safeHTML": safeHTML,
-    })
-    t, err := t.ParseFiles("tmpl/frame.html", "tmpl/meta.html", "tmpl/article.html")
+    t, err := templates.get("article.html")
     if err != nil {
         slog.Error(ctx, "Error parsing template: %s", err)
         http.Error(w, err.Error(), 500)
@@ -61,7 +58,8 @@ func handleArticle(w http.ResponseWriter, r *http.Request) {
 	a := articlePage{
 		Article: article,
 		base: base{
-			User: domain.UserFromContext(ctx),
+			User:      domain.UserFromContext(ctx),
+			CSRFToken: csrfToken(ctx),
 			Meta: Meta{
 				Title:       article.Title + " - " + article.Source.Name,
 				Description: preview([]domain.Element{{Type: "text", Value: article.Content.TextContent}}),
@@ -1,19 +1,21 @@
 package handler
 
 import (
+	"fmt"
 	"image"
 	_ "image/gif"
-	"image/jpeg"
 	_ "image/jpeg"
 	_ "image/png"
 	"net/http"
 	"strconv"
+	"strings"
 
-	dither "github.com/esimov/dithergo"
 	"github.com/monzo/slog"
 	"github.com/nfnt/resize"
 
+	"github.com/RusticPotatoes/news/dao"
 	"github.com/RusticPotatoes/news/domain"
+	"github.com/RusticPotatoes/news/pkg/dither"
 	"github.com/RusticPotatoes/news/pkg/util"
 )
 
@@ -23,56 +25,163 @@ func handleDitherImage(w http.ResponseWriter, r *http.Request) {
 	q := r.URL.Query()
 	url := q.Get("url")
 	ctx = util.SetParam(ctx, "url", url)
-
-	cachedImage := domain.C.GetImage(url)
-	if cachedImage != nil {
-		jpeg.Encode(w, *cachedImage, nil)
+	if url == "" {
+		http.Error(w, "missing url", http.StatusBadRequest)
 		return
 	}
 
-	res, err := http.Get(url)
+	opts, err := parseDitherOptions(q)
 	if err != nil {
-		slog.Error(ctx, "Error getting image: %s", err)
-		http.Error(w, err.Error(), 500)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	width, err := strconv.ParseInt(q.Get("w"), 10, 64)
-	if err != nil {
-		slog.Error(ctx, "Error parsing width: %s", err)
-		http.Error(w, err.Error(), 500)
+	// only proxy images we've stored with an article, or that we've
+	// signed, otherwise this is an open proxy
+	if !validImageSignature(url, q.Get("sig")) {
+		ok, err := dao.HasArticleImage(ctx, url)
+		if err != nil {
+			slog.Error(ctx, "Error checking image: %s", err)
+			http.Error(w, "error checking image", 500)
+			return
+		}
+		if !ok {
+			http.Error(w, errImageForbidden.Error(), http.StatusForbidden)
+			return
+		}
+	}
+
+	etag := ditherETag(url, opts)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(imageMaxAge.Seconds())))
+	if match := r.Header.Get("If-None-Match"); match != "" && strings.Contains(match, etag) {
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
 
-	img, _, err := image.Decode(res.Body)
+	data, err := ditheredImage(ctx, url, opts)
 	if err != nil {
-		slog.Error(ctx, "Error decoding image: %s", err)
-		http.Error(w, err.Error(), 500)
+		status := imageErrorStatus(err)
+		slog.Warn(ctx, "Error getting image: %s", err)
+		// errors shouldn't be cached like the image would be
+		w.Header().Del("ETag")
+		w.Header().Set("Cache-Control", "no-store")
+		http.Error(w, http.StatusText(status), status)
 		return
 	}
 
-	newImage := resize.Resize(uint(width), 0, img, resize.Lanczos3)
+	w.Header().Set("Content-Type", imageFormats[opts.Format])
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Write(data)
+}
+
+// imageFormats are the formats dithered images can be served as, with
+// their content types
+var imageFormats = map[string]string{
+	"png":  "image/png",
+	"gif":  "image/gif",
+	"jpeg": "image/jpeg",
+}
+
+// ditherOptions is how /image serves an image
+type ditherOptions struct {
+	Width  int
+	Format string
+	dither.Options
+}
 
-	d := dither.Dither{
-		Type: "FloydSteinberg",
-		Settings: dither.Settings{
-			Filter: [][]float32{
-				{0.0, 0.0, 0.0, 7.0 / 48.0, 5.0 / 48.0},
-				{3.0 / 48.0, 5.0 / 48.0, 7.0 / 48.0, 5.0 / 48.0, 3.0 / 48.0},
-				{1.0 / 48.0, 3.0 / 48.0, 5.0 / 48.0, 3.0 / 48.0, 1.0 / 48.0},
-			},
-		},
+// ditherDefaults picks the options for an image in a tile of layout l.
+// Small tiles use ordered dithering in gray, which stays legible when
+// scaled down, larger ones get error diffusion in colour.
+func ditherDefaults(l domain.Layout) ditherOptions {
+	o := ditherOptions{Width: warmImageWidth, Format: "png"}
+	switch {
+	case l.Width == 0:
+		o.Options = dither.Options{Algorithm: dither.FloydSteinberg, Mode: dither.Color}
+	case l.Width <= 2:
+		o.Options = dither.Options{Algorithm: dither.Bayer, Mode: dither.Gray}
+	case l.Width <= 4:
+		o.Options = dither.Options{Algorithm: dither.Atkinson, Mode: dither.Gray}
+	default:
+		o.Options = dither.Options{Algorithm: dither.FloydSteinberg, Mode: dither.Color}
 	}
+	// tiles are laid out on a twelve column grid, about 100px a column,
+	// doubled for high density screens
+	if l.Width > 0 {
+		o.Width = l.Width * 200
+	}
+	return o
+}
 
-	dithered := d.Color(newImage, 1)
+// parseDitherOptions reads the options from the query, "tile" is a layout
+// size whose defaults the other parameters override
+func parseDitherOptions(q map[string][]string) (ditherOptions, error) {
+	get := func(k string) string {
+		if v := q[k]; len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
 
-	w.Header().Set("Content-Type", "image/jpeg")
+	var l domain.Layout
+	if tile := get("tile"); tile != "" {
+		size, err := strconv.Atoi(tile)
+		if err != nil {
+			return ditherOptions{}, fmt.Errorf("unknown tile size: %s", tile)
+		}
+		var ok bool
+		l, ok = domain.LayoutForSize(size)
+		if !ok {
+			return ditherOptions{}, fmt.Errorf("unknown tile size: %s", tile)
+		}
+	}
+	o := ditherDefaults(l)
 
-	err = jpeg.Encode(w, dithered, nil)
-	if err != nil {
-		slog.Error(ctx, "Error encoding image: %s", err)
-		http.Error(w, err.Error(), 500)
-		return
+	if v := get("w"); v != "" {
+		width, err := strconv.Atoi(v)
+		if err != nil || width < 1 || width > maxImageWidth {
+			return ditherOptions{}, fmt.Errorf("w must be between 1 and %d", maxImageWidth)
+		}
+		o.Width = width
+	}
+	if v := get("algo"); v != "" {
+		o.Algorithm = dither.Algorithm(v)
+	}
+	if v := get("mode"); v != "" {
+		o.Mode = dither.Mode(v)
+	}
+	if err := o.Options.Validate(); err != nil {
+		return ditherOptions{}, err
 	}
+	if v := get("format"); v != "" {
+		if v == "jpg" {
+			v = "jpeg"
+		}
+		if _, ok := imageFormats[v]; !ok {
+			return ditherOptions{}, fmt.Errorf("unknown format: %s", v)
+		}
+		o.Format = v
+	}
+	return o, nil
+}
 
+// ditherImage resizes an image down to width, keeping its aspect ratio, and
+// dithers it for the print look. Images are never scaled up, a tall narrow
+// image would grow past the pixel limit.
+func ditherImage(img image.Image, width uint, o dither.Options) (image.Image, error) {
+	b := img.Bounds()
+	if b.Dx() <= 0 || b.Dy() <= 0 {
+		return nil, fmt.Errorf("%w: empty image", errNotImage)
+	}
+	if width > uint(b.Dx()) {
+		width = uint(b.Dx())
+	}
+	height := uint64(b.Dy()) * uint64(width) / uint64(b.Dx())
+	if uint64(width)*height > maxImagePixels {
+		return nil, fmt.Errorf("%w: %dx%d", errImageTooLarge, width, height)
+	}
+	if width != uint(b.Dx()) {
+		img = resize.Resize(width, 0, img, resize.Lanczos3)
+	}
+	return dither.Dither(img, o), nil
 }
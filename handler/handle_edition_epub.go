@@ -0,0 +1,454 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/monzo/slog"
+	"github.com/nfnt/resize"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goitalic"
+	"golang.org/x/image/font/gofont/gosmallcaps"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+
+	"github.com/RusticPotatoes/news/dao"
+	"github.com/RusticPotatoes/news/domain"
+	"github.com/RusticPotatoes/news/pkg/dither"
+	"github.com/RusticPotatoes/news/pkg/epub"
+)
+
+const (
+	epubImageWidth     = 600
+	epubImagesPerStory = 8
+)
+
+// epubOptions are chosen by the reader with query parameters
+type epubOptions struct {
+	// EInk dithers images to grayscale, for e-ink screens
+	EInk bool
+	// GroupBy is "section" or "source", how the table of contents is
+	// grouped
+	GroupBy string
+}
+
+func (o epubOptions) isDefault() bool {
+	return !o.EInk && o.GroupBy == "section"
+}
+
+func handleEditionEPUB(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	u := domain.UserFromContext(ctx)
+	q := r.URL.Query()
+
+	opts := epubOptions{
+		EInk:    q.Get("eink") != "",
+		GroupBy: "section",
+	}
+	if q.Get("toc") == "source" {
+		opts.GroupBy = "source"
+	}
+
+	e, _, err := editionForUser(ctx, u, mux.Vars(r)["id"])
+	if err != nil {
+		httpError(ctx, w, "Error getting edition", err)
+		return
+	}
+	if e == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/epub+zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"edition-%s.epub\"", e.ID))
+
+	// the scheduled export has no reader's rules applied, so it's only
+	// served to logged out readers
+	if u == nil && opts.isDefault() {
+		if f, err := os.Open(editionArtifactPath(e)); err == nil {
+			defer f.Close()
+			http.ServeContent(w, r, "", time.Time{}, f)
+			return
+		}
+	}
+
+	buf, err := editionEPUB(ctx, e, opts)
+	if err != nil {
+		w.Header().Del("Content-Disposition")
+		httpError(ctx, w, "Error building epub", err)
+		return
+	}
+	_, err = buf.WriteTo(w)
+	if err != nil {
+		slog.Error(ctx, "Error writing epub: %s", err)
+	}
+}
+
+// WriteEditionArtifacts exports the current edition as an EPUB, so it can be
+// served without waiting on every image to download. Editions that have
+// already been exported are skipped, and exports of an edition from before
+// it was regenerated are removed.
+func WriteEditionArtifacts(ctx context.Context) error {
+	e, err := dao.GetEditionForTime(ctx, time.Now(), true)
+	if err != nil {
+		slog.Error(ctx, "Error getting edition: %s", err)
+		return err
+	}
+	if e == nil {
+		return nil
+	}
+	path := editionArtifactPath(e)
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+
+	buf, err := editionEPUB(ctx, e, epubOptions{GroupBy: "section"})
+	if err != nil {
+		slog.Error(ctx, "Error building epub for edition %s: %s", e.ID, err)
+		return err
+	}
+	err = os.MkdirAll(config.ArtifactDir(), 0o755)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, buf.Bytes(), 0o644)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp, path)
+	if err != nil {
+		return err
+	}
+	slog.Info(ctx, "Wrote epub for edition %s - %s", e.ID, e.Name)
+
+	stale, err := filepath.Glob(filepath.Join(config.ArtifactDir(), fmt.Sprintf("edition-%s-*.epub", e.ID)))
+	if err != nil {
+		return err
+	}
+	// exports used to be named for the ID alone
+	stale = append(stale, filepath.Join(config.ArtifactDir(), fmt.Sprintf("edition-%s.epub", e.ID)))
+	for _, p := range stale {
+		if p == path {
+			continue
+		}
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			slog.Warn(ctx, "Error removing old epub %s: %s", p, err)
+		}
+	}
+	return nil
+}
+
+// editionArtifactPath is where an edition's export is written. Regenerating
+// an edition keeps its ID, so the file is named for when it was made too.
+func editionArtifactPath(e *domain.Edition) string {
+	return filepath.Join(config.ArtifactDir(), fmt.Sprintf("edition-%s-%d.epub", e.ID, e.Created.Unix()))
+}
+
+// editionEPUB packages an edition's articles as an EPUB, one chapter per
+// article
+func editionEPUB(ctx context.Context, e *domain.Edition, opts epubOptions) (*bytes.Buffer, error) {
+	book := epub.New("urn:the-webpage:edition:"+e.ID, e.Name+" - "+e.Date)
+	book.Author = "The Webpage"
+	if !e.Created.IsZero() {
+		book.Modified = e.Created
+	}
+
+	cover, err := editionCover(e)
+	if err != nil {
+		return nil, err
+	}
+	book.SetCover(cover, "image/png")
+
+	groups := epubGroups(e, opts.GroupBy)
+
+	// resolve and fetch every image up front, so they download in parallel
+	var sources []string
+	for _, g := range groups {
+		for _, a := range g.Articles {
+			sources = append(sources, articleImages(a)...)
+		}
+	}
+	fetchCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	images := fetchEPUBImages(fetchCtx, sources, opts.EInk)
+	cancel()
+
+	embedded := make(map[string]string)
+	embed := func(src string) string {
+		if href, ok := embedded[src]; ok {
+			return href
+		}
+		img, ok := images[src]
+		if !ok {
+			return ""
+		}
+		href := book.AddImage(fmt.Sprintf("image%d%s", len(embedded)+1, img.ext), img.data, img.mediaType)
+		embedded[src] = href
+		return href
+	}
+
+	for _, g := range groups {
+		for _, a := range g.Articles {
+			book.AddChapter(g.Name, a.Title, epubChapter(a, embed))
+		}
+	}
+
+	var buf bytes.Buffer
+	err = book.Write(&buf)
+	if err != nil {
+		return nil, err
+	}
+	return &buf, nil
+}
+
+// epubGroups orders an edition's articles for reading, either by section or
+// by source, sources are listed in the order they first appear
+func epubGroups(e *domain.Edition, groupBy string) []domain.Section {
+	if groupBy != "source" {
+		return e.Sections()
+	}
+	var groups []domain.Section
+	index := make(map[string]int)
+	for _, a := range e.Articles {
+		i, ok := index[a.Source.Name]
+		if !ok {
+			i = len(groups)
+			index[a.Source.Name] = i
+			groups = append(groups, domain.Section{Name: a.Source.Name})
+		}
+		groups[i].Articles = append(groups[i].Articles, a)
+	}
+	return groups
+}
+
+// epubChapter renders an article's readability content as XHTML, with a
+// heading, byline and its lead image
+func epubChapter(a domain.Article, embed func(string) string) string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "<h1>%s</h1>\n", html.EscapeString(a.Title))
+
+	byline := a.Source.Name
+	if a.Author != "" {
+		byline += " - " + a.Author
+	}
+	if !a.Timestamp.IsZero() {
+		byline += " - " + a.Timestamp.Format("Mon Jan 2 15:04")
+	}
+	fmt.Fprintf(&buf, "<p class=\"byline\">%s</p>\n", html.EscapeString(byline))
+
+	content := articleContent(a)
+	inline := make(map[string]bool)
+	for _, src := range epub.ImageSources(content) {
+		inline[resolveURL(a.Link, src)] = true
+	}
+	if a.ImageURL != "" && !inline[a.ImageURL] {
+		if href := embed(a.ImageURL); href != "" {
+			fmt.Fprintf(&buf, "<figure><img src=\"%s\" alt=\"\"/></figure>\n", html.EscapeString(href))
+		}
+	}
+
+	if content == "" {
+		fmt.Fprintf(&buf, "<p>%s</p>\n", html.EscapeString(a.Summary(2000)))
+	} else {
+		buf.WriteString(epub.XHTML(content, func(src string) string {
+			return embed(resolveURL(a.Link, src))
+		}))
+	}
+	if a.Link != "" {
+		fmt.Fprintf(&buf, "\n<p class=\"byline\"><a href=\"%s\">Read the original</a></p>", html.EscapeString(a.Link))
+	}
+	return buf.String()
+}
+
+// articleContent is the readability HTML for an article, decompressing it if
+// it wasn't stored with the edition
+func articleContent(a domain.Article) string {
+	if a.Content.Content != "" || len(a.CompressedContent) == 0 {
+		return a.Content.Content
+	}
+	c, err := domain.DecompressContent(a.CompressedContent)
+	if err != nil {
+		return ""
+	}
+	return c.Content
+}
+
+// articleImages lists the absolute URLs of an article's lead image and the
+// images in its content
+func articleImages(a domain.Article) []string {
+	var out []string
+	if a.ImageURL != "" {
+		out = append(out, a.ImageURL)
+	}
+	for _, src := range epub.ImageSources(articleContent(a)) {
+		if len(out) >= epubImagesPerStory {
+			break
+		}
+		if u := resolveURL(a.Link, src); u != "" {
+			out = append(out, u)
+		}
+	}
+	return out
+}
+
+func resolveURL(base, ref string) string {
+	r, err := url.Parse(ref)
+	if err != nil {
+		return ""
+	}
+	b, err := url.Parse(base)
+	if err != nil {
+		return r.String()
+	}
+	return b.ResolveReference(r).String()
+}
+
+type epubImage struct {
+	data      []byte
+	mediaType string
+	ext       string
+}
+
+// fetchEPUBImages downloads and shrinks images to a size suited to
+// e-readers. For e-ink screens they're converted to dithered grayscale,
+// which is stored as PNG since JPEG smears the dithering.
+func fetchEPUBImages(ctx context.Context, sources []string, eink bool) map[string]epubImage {
+	var (
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		images = make(map[string]epubImage)
+		seen   = make(map[string]bool)
+		sem    = make(chan struct{}, 8)
+	)
+	for _, src := range sources {
+		if seen[src] {
+			continue
+		}
+		seen[src] = true
+		wg.Add(1)
+		go func(src string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			img, err := fetchImage(ctx, src)
+			if err != nil {
+				slog.Warn(ctx, "Error getting image %s: %s", src, err)
+				return
+			}
+			var (
+				buf bytes.Buffer
+				out = epubImage{mediaType: "image/jpeg", ext: ".jpg"}
+			)
+			if eink {
+				var dithered image.Image
+				dithered, err = ditherImage(img, epubImageWidth, dither.Options{Mode: dither.Gray})
+				if err == nil {
+					err = png.Encode(&buf, dithered)
+				}
+				out.mediaType, out.ext = "image/png", ".png"
+			} else {
+				if img.Bounds().Dx() > epubImageWidth {
+					img = resize.Resize(epubImageWidth, 0, img, resize.Lanczos3)
+				}
+				err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 80})
+			}
+			if err != nil {
+				slog.Warn(ctx, "Error encoding image %s: %s", src, err)
+				return
+			}
+			out.data = buf.Bytes()
+			mu.Lock()
+			images[src] = out
+			mu.Unlock()
+		}(src)
+	}
+	wg.Wait()
+	return images
+}
+
+// editionCover draws the masthead as cover art, with the edition's name,
+// date and the sections inside
+func editionCover(e *domain.Edition) ([]byte, error) {
+	const (
+		width  = 600
+		height = 900
+		margin = 40
+	)
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+
+	title, err := coverFace(gobold.TTF, 76)
+	if err != nil {
+		return nil, err
+	}
+	italic, err := coverFace(goitalic.TTF, 26)
+	if err != nil {
+		return nil, err
+	}
+	caps, err := coverFace(gosmallcaps.TTF, 28)
+	if err != nil {
+		return nil, err
+	}
+
+	rule := func(y, thickness int) {
+		draw.Draw(img, image.Rect(margin, y, width-margin, y+thickness), image.Black, image.Point{}, draw.Src)
+	}
+	centre := func(face font.Face, y int, s string) {
+		d := font.Drawer{Dst: img, Src: image.Black, Face: face}
+		w := d.MeasureString(s).Ceil()
+		if w > width-2*margin {
+			// too long to centre, let it run off the right
+			w = width - 2*margin
+		}
+		d.Dot = fixed.P((width-w)/2, y)
+		d.DrawString(s)
+	}
+
+	rule(margin, 6)
+	centre(title, 170, "The Webpage")
+	centre(italic, 215, "The RSS Reader for the 20th Century")
+	rule(240, 2)
+	centre(italic, 285, e.Name)
+	centre(italic, 320, e.Date)
+	rule(345, 2)
+
+	y := 420
+	for _, s := range e.Sections() {
+		if y > height-2*margin {
+			break
+		}
+		centre(caps, y, s.Name)
+		y += 45
+	}
+	rule(height-margin-6, 6)
+
+	var buf bytes.Buffer
+	err = png.Encode(&buf, img)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func coverFace(ttf []byte, size float64) (font.Face, error) {
+	f, err := opentype.Parse(ttf)
+	if err != nil {
+		return nil, err
+	}
+	return opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+}
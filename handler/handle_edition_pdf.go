@@ -0,0 +1,325 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-pdf/fpdf"
+	"github.com/gorilla/mux"
+	"github.com/monzo/slog"
+
+	"github.com/RusticPotatoes/news/domain"
+	"github.com/RusticPotatoes/news/pkg/dither"
+)
+
+// Page geometry for printed editions, in millimetres on A4
+const (
+	pdfMargin     = 12.0
+	pdfColumns    = 3
+	pdfGutter     = 5.0
+	pdfImageWidth = 400 // pixels, before dithering
+	pdfMaxImageH  = 55.0
+	pdfQRSize     = 16.0
+	pdfSummaryLen = 450
+)
+
+func handleEditionPDF(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	u := domain.UserFromContext(ctx)
+
+	e, _, err := editionForUser(ctx, u, mux.Vars(r)["id"])
+	if err != nil {
+		httpError(ctx, w, "Error getting edition", err)
+		return
+	}
+	if e == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	fetchCtx, cancel := context.WithTimeout(ctx, 20*time.Second)
+	images := fetchEditionImages(fetchCtx, e.Articles)
+	cancel()
+
+	doc := newEditionPDF(e, domain.PublicURL(), images)
+	var buf bytes.Buffer
+	err = doc.Render(&buf)
+	if err != nil {
+		httpError(ctx, w, "Error rendering pdf", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"edition-%s.pdf\"", e.ID))
+	_, err = buf.WriteTo(w)
+	if err != nil {
+		slog.Error(ctx, "Error writing pdf: %s", err)
+	}
+}
+
+// fetchEditionImages downloads and dithers the lead image of each article,
+// articles whose image can't be fetched in time are printed without one
+func fetchEditionImages(ctx context.Context, articles []domain.Article) map[string]image.Image {
+	var (
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		images = make(map[string]image.Image)
+		sem    = make(chan struct{}, 8)
+	)
+	for _, a := range articles {
+		if a.ImageURL == "" {
+			continue
+		}
+		wg.Add(1)
+		go func(id, imageURL string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			img, err := fetchImage(ctx, imageURL)
+			if err != nil {
+				slog.Warn(ctx, "Error getting image %s: %s", imageURL, err)
+				return
+			}
+			dithered, err := ditherImage(img, pdfImageWidth, dither.Options{})
+			if err != nil {
+				slog.Warn(ctx, "Error dithering image %s: %s", imageURL, err)
+				return
+			}
+			mu.Lock()
+			images[id] = dithered
+			mu.Unlock()
+		}(a.ID, a.ImageURL)
+	}
+	wg.Wait()
+	return images
+}
+
+// editionPDF lays an edition out as a paginated, multi column newspaper
+type editionPDF struct {
+	pdf     *fpdf.Fpdf
+	tr      func(string) string
+	edition *domain.Edition
+	baseURL string
+	images  map[string]image.Image
+
+	col      int
+	colWidth float64
+	// top is where the columns start on the current page, below the
+	// masthead or running header
+	top float64
+}
+
+func newEditionPDF(e *domain.Edition, baseURL string, images map[string]image.Image) *editionPDF {
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pageW, _ := pdf.GetPageSize()
+	d := &editionPDF{
+		pdf:      pdf,
+		tr:       pdf.UnicodeTranslatorFromDescriptor(""),
+		edition:  e,
+		baseURL:  baseURL,
+		images:   images,
+		colWidth: (pageW - 2*pdfMargin - (pdfColumns-1)*pdfGutter) / pdfColumns,
+	}
+	pdf.SetMargins(pdfMargin, pdfMargin, pdfMargin)
+	pdf.SetAutoPageBreak(true, pdfMargin+6)
+	pdf.SetTitle(e.Name+" - "+e.Date, true)
+	pdf.SetCreator("The Webpage", true)
+	pdf.SetHeaderFuncMode(d.header, false)
+	pdf.SetFooterFunc(d.footer)
+	pdf.SetAcceptPageBreakFunc(d.nextColumn)
+	return d
+}
+
+// Render writes the whole edition, section by section
+func (d *editionPDF) Render(w io.Writer) error {
+	d.pdf.AddPage()
+	for _, s := range d.edition.Sections() {
+		d.sectionHeading(s.Name)
+		for _, a := range s.Articles {
+			d.article(a)
+		}
+	}
+	if err := d.pdf.Error(); err != nil {
+		return err
+	}
+	return d.pdf.Output(w)
+}
+
+func (d *editionPDF) header() {
+	pdf := d.pdf
+	pageW, _ := pdf.GetPageSize()
+	pdf.SetLeftMargin(pdfMargin)
+	pdf.SetXY(pdfMargin, pdfMargin)
+	if pdf.PageNo() == 1 {
+		pdf.SetFont("Times", "B", 44)
+		pdf.CellFormat(0, 18, d.tr("The Webpage"), "", 1, "C", false, 0, "")
+		pdf.SetFont("Times", "I", 10)
+		pdf.CellFormat(0, 5, d.tr("The RSS Reader for the 20th Century"), "", 1, "C", false, 0, "")
+		pdf.SetLineWidth(0.6)
+		pdf.Line(pdfMargin, pdf.GetY()+1, pageW-pdfMargin, pdf.GetY()+1)
+		pdf.SetY(pdf.GetY() + 2)
+		pdf.SetFont("Times", "", 9)
+		pdf.CellFormat((pageW-2*pdfMargin)/2, 6, d.tr(d.edition.Name), "", 0, "L", false, 0, "")
+		pdf.CellFormat(0, 6, d.tr(d.edition.Date), "", 1, "R", false, 0, "")
+		pdf.Line(pdfMargin, pdf.GetY(), pageW-pdfMargin, pdf.GetY())
+		pdf.SetLineWidth(0.2)
+		pdf.SetY(pdf.GetY() + 4)
+	} else {
+		pdf.SetFont("Times", "I", 8)
+		pdf.CellFormat((pageW-2*pdfMargin)/2, 5, d.tr("The Webpage - "+d.edition.Name), "", 0, "L", false, 0, "")
+		pdf.CellFormat(0, 5, d.tr(d.edition.Date), "", 1, "R", false, 0, "")
+		pdf.Line(pdfMargin, pdf.GetY(), pageW-pdfMargin, pdf.GetY())
+		pdf.SetY(pdf.GetY() + 3)
+	}
+	d.top = pdf.GetY()
+	d.setColumn(0)
+}
+
+func (d *editionPDF) footer() {
+	pdf := d.pdf
+	pdf.SetLeftMargin(pdfMargin)
+	pdf.SetY(-pdfMargin - 2)
+	pdf.SetFont("Times", "", 8)
+	pdf.CellFormat(0, 5, fmt.Sprintf("%d", pdf.PageNo()), "", 0, "C", false, 0, "")
+}
+
+// nextColumn is called when text reaches the bottom of a column, it moves on
+// to the next column and only allows a page break after the last one
+func (d *editionPDF) nextColumn() bool {
+	if d.col < pdfColumns-1 {
+		d.setColumn(d.col + 1)
+		d.pdf.SetY(d.top)
+		return false
+	}
+	d.setColumn(0)
+	return true
+}
+
+func (d *editionPDF) setColumn(col int) {
+	d.col = col
+	x := pdfMargin + float64(col)*(d.colWidth+pdfGutter)
+	d.pdf.SetLeftMargin(x)
+	d.pdf.SetX(x)
+}
+
+// ensureSpace moves to the next column or page if there isn't h millimetres
+// left in the current column, for things that can't be split like images
+func (d *editionPDF) ensureSpace(h float64) {
+	_, pageH := d.pdf.GetPageSize()
+	_, _, _, bottom := d.pdf.GetMargins()
+	_, autoBottom := d.pdf.GetAutoPageBreak()
+	if bottom < autoBottom {
+		bottom = autoBottom
+	}
+	if d.pdf.GetY()+h <= pageH-bottom {
+		return
+	}
+	if !d.nextColumn() {
+		return
+	}
+	d.pdf.AddPage()
+}
+
+func (d *editionPDF) sectionHeading(name string) {
+	pdf := d.pdf
+	d.ensureSpace(40)
+	x := pdf.GetX()
+	pdf.SetFont("Helvetica", "B", 11)
+	pdf.CellFormat(d.colWidth, 7, d.tr(strings.ToUpper(name)), "B", 1, "L", false, 0, "")
+	pdf.SetX(x)
+	pdf.SetY(pdf.GetY() + 2)
+}
+
+func (d *editionPDF) article(a domain.Article) {
+	pdf := d.pdf
+	d.ensureSpace(25)
+
+	pdf.SetFont("Times", "B", 12)
+	pdf.MultiCell(d.colWidth, 5, d.tr(a.Title), "", "L", false)
+
+	byline := a.Source.Name
+	if !a.Timestamp.IsZero() {
+		byline += " - " + a.Timestamp.Format("Jan 2 15:04")
+	}
+	pdf.SetFont("Times", "I", 8)
+	pdf.MultiCell(d.colWidth, 4, d.tr(byline), "", "L", false)
+	pdf.SetY(pdf.GetY() + 1)
+
+	if img, ok := d.images[a.ID]; ok {
+		d.image(a.ID, img)
+	}
+
+	pdf.SetFont("Times", "", 9.5)
+	pdf.MultiCell(d.colWidth, 4.2, d.tr(a.Summary(pdfSummaryLen)), "", "J", false)
+	pdf.SetY(pdf.GetY() + 1)
+
+	d.qrCode(a)
+
+	x, y := pdf.GetX(), pdf.GetY()+2
+	pdf.SetLineWidth(0.1)
+	pdf.Line(x, y, x+d.colWidth, y)
+	pdf.SetLineWidth(0.2)
+	pdf.SetY(y + 3)
+}
+
+func (d *editionPDF) image(id string, img image.Image) {
+	pdf := d.pdf
+	b := img.Bounds()
+	if b.Dx() == 0 {
+		return
+	}
+	w := d.colWidth
+	h := w * float64(b.Dy()) / float64(b.Dx())
+	if h > pdfMaxImageH {
+		w = w * pdfMaxImageH / h
+		h = pdfMaxImageH
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		return
+	}
+	d.ensureSpace(h + 2)
+
+	opts := fpdf.ImageOptions{ImageType: "JPG"}
+	name := "img-" + id
+	pdf.RegisterImageOptionsReader(name, opts, &buf)
+	x, y := pdf.GetX(), pdf.GetY()
+	pdf.ImageOptions(name, x+(d.colWidth-w)/2, y, w, h, false, opts, 0, "")
+	pdf.SetY(y + h + 2)
+}
+
+// qrCode prints a code linking to the full article, next to a short note
+func (d *editionPDF) qrCode(a domain.Article) {
+	pdf := d.pdf
+	link := d.baseURL + "/article?id=" + url.QueryEscape(a.ID)
+	img, err := qrCode(link, 200)
+	if err != nil {
+		return
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		return
+	}
+	d.ensureSpace(pdfQRSize)
+
+	opts := fpdf.ImageOptions{ImageType: "JPG"}
+	name := "qr-" + a.ID
+	pdf.RegisterImageOptionsReader(name, opts, &buf)
+	x, y := pdf.GetX(), pdf.GetY()
+	pdf.ImageOptions(name, x, y, pdfQRSize, pdfQRSize, false, opts, 0, link)
+
+	pdf.SetFont("Times", "I", 8)
+	pdf.SetXY(x+pdfQRSize+2, y+pdfQRSize/2-2)
+	pdf.CellFormat(d.colWidth-pdfQRSize-2, 4, d.tr("Scan for the full article"), "", 0, "L", false, 0, link)
+	pdf.SetXY(x, y+pdfQRSize)
+}
@@ -2,90 +2,22 @@ package handler
 
 import (
 	"net/http"
-	"sort"
-	"time"
-	"unicode/utf8"
 
-	"github.com/RusticPotatoes/news/dao"
-	"github.com/RusticPotatoes/news/domain"
-	"github.com/monzo/slog"
+	"github.com/RusticPotatoes/news/pkg/jobs"
 )
 
+// handleGenerateEdition makes a new edition, unless there's already one for
+// the current window and force isn't set, and responds with its ID
 func handleGenerateEdition(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	e, err := dao.GetEditionForTime(ctx, time.Now(), false)
-	if err != nil {
-		slog.Error(ctx, "Error getting edition: %s", err)
-		http.Error(w, err.Error(), 500)
-		return
-	}
-	if e != nil && r.URL.Query().Get("force") == "" {
-		slog.Info(ctx, "Edition %s - %s already exists and is within window", e.ID, e.Name)
-		w.Write([]byte(e.ID))
-		return
-	}
-
-	e, err = domain.NewEdition(ctx, time.Now())
-	if err != nil {
-		slog.Error(ctx, "Error creating edition: %s", err)
-		http.Error(w, err.Error(), 500)
-		return
-	}
-
-	start := time.Now().Add(-72 * time.Hour)
-	end := time.Now()
-	articles, _, err := dao.GetArticlesForOwner(ctx, "", start, end)
-	if err != nil {
-		httpError(ctx, w, "error getting articles", err)
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-
-	newArticles := []domain.Article{}
-L:
-	for _, a := range articles {
-		if a.Content.Title == "" || a.Content.TextContent == "" {
-			continue L
-		}
-
-		if !utf8.Valid([]byte(a.Content.Title)) || 
-			!utf8.Valid([]byte(a.Content.TextContent)) {
-			continue L
-		}
-
-		newArticles = append(newArticles, a)
-	}
-	e.Articles = newArticles
-
-	bySource := make(map[string][]domain.Article)
-	for _, a := range e.Articles {
-		bySource[a.Source.Name] = append(bySource[a.Source.Name], a)
-	}
-	newArticles = nil
-	for _, as := range bySource {
-		sort.Slice(as, func(i, j int) bool {
-			return as[i].Timestamp.After(as[j].Timestamp)
-		})
-	}
-top:
-	for s, as := range bySource {
-		newArticles = append(newArticles, as[0])
-		bySource[s] = as[1:]
-		if len(bySource[s]) == 0 {
-			delete(bySource, s)
-			goto top
-		}
-	}
-	if len(bySource) != 0 {
-		goto top
-	}
-	e.Articles = newArticles
-
-	err = dao.SetEdition(ctx, e)
+	e, err := jobs.GenerateEdition(ctx, r.FormValue("force") != "")
 	if err != nil {
-		slog.Error(ctx, "Error storing edition: %s", err)
-		http.Error(w, err.Error(), 500)
+		httpError(ctx, w, "error generating edition", err)
 		return
 	}
-	slog.Info(ctx, "Created new edition: %s - %s", e.ID, e.Name)
 	w.Write([]byte(e.ID))
 }
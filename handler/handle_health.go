@@ -0,0 +1,92 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/monzo/slog"
+
+	"github.com/RusticPotatoes/news/dao"
+	"github.com/RusticPotatoes/news/pkg/util"
+)
+
+// readyTimeout bounds each readiness check, so a stuck database fails the
+// check rather than hanging it
+const readyTimeout = 2 * time.Second
+
+var (
+	readyMu     sync.Mutex
+	readyChecks = map[string]func(ctx context.Context) error{
+		"database": dao.Ping,
+	}
+)
+
+// AddReadyCheck adds a check /readyz runs, the server isn't ready while it
+// returns an error
+func AddReadyCheck(name string, check func(ctx context.Context) error) {
+	readyMu.Lock()
+	defer readyMu.Unlock()
+	readyChecks[name] = check
+}
+
+// handleHealthz reports the process is up, it doesn't check anything else
+// so a slow database doesn't get the server restarted
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "ok")
+}
+
+// handleReadyz runs every readiness check, and fails if any do
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+	defer cancel()
+
+	readyMu.Lock()
+	names := make([]string, 0, len(readyChecks))
+	checks := make(map[string]func(ctx context.Context) error, len(readyChecks))
+	for name, check := range readyChecks {
+		names = append(names, name)
+		checks[name] = check
+	}
+	readyMu.Unlock()
+	sort.Strings(names)
+
+	var (
+		out    strings.Builder
+		failed bool
+	)
+	for _, name := range names {
+		if err := checks[name](ctx); err != nil {
+			slog.Warn(ctx, "Readiness check %s failed: %s", name, err)
+			fmt.Fprintf(&out, "%s: %s\n", name, err)
+			failed = true
+			continue
+		}
+		fmt.Fprintf(&out, "%s: ok\n", name)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if failed {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	fmt.Fprint(w, out.String())
+}
+
+// routeMiddleware records the matched route's template on the request, so
+// request metrics are grouped by route rather than by URL
+func routeMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if route := mux.CurrentRoute(r); route != nil {
+			if tmpl, err := route.GetPathTemplate(); err == nil {
+				util.SetParam(r.Context(), util.RouteParam, tmpl)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -1,42 +1,57 @@
 package handler
 
 import (
-	"fmt"
-	"html/template"
 	"net/http"
-	"os"
+	"strings"
+	"time"
 
 	"github.com/RusticPotatoes/news/dao"
 	"github.com/RusticPotatoes/news/domain"
-	"github.com/dgrijalva/jwt-go"
 	"github.com/monzo/slog"
 )
 
 type loginPage struct {
+	Signup domain.SignupMode
+	// Invite is the code from an invite link
+	Invite string
+	// Message is shown above the form, it's used instead of base.Error so
+	// the form stays on the page
+	Message string
+	// SSO names the single sign-on provider, it's empty if there isn't one
+	SSO string
 	base
 }
 
 func handleLogin(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-
 	if r.Method == http.MethodPost {
 		loginRedirect(w, r)
 		return
 	}
+	renderLogin(w, r, "")
+}
+
+func renderLogin(w http.ResponseWriter, r *http.Request, msg string) {
+	ctx := r.Context()
 
-	t := template.New("frame.html")
-	t, err := t.ParseFiles("tmpl/frame.html", "tmpl/meta.html", "tmpl/login.html")
+	t, err := templates.get("login.html")
 	if err != nil {
 		slog.Error(ctx, "Error parsing template: %s", err)
 		http.Error(w, err.Error(), 500)
 		return
 	}
 	l := loginPage{
+		Signup:  domain.Signup(),
+		Invite:  r.FormValue("invite"),
+		Message: msg,
 		base: base{
-			ID:   "Login",
-			User: domain.UserFromContext(ctx),
+			ID:        "Login",
+			User:      domain.UserFromContext(ctx),
+			CSRFToken: csrfToken(ctx),
 		},
 	}
+	if c := oidcFromConfig(); c.Enabled() {
+		l.SSO = c.Name
+	}
 	err = t.Execute(w, &l)
 	if err != nil {
 		slog.Error(ctx, "Error executing template: %s", err)
@@ -45,42 +60,57 @@ func handleLogin(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// pendingMessage is shown to readers whose account hasn't been approved
+const pendingMessage = "Your account is waiting for an admin to approve it."
+
 func loginRedirect(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	r.ParseForm()
 	username := r.Form.Get("username")
 	pw := r.Form.Get("pw")
 	signup := r.Form.Get("signup")
+	ip := clientIP(r)
 
-	if signup != "" {
-		u, err := dao.GetUserByName(ctx, username)
+	// signing up with a taken name checks the password too, so both are
+	// throttled
+	now := time.Now()
+	ok, wait := loginLimiter.Allow(ip, now)
+	if ok {
+		var err error
+		wait, err = loginWait(ctx, username, ip, now)
 		if err != nil {
-			slog.Error(ctx, "Err: %s", err)
+			slog.Error(ctx, "Error checking login attempts: %s", err)
+			http.Error(w, "error logging in", 500)
+			return
 		}
-		if u != nil {
-			slog.Info(ctx, "User already exists: %s", username)
-			goto login
+	}
+	if wait > 0 {
+		w.Header().Set("Retry-After", retryAfter(wait))
+		w.WriteHeader(http.StatusTooManyRequests)
+		renderLogin(w, r, waitMessage(wait))
+		return
+	}
+
+	if signup != "" {
+		u, err := signupUser(ctx, username, pw, r.Form.Get("invite"))
+		if err == errUsernameTaken {
+			recordLogin(ctx, username, ip, false)
+		}
+		if errMsg, ok := err.(signupError); ok {
+			renderLogin(w, r, string(errMsg))
+			return
 		}
-		u = domain.NewUser(ctx, username, pw, false)
-		err = dao.SetUser(ctx, u)
 		if err != nil {
 			slog.Error(ctx, "Error creating user: %s", err)
-			http.Error(w, "couldn't create user", 404)
+			http.Error(w, "couldn't create user", 500)
 			return
 		}
-		for _, src := range domain.GetSources() {
-			src := src
-			// src.ID = idgen.New("src")
-			src.OwnerID = u.ID
-			err := dao.SetSource(ctx, &src)
-			if err != nil {
-				slog.Error(ctx, "Error creating user: %s", err)
-				http.Error(w, "couldn't create user", 404)
-				return
-			}
+		if u.Pending {
+			renderLogin(w, r, pendingMessage)
+			return
 		}
 	}
-login:
+
 	u, err := dao.GetUserByName(ctx, username)
 	if err != nil {
 		slog.Error(ctx, "Error getting user: %s", err)
@@ -89,66 +119,159 @@ login:
 	}
 
 	if !u.ValidatePassword(pw) {
-		http.Error(w, "couldn't find user", 404)
+		recordLogin(ctx, username, ip, false)
+		w.WriteHeader(http.StatusUnauthorized)
+		renderLogin(w, r, "Wrong username or password.")
+		return
+	}
+	recordLogin(ctx, username, ip, true)
+	if u.Pending {
+		renderLogin(w, r, pendingMessage)
 		return
 	}
 
-	sess, err := u.Session()
+	err = startSession(w, r, u)
 	if err != nil {
 		slog.Error(ctx, "Error creating session: %s", err)
 		http.Error(w, "error creating session", 500)
 		return
 	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// sessionCookie is the name of the cookie holding the session token
+const sessionCookie = "sess"
+
+// startSession stores a new session for u and sets its cookie
+func startSession(w http.ResponseWriter, r *http.Request, u *domain.User) error {
+	now := time.Now()
+	sess, err := domain.NewSession(u, r.UserAgent(), now)
+	if err != nil {
+		return err
+	}
+	err = dao.SetSession(r.Context(), sess)
+	if err != nil {
+		return err
+	}
+	err = dao.SetUserLastLogin(r.Context(), u.ID, now)
+	if err != nil {
+		return err
+	}
+	return setSessionCookie(w, r, sess, now)
+}
 
+func setSessionCookie(w http.ResponseWriter, r *http.Request, sess *domain.Session, now time.Time) error {
+	token, err := sess.Token(now)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  sess.Expires,
+		HttpOnly: true,
+		Secure:   secureRequest(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+func clearSessionCookie(w http.ResponseWriter, r *http.Request) {
 	http.SetCookie(w, &http.Cookie{
-		Name:  "sess",
-		Value: sess,
+		Name:     sessionCookie,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   secureRequest(r),
+		SameSite: http.SameSiteLaxMode,
 	})
-	http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 }
 
+// secureRequest reports whether the reader is connected over HTTPS, either
+// directly or through a proxy, so cookies can be kept off plain HTTP
+func secureRequest(r *http.Request) bool {
+	return r.TLS != nil ||
+		strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https") ||
+		strings.HasPrefix(domain.PublicURL(), "https://")
+}
+
+func handleLogout(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if sess := domain.SessionFromContext(ctx); sess != nil {
+		err := dao.RevokeSession(ctx, sess.UserID, sess.ID)
+		if err != nil {
+			slog.Error(ctx, "Error revoking session: %s", err)
+		}
+	}
+	clearSessionCookie(w, r)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// sessionMiddleware adds the reader and their session to the context. The
+// token only names the session, which has to be stored and not revoked,
+// and the token is reissued once a day to keep the session alive.
+// Scripts can send an API token as a bearer token instead, other schemes
+// such as basic auth from a proxy in front are left alone.
 func sessionMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
-		sessionCookie, err := r.Cookie("sess")
-		if err != nil {
-			slog.Info(ctx, "no cookie: %s", err)
+		if token, ok := bearerToken(r); ok {
+			tokenAuth(w, r, next, token)
+			return
+		}
+		cookie, err := r.Cookie(sessionCookie)
+		if err != nil || cookie.Value == "" {
 			next.ServeHTTP(w, r)
 			return
 		}
 
-		if sessionCookie.Value == "" {
+		claims, err := domain.ParseSessionToken(cookie.Value)
+		if err != nil {
+			slog.Info(ctx, "Invalid session token: %s", err)
+			clearSessionCookie(w, r)
 			next.ServeHTTP(w, r)
 			return
 		}
 
-		token, err := jwt.Parse(sessionCookie.Value, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
-			}
+		now := time.Now()
+		sess, err := dao.GetSession(ctx, claims.SessionID)
+		if err != nil {
+			slog.Error(ctx, "Error getting session: %s", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !sess.Valid(now) || sess.UserID != claims.UserID {
+			slog.Info(ctx, "Session %s has ended", claims.SessionID)
+			clearSessionCookie(w, r)
+			next.ServeHTTP(w, r)
+			return
+		}
 
-			return []byte(os.Getenv("TOKEN_SECRET")), nil
-		})
+		u, err := dao.GetUser(ctx, sess.UserID)
+		if err != nil || u == nil || u.Pending {
+			slog.Info(ctx, "no user: %s %s", sess.UserID, err)
+			next.ServeHTTP(w, r)
+			return
+		}
 
-		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-			userID, ok := claims["user"].(string)
-			if !ok {
-				slog.Info(ctx, "no user claim")
-				next.ServeHTTP(w, r)
-				return
+		if now.Sub(claims.Issued) > domain.SessionRefresh {
+			sess.Refresh(now)
+			err = dao.SetSession(ctx, sess)
+			if err == nil {
+				err = setSessionCookie(w, r, sess, now)
 			}
-			u, err := dao.GetUser(ctx, userID)
 			if err != nil {
-				slog.Info(ctx, "no user: %s %s", userID, err)
-				next.ServeHTTP(w, r)
-				return
+				slog.Error(ctx, "Error refreshing session: %s", err)
 			}
-			slog.Info(ctx, "User session: %s %s", u.ID, u.Name)
-			ctx := domain.WithUser(ctx, u)
-			r = r.WithContext(ctx)
-		} else {
-			slog.Error(ctx, "invalid token %s", token)
 		}
-		next.ServeHTTP(w, r)
+
+		ctx = domain.WithUser(ctx, u)
+		ctx = domain.WithSession(ctx, sess)
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
@@ -0,0 +1,370 @@
+package handler
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/monzo/slog"
+	"golang.org/x/oauth2"
+
+	"github.com/RusticPotatoes/news/dao"
+	"github.com/RusticPotatoes/news/domain"
+	"github.com/RusticPotatoes/news/pkg/jobs"
+)
+
+const (
+	// oidcCookie holds the state of a sign-on between leaving for the
+	// provider and coming back
+	oidcCookie = "oidc"
+	// oidcFlowTimeout is how long the reader has to sign in at the provider
+	oidcFlowTimeout = 10 * time.Minute
+	// oidcTimeout bounds each request to the provider
+	oidcTimeout = 10 * time.Second
+)
+
+// oidcConfig is the single sign-on provider from the config, with the
+// redirect URL filled in
+type oidcConfig struct {
+	domain.OIDCConfig
+}
+
+func oidcFromConfig() oidcConfig {
+	c := oidcConfig{config.OIDC}
+	if c.RedirectURL == "" {
+		c.RedirectURL = domain.PublicURL() + "/login/oidc/callback"
+	}
+	if c.Name == "" {
+		c.Name = "single sign-on"
+	}
+	return c
+}
+
+func (c oidcConfig) Enabled() bool {
+	return c.Issuer != "" && c.ClientID != ""
+}
+
+func (c oidcConfig) allowed(email string) bool {
+	if len(c.AllowedDomains) == 0 {
+		return true
+	}
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	domain := strings.ToLower(email[at+1:])
+	for _, d := range c.AllowedDomains {
+		if domain == d {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	oidcMu        sync.Mutex
+	oidcProviders = make(map[string]*oidc.Provider)
+)
+
+// oidcClient is the context for requests to the provider
+func oidcClient(ctx context.Context) context.Context {
+	return oidc.ClientContext(ctx, &http.Client{Timeout: oidcTimeout})
+}
+
+// oidcDiscover fetches the issuer's endpoints and keys, keeping them once
+// it's succeeded so a provider that's down at startup is retried
+func oidcDiscover(c oidcConfig) (*oidc.Provider, error) {
+	oidcMu.Lock()
+	defer oidcMu.Unlock()
+	if p, ok := oidcProviders[c.Issuer]; ok {
+		return p, nil
+	}
+	// the provider refreshes its keys with this context long after the
+	// request that discovered it, so it can't be the request's
+	p, err := oidc.NewProvider(oidcClient(context.Background()), c.Issuer)
+	if err != nil {
+		return nil, err
+	}
+	oidcProviders[c.Issuer] = p
+	return p, nil
+}
+
+func (c oidcConfig) oauth2(p *oidc.Provider) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     c.ClientID,
+		ClientSecret: c.ClientSecret,
+		RedirectURL:  c.RedirectURL,
+		Endpoint:     p.Endpoint(),
+		Scopes:       []string{oidc.ScopeOpenID, "email", "profile"},
+	}
+}
+
+// oidcState is kept in a signed cookie while the reader is at the
+// provider, so the callback can check it's the sign-on this browser
+// started
+type oidcState struct {
+	State    string
+	Nonce    string
+	Verifier string
+	Expires  time.Time
+}
+
+func (s *oidcState) encode() (string, error) {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	return payload + "." + oidcSign(payload), nil
+}
+
+func decodeOIDCState(v string, now time.Time) (*oidcState, error) {
+	payload, sig, ok := strings.Cut(v, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(oidcSign(payload))) {
+		return nil, errors.New("invalid sign-on state")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, err
+	}
+	var s oidcState
+	err = json.Unmarshal(b, &s)
+	if err != nil {
+		return nil, err
+	}
+	if now.After(s.Expires) {
+		return nil, errors.New("sign-on took too long")
+	}
+	return &s, nil
+}
+
+func oidcSign(payload string) string {
+	mac := hmac.New(sha256.New, domain.TokenSecret())
+	mac.Write([]byte("oidc:" + payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// handleOIDCLogin sends the reader to the provider to sign in
+func handleOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	c := oidcFromConfig()
+	if !c.Enabled() {
+		http.NotFound(w, r)
+		return
+	}
+	p, err := oidcDiscover(c)
+	if err != nil {
+		slog.Error(ctx, "Error discovering OIDC issuer %s: %s", c.Issuer, err)
+		renderLogin(w, r, "Single sign-on isn't available right now, try again later.")
+		return
+	}
+
+	st := oidcState{
+		Verifier: oauth2.GenerateVerifier(),
+		Expires:  time.Now().Add(oidcFlowTimeout),
+	}
+	if st.State, err = randomString(); err == nil {
+		st.Nonce, err = randomString()
+	}
+	var cookie string
+	if err == nil {
+		cookie, err = st.encode()
+	}
+	if err != nil {
+		httpError(ctx, w, "error starting sign-on", err)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookie,
+		Value:    cookie,
+		Path:     "/login/oidc",
+		Expires:  st.Expires,
+		HttpOnly: true,
+		Secure:   secureRequest(r),
+		// the provider sends the reader back with a top level GET
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	to := c.oauth2(p).AuthCodeURL(st.State, oidc.Nonce(st.Nonce), oauth2.S256ChallengeOption(st.Verifier))
+	http.Redirect(w, r, to, http.StatusFound)
+}
+
+// handleOIDCCallback finishes sign-on: it swaps the code for an ID token,
+// checks it, and logs in the account with its email address, creating one
+// if there isn't one
+func handleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	c := oidcFromConfig()
+	if !c.Enabled() {
+		http.NotFound(w, r)
+		return
+	}
+	q := r.URL.Query()
+
+	cookie, err := r.Cookie(oidcCookie)
+	if err != nil {
+		renderLogin(w, r, "Your sign-on expired, try again.")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookie,
+		Path:     "/login/oidc",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   secureRequest(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	st, err := decodeOIDCState(cookie.Value, time.Now())
+	if err != nil {
+		slog.Warn(ctx, "Bad OIDC state cookie: %s", err)
+		renderLogin(w, r, "Your sign-on expired, try again.")
+		return
+	}
+	if !hmac.Equal([]byte(q.Get("state")), []byte(st.State)) {
+		slog.Warn(ctx, "OIDC callback state doesn't match")
+		renderLogin(w, r, "Your sign-on expired, try again.")
+		return
+	}
+	if e := q.Get("error"); e != "" {
+		slog.Warn(ctx, "OIDC provider returned %s: %s", e, q.Get("error_description"))
+		renderLogin(w, r, "Single sign-on was cancelled or refused.")
+		return
+	}
+
+	p, err := oidcDiscover(c)
+	if err != nil {
+		slog.Error(ctx, "Error discovering OIDC issuer %s: %s", c.Issuer, err)
+		renderLogin(w, r, "Single sign-on isn't available right now, try again later.")
+		return
+	}
+	reqCtx := oidcClient(ctx)
+	tok, err := c.oauth2(p).Exchange(reqCtx, q.Get("code"), oauth2.VerifierOption(st.Verifier))
+	if err != nil {
+		slog.Error(ctx, "Error exchanging OIDC code: %s", err)
+		renderLogin(w, r, "Single sign-on failed, try again.")
+		return
+	}
+	raw, ok := tok.Extra("id_token").(string)
+	if !ok {
+		slog.Error(ctx, "OIDC token response had no id_token")
+		renderLogin(w, r, "Single sign-on failed, try again.")
+		return
+	}
+	idToken, err := p.Verifier(&oidc.Config{ClientID: c.ClientID}).Verify(reqCtx, raw)
+	if err != nil {
+		slog.Error(ctx, "Invalid OIDC ID token: %s", err)
+		renderLogin(w, r, "Single sign-on failed, try again.")
+		return
+	}
+	if !hmac.Equal([]byte(idToken.Nonce), []byte(st.Nonce)) {
+		slog.Warn(ctx, "OIDC ID token nonce doesn't match")
+		renderLogin(w, r, "Single sign-on failed, try again.")
+		return
+	}
+	var claims struct {
+		Email         string `json:"email"`
+		EmailVerified *bool  `json:"email_verified"`
+	}
+	err = idToken.Claims(&claims)
+	if err != nil {
+		slog.Error(ctx, "Error reading OIDC claims: %s", err)
+		renderLogin(w, r, "Single sign-on failed, try again.")
+		return
+	}
+	// an address is only trusted without email_verified if the provider
+	// is configured as one that only hands out addresses it manages
+	verified := claims.EmailVerified != nil && *claims.EmailVerified
+	if claims.EmailVerified == nil && c.TrustEmail {
+		verified = true
+	}
+
+	u, err := oidcUser(ctx, c, strings.ToLower(claims.Email), verified)
+	if errMsg, ok := err.(signupError); ok {
+		renderLogin(w, r, string(errMsg))
+		return
+	}
+	if err != nil {
+		httpError(ctx, w, "error signing in", err)
+		return
+	}
+	recordLogin(ctx, u.Name, clientIP(r), true)
+	if u.Pending {
+		renderLogin(w, r, pendingMessage)
+		return
+	}
+
+	err = startSession(w, r, u)
+	if err != nil {
+		slog.Error(ctx, "Error creating session: %s", err)
+		http.Error(w, "error creating session", 500)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// oidcUser finds the account for a signed on address, creating it with the
+// default sources if signup allows. Invite codes don't apply, so in invite
+// mode accounts are only created for the allowed domains.
+func oidcUser(ctx context.Context, c oidcConfig, email string, verified bool) (*domain.User, error) {
+	if email == "" || !verified {
+		return nil, signupError("Your account at the provider needs a verified email address.")
+	}
+	if !c.allowed(email) {
+		return nil, signupError("Your email address isn't allowed to sign on here.")
+	}
+
+	u, err := dao.GetUserByEmail(ctx, email)
+	if err != nil || u != nil {
+		return u, err
+	}
+
+	// a password account with the address as its name could belong to
+	// anyone, it mustn't be handed to whoever signs on with the address
+	existing, err := dao.GetUserByName(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, signupError("An account named " + email + " already exists, log in with its password.")
+	}
+
+	mode := domain.Signup()
+	if mode == domain.SignupDisabled {
+		return nil, signupError("Signing up is closed, ask an admin for an account.")
+	}
+	if mode == domain.SignupInvite && len(c.AllowedDomains) == 0 {
+		return nil, signupError("Signing up needs an invite, ask an admin for one and sign up with a password.")
+	}
+	u = &domain.User{
+		Name:    email,
+		Email:   email,
+		Created: time.Now(),
+		Pending: mode == domain.SignupApproval,
+	}
+	err = jobs.CreateUser(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info(ctx, "New user %s signed on with %s, signup is %s", u.Name, c.Issuer, mode)
+
+	// reload for the ID
+	return dao.GetUserByEmail(ctx, email)
+}
@@ -1,7 +1,6 @@
 package handler
 
 import (
-	"html/template"
 	"net/http"
 	"regexp"
 	"sort"
@@ -20,6 +19,10 @@ import (
 type newsPage struct {
 	base
 	Articles []domain.Article
+	// Hidden are the articles removed by the reader's rules
+	Hidden []domain.Article
+	// Contents lists the section pages
+	Contents []contentsEntry
 
 	claimed      map[string]bool
 	cacheIndex   int
@@ -28,8 +31,7 @@ type newsPage struct {
 
 func handleNews(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	t := template.New("frame.html")
-	t, err := t.ParseFiles("tmpl/frame.html", "tmpl/meta.html", "tmpl/frontpage-1.html", "tmpl/section.html", "tmpl/article-tile.html")
+	t, err := templates.get("frontpage-1.html")
 	if err != nil {
 		slog.Error(ctx, "Error parsing template: %s", err)
 		http.Error(w, err.Error(), 500)
@@ -41,7 +43,8 @@ func handleNews(w http.ResponseWriter, r *http.Request) {
 	var (
 		p = newsPage{
 			base: base{
-				User: u,
+				User:      u,
+				CSRFToken: csrfToken(ctx),
 				Meta: Meta{
 					Title:       "The Webpage",
 					Description: "The RSS Reader for the 20th Century",
@@ -75,10 +78,6 @@ func handleNews(w http.ResponseWriter, r *http.Request) {
 			smap[cat] = struct{}{}
 		}
 	}
-	for cat := range smap {
-		p.Categories = append(p.Categories, cat)
-	}
-	sort.Strings(p.Categories)
 	p.DisableCache = true
 	newArticles := []domain.Article{}
 L:
@@ -94,65 +93,24 @@ L:
 		// set other fields of a from a.Content as needed
 
 		a.Source = byFeedURL[a.Source.FeedURL]
+		for _, tag := range a.Tags {
+			smap[tag] = struct{}{}
+		}
 		newArticles = append(newArticles, a)
 	}
 	p.Articles = newArticles
-
-	bySource := make(map[string][]domain.Article)
-	for _, a := range p.Articles {
-		bySource[a.Source.FeedURL] = append(bySource[a.Source.FeedURL], a)
-	}
-	newArticles = nil
-	for _, as := range bySource {
-		sort.Slice(as, func(i, j int) bool {
-			return as[i].Timestamp.After(as[j].Timestamp)
-		})
-	}
-	keys := []string{}
-	for k := range bySource {
-		keys = append(keys, k)
-	}
-	sort.Strings(keys)
-top:
-	for _, key := range keys {
-		as, ok := bySource[key]
-		if !ok {
-			continue
-		}
-		newArticles = append(newArticles, as[0])
-		bySource[key] = as[1:]
-		if len(bySource[key]) == 0 {
-			delete(bySource, key)
-			goto top
-		}
-	}
-	if len(bySource) != 0 {
-		goto top
+	for cat := range smap {
+		p.Categories = append(p.Categories, cat)
 	}
-	p.Articles = newArticles
+	sort.Strings(p.Categories)
 
 	cat := r.URL.Query().Get("cat")
 	if cat != "" {
-		sources := domain.GetSources()
-		if u != nil {
-			sources, err = dao.GetSources(ctx, u.Name)
-			if err != nil {
-				http.Error(w, "Error getting sources", 500)
-				return
-			}
-		}
-		sourceCats := make(map[string][]string)
-		for _, s := range sources {
-			sourceCats[s.FeedURL] = s.Categories
-		}
+		// articles already carry their source's categories
 		newArticles := []domain.Article{}
-	articles:
 		for _, a := range p.Articles {
-			for _, c := range sourceCats[a.Source.FeedURL] {
-				if c == cat {
-					newArticles = append(newArticles, a)
-					continue articles
-				}
+			if a.HasCategory(cat) {
+				newArticles = append(newArticles, a)
 			}
 		}
 		p.Articles = newArticles
@@ -170,6 +128,21 @@ top:
 		p.Articles = newArticles
 	}
 
+	if u != nil {
+		rules, err := dao.GetRules(ctx, u.ID)
+		if err != nil {
+			slog.Error(ctx, "Error getting rules: %s", err)
+			http.Error(w, "Error getting rules", 500)
+			return
+		}
+		p.Articles, p.Hidden = domain.ApplyRules(rules, p.Articles)
+	}
+	// rules go first so source caps keep highlighted articles
+	p.Articles = domain.SelectArticles(p.Articles)
+	if cat == "" && src == "" {
+		p.Contents = tableOfContents(domain.BuildSections(p.Articles, p.Categories), "/section/")
+	}
+
 	err = t.Execute(w, &p)
 	if err != nil {
 		slog.Error(ctx, "Error executing template: %s", err)
@@ -243,7 +216,9 @@ top:
 			if e.claimed[a.ID] {
 				continue
 			}
-			if a.Timestamp.After(candidate.Timestamp) {
+			// articles are in the order SelectArticles gave
+			// them, take the first of the highest priority
+			if candidate.ID == "" || a.Priority() > candidate.Priority() {
 				candidate = a
 			}
 		}
@@ -2,8 +2,10 @@ package handler
 
 import (
 	"fmt"
+	"image"
 	"image/jpeg"
 	"net/http"
+	"net/url"
 
 	"github.com/boombuler/barcode"
 	"github.com/boombuler/barcode/qr"
@@ -15,10 +17,7 @@ func handleQRCode(w http.ResponseWriter, r *http.Request) {
 
 	id := r.URL.Query().Get("id")
 
-	b, err := qr.Encode(fmt.Sprintf("https://news.russellsaw.io/?id=%s", id), qr.M, qr.Auto)
-
-	b, _ = barcode.Scale(b, 100, 100)
-
+	b, err := qrCode(fmt.Sprintf("%s/?id=%s", config.BaseURL, url.QueryEscape(id)), 100)
 	if err != nil {
 		slog.Error(ctx, "Error encoding barcode: %s", err)
 		http.Error(w, err.Error(), 500)
@@ -30,3 +29,12 @@ func handleQRCode(w http.ResponseWriter, r *http.Request) {
 		slog.Error(ctx, "Error writing image: %s", err)
 	}
 }
+
+// qrCode encodes content as a square QR code of size pixels
+func qrCode(content string, size int) (image.Image, error) {
+	b, err := qr.Encode(content, qr.M, qr.Auto)
+	if err != nil {
+		return nil, err
+	}
+	return barcode.Scale(b, size, size)
+}
@@ -5,16 +5,25 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"strings"
 
 	"github.com/RusticPotatoes/news/dao"
 	"github.com/monzo/slog"
 )
 
 func handleRefreshArticle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
 	var (
 		ctx = r.Context()
-		id  = r.URL.Query().Get("id")
+		id  = r.FormValue("id")
 	)
+	if config.ReadabilityURL == "" {
+		http.Error(w, "refreshing articles needs a readability server", http.StatusNotImplemented)
+		return
+	}
 
 	a, err := dao.GetArticle(ctx, id)
 	if err != nil {
@@ -25,7 +34,7 @@ func handleRefreshArticle(w http.ResponseWriter, r *http.Request) {
 	slogParams := map[string]string{
 		"url": a.Link,
 	}
-	res, err := c.Get(fmt.Sprintf("https://readability-server.russellsaw.io/?url=%s", url.QueryEscape(a.Link)))
+	res, err := c.Get(fmt.Sprintf("%s/?url=%s", strings.TrimSuffix(config.ReadabilityURL, "/"), url.QueryEscape(a.Link)))
 	if err != nil {
 		slog.Error(ctx, "Error fetching article: %s", err, slogParams)
 		return
@@ -57,5 +66,5 @@ func handleRefreshArticle(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	slog.Info(ctx, "Stored article: %s - %s", a.ID, a.Title)
-	http.Redirect(w, r, fmt.Sprintf("/article?id=%s", a.ID), 307)
+	http.Redirect(w, r, fmt.Sprintf("/article?id=%s", a.ID), http.StatusSeeOther)
 }
@@ -16,6 +16,7 @@ type result struct {
 
 type searchPage struct {
 	Results []result
+	Hidden  []domain.Article
 	Query   string
 	url     string
 }
@@ -28,10 +29,20 @@ func handleSearch(w http.ResponseWriter, r *http.Request) (interface{}, error) {
 	if err != nil {
 		return nil, err
 	}
+	var hidden []domain.Article
 	
+	if u := domain.UserFromContext(ctx); u != nil {
+		rules, err := dao.GetRules(ctx, u.ID)
+		if err != nil {
+			return nil, err
+		}
+		searchResults, hidden = domain.ApplyRules(rules, searchResults)
+	}
+
 	p := searchPage{
-		Query: query,
-		url:   r.URL.String(),
+		Query:  query,
+		Hidden: hidden,
+		url:    r.URL.String(),
 	}
 	for _, article := range searchResults {
 		p.Results = append(p.Results, result{
@@ -39,7 +50,10 @@ func handleSearch(w http.ResponseWriter, r *http.Request) (interface{}, error) {
 		})
 	}
 
-	sort.Slice(p.Results, func(i, j int) bool {
+	sort.SliceStable(p.Results, func(i, j int) bool {
+		if p.Results[i].Article.Priority() != p.Results[j].Article.Priority() {
+			return p.Results[i].Article.Priority() > p.Results[j].Article.Priority()
+		}
 		return p.Results[i].Article.Timestamp.After(p.Results[j].Article.Timestamp)
 	})
 	return p, nil
@@ -0,0 +1,236 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"github.com/monzo/slog"
+
+	"github.com/RusticPotatoes/news/dao"
+	"github.com/RusticPotatoes/news/domain"
+)
+
+// editionFrontArticles is how many of an edition's top stories make its
+// front page, the rest are found in the section fronts
+const editionFrontArticles = 16
+
+type sectionPage struct {
+	base
+	Heading  string
+	Edition  *domain.Edition
+	Contents []contentsEntry
+	Articles []domain.Article
+	Hidden   []domain.Article
+}
+
+// contentsEntry is a line in a table of contents
+type contentsEntry struct {
+	Name  string
+	URL   string
+	Count int
+}
+
+// tableOfContents lists each section, linking to its page under prefix
+func tableOfContents(sections []domain.Section, prefix string) []contentsEntry {
+	out := make([]contentsEntry, 0, len(sections))
+	for _, s := range sections {
+		out = append(out, contentsEntry{
+			Name:  s.Name,
+			URL:   prefix + url.PathEscape(s.Name),
+			Count: len(s.Articles),
+		})
+	}
+	return out
+}
+
+func handleSection(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	u := domain.UserFromContext(ctx)
+	name := mux.Vars(r)["category"]
+
+	articles, hidden, categories, err := userArticles(ctx, u)
+	if err != nil {
+		httpError(ctx, w, "Error getting articles", err)
+		return
+	}
+
+	// the same sections as the front page's table of contents, so the
+	// counts there match and "more" has a page too
+	sections := domain.BuildSections(articles, categories)
+	section := domain.FindSection(sections, name)
+	if section == nil {
+		// categories are sorted
+		i := sort.SearchStrings(categories, name)
+		if name != domain.SectionMore && (i == len(categories) || categories[i] != name) {
+			http.NotFound(w, r)
+			return
+		}
+		// a category can have every article taken by other sections
+		section = &domain.Section{Name: name}
+	}
+	var sectionHidden []domain.Article
+	if s := domain.FindSection(domain.BuildSections(hidden, categories), name); s != nil {
+		sectionHidden = s.Articles
+	}
+
+	p := sectionPage{
+		base: base{
+			User:       u,
+			CSRFToken:  csrfToken(ctx),
+			Categories: categories,
+			Meta: Meta{
+				Title:       strings.Title(name) + " - The Webpage",
+				Description: fmt.Sprintf("The %s section of The Webpage", name),
+				Image:       "/static/images/preview.png",
+				URL:         r.URL.String(),
+			},
+		},
+		Heading:  name,
+		Contents: tableOfContents(sections, "/section/"),
+		Articles: domain.LayoutArticles(section.Articles),
+		Hidden:   sectionHidden,
+	}
+	renderSectionPage(ctx, w, &p)
+}
+
+func handleEdition(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	u := domain.UserFromContext(ctx)
+	vars := mux.Vars(r)
+
+	e, hidden, err := editionForUser(ctx, u, vars["id"])
+	if err != nil {
+		httpError(ctx, w, "Error getting edition", err)
+		return
+	}
+	if e == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	prefix := fmt.Sprintf("/edition/%s/section/", e.ID)
+	p := sectionPage{
+		base: base{
+			User:       u,
+			CSRFToken:  csrfToken(ctx),
+			Categories: e.Categories,
+			Meta: Meta{
+				Title:       e.Name + " - " + e.Date,
+				Description: "The RSS Reader for the 20th Century",
+				Image:       "/static/images/preview.png",
+				URL:         r.URL.String(),
+			},
+		},
+		Edition:  e,
+		Heading:  e.Name,
+		Contents: tableOfContents(e.Sections(), prefix),
+		Hidden:   hidden,
+	}
+
+	section := vars["section"]
+	if section == "" {
+		front := e.Articles
+		if len(front) > editionFrontArticles {
+			front = front[:editionFrontArticles]
+		}
+		p.Articles = domain.LayoutArticles(front)
+	} else {
+		s := e.Section(section)
+		if s == nil {
+			http.NotFound(w, r)
+			return
+		}
+		p.Heading = s.Name
+		p.Meta.Title = strings.Title(s.Name) + " - " + e.Name
+		p.Articles = domain.LayoutArticles(s.Articles)
+	}
+	renderSectionPage(ctx, w, &p)
+}
+
+func renderSectionPage(ctx context.Context, w http.ResponseWriter, p *sectionPage) {
+	t, err := templates.get("section-page.html")
+	if err != nil {
+		slog.Error(ctx, "Error parsing template: %s", err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	err = t.Execute(w, p)
+	if err != nil {
+		slog.Error(ctx, "Error executing template: %s", err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+}
+
+// userArticles returns the last couple of days of articles from the reader's
+// sources, interleaved by source weight with the reader's rules applied, as
+// well as the categories they cover
+func userArticles(ctx context.Context, u *domain.User) ([]domain.Article, []domain.Article, []string, error) {
+	ownerID := "admin"
+	if u != nil {
+		ownerID = u.Name
+	}
+	articles, sources, err := dao.GetArticlesForOwner(ctx, ownerID, time.Now().Add(-48*time.Hour), time.Now())
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	cats := make(map[string]struct{})
+	for _, s := range sources {
+		for _, c := range s.Categories {
+			cats[c] = struct{}{}
+		}
+	}
+	valid := []domain.Article{}
+	for _, a := range articles {
+		if !utf8.Valid([]byte(a.Content.Content)) {
+			continue
+		}
+		a.Title = a.Content.Title
+		for _, t := range a.Tags {
+			cats[t] = struct{}{}
+		}
+		valid = append(valid, a)
+	}
+	categories := make([]string, 0, len(cats))
+	for c := range cats {
+		categories = append(categories, c)
+	}
+	sort.Strings(categories)
+
+	var hidden []domain.Article
+	if u != nil {
+		rules, err := dao.GetRules(ctx, u.ID)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		valid, hidden = domain.ApplyRules(rules, valid)
+	}
+	// rules go first so source caps keep highlighted articles
+	valid = domain.SelectArticles(valid)
+	return valid, hidden, categories, nil
+}
+
+// editionForUser loads an edition with the reader's rules applied to it
+func editionForUser(ctx context.Context, u *domain.User, id string) (*domain.Edition, []domain.Article, error) {
+	e, err := dao.GetEdition(ctx, id)
+	if err != nil || e == nil {
+		return nil, nil, err
+	}
+	if u == nil {
+		return e, nil, nil
+	}
+	rules, err := dao.GetRules(ctx, u.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	e, hidden := e.ApplyRules(rules)
+	return e, hidden, nil
+}
@@ -1,8 +1,8 @@
 package handler
 
 import (
-	"html/template"
 	"net/http"
+	"time"
 
 	"github.com/RusticPotatoes/news/dao"
 	"github.com/RusticPotatoes/news/domain"
@@ -10,7 +10,29 @@ import (
 )
 
 type settingsPage struct {
-	Sources []domain.Source
+	Sources     []domain.Source
+	Rules       []domain.Rule
+	RuleFields  []string
+	RuleActions []string
+	Delivery    *domain.Delivery
+	// DeliveryFormats are the ways an edition can be emailed
+	DeliveryFormats []string
+	// MailEnabled is set when the server can send email
+	MailEnabled bool
+	Webhooks    []domain.Webhook
+	// WebhookLog is the most recent webhook deliveries, newest first
+	WebhookLog    []domain.WebhookDelivery
+	WebhookEvents []string
+	WebhookFields []string
+	// Sessions are the devices the reader is logged in on
+	Sessions []domain.Session
+	// SessionID is the session of this request
+	SessionID string
+	// Tokens are the reader's API tokens
+	Tokens      []domain.APIToken
+	TokenScopes []string
+	// NewToken is a token that was just made, it's only ever shown once
+	NewToken string
 	base
 }
 
@@ -22,6 +44,8 @@ type base struct {
 	Name       string
 	Title      string
 	Meta       Meta
+	// CSRFToken has to be posted with every form, as csrf_token
+	CSRFToken string
 }
 
 type Meta struct {
@@ -32,10 +56,15 @@ type Meta struct {
 }
 
 func handleSettings(w http.ResponseWriter, r *http.Request) {
+	renderSettings(w, r, "")
+}
+
+// renderSettings shows the settings page, along with newToken if the
+// reader just made one
+func renderSettings(w http.ResponseWriter, r *http.Request, newToken string) {
 	ctx := r.Context()
 
-	t := template.New("frame.html")
-	t, err := t.ParseFiles("tmpl/frame.html", "tmpl/meta.html", "tmpl/settings.html")
+	t, err := templates.get("settings.html")
 	if err != nil {
 		slog.Error(ctx, "Error parsing template: %s", err)
 		http.Error(w, err.Error(), 500)
@@ -53,11 +82,75 @@ func handleSettings(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	rules, err := dao.GetRules(ctx, u.ID)
+	if err != nil {
+		http.Error(w, "Couldn't get rules", 500)
+		return
+	}
+
+	delivery, err := dao.GetDelivery(ctx, u.ID)
+	if err != nil {
+		http.Error(w, "Couldn't get delivery settings", 500)
+		return
+	}
+	if delivery == nil {
+		delivery = defaultDelivery(u.ID)
+	}
+
+	webhooks, err := dao.GetWebhooks(ctx, u.ID)
+	if err != nil {
+		http.Error(w, "Couldn't get webhooks", 500)
+		return
+	}
+	webhookLog, err := dao.GetWebhookDeliveries(ctx, u.ID, webhookLogLength)
+	if err != nil {
+		http.Error(w, "Couldn't get webhook deliveries", 500)
+		return
+	}
+
+	sessions, err := dao.GetSessions(ctx, u.ID, time.Now())
+	if err != nil {
+		http.Error(w, "Couldn't get sessions", 500)
+		return
+	}
+	var sessionID string
+	if sess := domain.SessionFromContext(ctx); sess != nil {
+		sessionID = sess.ID
+	}
+
+	tokens, err := dao.GetAPITokens(ctx, u.ID)
+	if err != nil {
+		http.Error(w, "Couldn't get API tokens", 500)
+		return
+	}
+	var scopes []string
+	for _, scope := range domain.TokenScopes {
+		if !domain.AdminScope(scope) || u.IsAdmin {
+			scopes = append(scopes, scope)
+		}
+	}
+
 	s := settingsPage{
-		Sources: sources,
+		Sources:         sources,
+		Rules:           rules,
+		RuleFields:      domain.RuleFields,
+		RuleActions:     domain.RuleActions,
+		Delivery:        delivery,
+		DeliveryFormats: domain.DeliveryFormats,
+		MailEnabled:     config.SMTP.Enabled(),
+		Webhooks:        webhooks,
+		WebhookLog:      webhookLog,
+		WebhookEvents:   domain.WebhookEvents,
+		WebhookFields:   domain.WebhookFields,
+		Sessions:        sessions,
+		SessionID:       sessionID,
+		Tokens:          tokens,
+		TokenScopes:     scopes,
+		NewToken:        newToken,
 		base: base{
-			ID:   "Settings",
-			User: u,
+			ID:        "Settings",
+			User:      u,
+			CSRFToken: csrfToken(ctx),
 		},
 	}
 
@@ -0,0 +1,221 @@
+package handler
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/RusticPotatoes/news/dao"
+	"github.com/RusticPotatoes/news/domain"
+	"github.com/RusticPotatoes/news/pkg/opml"
+	"github.com/monzo/slog"
+)
+
+type accountPage struct {
+	// Message is the outcome of the last change, shown above the forms
+	Message string
+	base
+}
+
+// handleSettingsAccount shows the account page, and changes the reader's
+// password or deletes their account
+func handleSettingsAccount(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	u := domain.UserFromContext(ctx)
+	if u == nil {
+		http.Error(w, "Not logged in", 400)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		r.ParseForm()
+		switch r.Form.Get("action") {
+		case "password":
+			changePassword(w, r, u)
+		case "delete":
+			deleteAccount(w, r, u)
+		default:
+			http.Error(w, "unknown action", 400)
+		}
+		return
+	}
+
+	t, err := templates.get("account.html")
+	if err != nil {
+		slog.Error(ctx, "Error parsing template: %s", err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	p := accountPage{
+		Message: r.URL.Query().Get("msg"),
+		base: base{
+			ID:        "Account",
+			User:      u,
+			CSRFToken: csrfToken(ctx),
+		},
+	}
+	err = t.Execute(w, &p)
+	if err != nil {
+		slog.Error(ctx, "Error executing template: %s", err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+}
+
+func accountRedirect(w http.ResponseWriter, r *http.Request, msg string) {
+	http.Redirect(w, r, "/settings/account?msg="+url.QueryEscape(msg), http.StatusSeeOther)
+}
+
+// changePassword sets a new password once the current one is confirmed,
+// and logs the reader out of their other devices
+func changePassword(w http.ResponseWriter, r *http.Request, u *domain.User) {
+	ctx := r.Context()
+	// accounts made by single sign-on can set a password without one
+	if u.HasPassword() && !u.ValidatePassword(r.Form.Get("current")) {
+		accountRedirect(w, r, "Your current password is wrong.")
+		return
+	}
+	password := r.Form.Get("password")
+	if len(password) < domain.MinPasswordLength {
+		accountRedirect(w, r, fmt.Sprintf("Passwords must be at least %d characters.", domain.MinPasswordLength))
+		return
+	}
+	if password != r.Form.Get("confirm") {
+		accountRedirect(w, r, "The new passwords don't match.")
+		return
+	}
+
+	err := u.SetPassword(password)
+	if err == nil {
+		err = dao.SetUser(ctx, u)
+	}
+	if err == nil {
+		var keep string
+		if sess := domain.SessionFromContext(ctx); sess != nil {
+			keep = sess.ID
+		}
+		err = dao.RevokeOtherSessions(ctx, u.ID, keep)
+	}
+	if err != nil {
+		httpError(ctx, w, "error changing password", err)
+		return
+	}
+	slog.Info(ctx, "User %s changed their password", u.Name)
+	accountRedirect(w, r, "Your password has been changed, and your other devices logged out.")
+}
+
+// deleteAccount removes the reader and everything they've set up, once
+// they've confirmed their password
+func deleteAccount(w http.ResponseWriter, r *http.Request, u *domain.User) {
+	ctx := r.Context()
+	if u.HasPassword() && !u.ValidatePassword(r.Form.Get("current")) {
+		accountRedirect(w, r, "Your password is wrong, your account hasn't been deleted.")
+		return
+	}
+	// without a password, typing the account name has to do
+	if !u.HasPassword() && r.Form.Get("current") != u.Name {
+		accountRedirect(w, r, "Type your account name to delete it, your account hasn't been deleted.")
+		return
+	}
+	// the site can't be left without an admin to run it
+	if u.IsAdmin {
+		accountRedirect(w, r, "Admins can't delete their account, ask another admin to remove your admin rights first.")
+		return
+	}
+
+	err := dao.DeleteUser(ctx, u)
+	if err != nil {
+		httpError(ctx, w, "error deleting account", err)
+		return
+	}
+	slog.Info(ctx, "User %s deleted their account", u.Name)
+	clearSessionCookie(w, r)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// accountExport is everything the reader has set up. Sources are also
+// exported as OPML, for other feed readers.
+type accountExport struct {
+	Exported time.Time
+	User     *domain.User
+	Sources  []domain.Source
+	Rules    []domain.Rule
+	Delivery *domain.Delivery
+	Webhooks []domain.Webhook
+	Sessions []domain.Session
+}
+
+// handleAccountExport downloads the reader's data as a zip of
+// account.json and sources.opml
+func handleAccountExport(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	u := domain.UserFromContext(ctx)
+	if u == nil {
+		http.Error(w, "Not logged in", 400)
+		return
+	}
+
+	now := time.Now()
+	e := accountExport{Exported: now, User: u}
+	var err error
+	e.Sources, err = dao.GetSources(ctx, u.Name)
+	if err != nil {
+		httpError(ctx, w, "Couldn't get sources", err)
+		return
+	}
+	e.Rules, err = dao.GetRules(ctx, u.ID)
+	if err != nil {
+		httpError(ctx, w, "Couldn't get rules", err)
+		return
+	}
+	e.Delivery, err = dao.GetDelivery(ctx, u.ID)
+	if err != nil {
+		httpError(ctx, w, "Couldn't get delivery settings", err)
+		return
+	}
+	e.Webhooks, err = dao.GetWebhooks(ctx, u.ID)
+	if err != nil {
+		httpError(ctx, w, "Couldn't get webhooks", err)
+		return
+	}
+	e.Sessions, err = dao.GetSessions(ctx, u.ID, now)
+	if err != nil {
+		httpError(ctx, w, "Couldn't get sessions", err)
+		return
+	}
+
+	doc := opml.New(u.Name+"'s news sources", now)
+	for _, s := range e.Sources {
+		doc.Feed(s.Name, s.FeedURL, s.URL, s.Categories)
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="news-%s-%s.zip"`, url.PathEscape(u.Name), now.Format("2006-01-02")))
+	w.Header().Set("Cache-Control", "no-store")
+
+	z := zip.NewWriter(w)
+	f, err := z.CreateHeader(&zip.FileHeader{Name: "account.json", Method: zip.Deflate, Modified: now})
+	if err == nil {
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(e)
+	}
+	if err == nil {
+		f, err = z.CreateHeader(&zip.FileHeader{Name: "sources.opml", Method: zip.Deflate, Modified: now})
+	}
+	if err == nil {
+		err = doc.Write(f)
+	}
+	if err == nil {
+		err = z.Close()
+	}
+	if err != nil {
+		// the headers have gone, all that can be done is stop
+		slog.Error(ctx, "Error writing export for %s: %s", u.Name, err)
+	}
+}
@@ -0,0 +1,223 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/RusticPotatoes/news/dao"
+	"github.com/RusticPotatoes/news/domain"
+	"github.com/monzo/slog"
+)
+
+// defaultDelivery is shown in settings before a reader has set up delivery
+func defaultDelivery(ownerID string) *domain.Delivery {
+	return &domain.Delivery{
+		OwnerID:  ownerID,
+		Format:   domain.DeliveryHTML,
+		SendTime: "07:00",
+	}
+}
+
+func handleSettingsDelivery(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	r.ParseForm()
+
+	u := domain.UserFromContext(ctx)
+	if u == nil {
+		http.Error(w, "not logged in", 400)
+		return
+	}
+
+	old, err := dao.GetDelivery(ctx, u.ID)
+	if err != nil {
+		slog.Error(ctx, "Error getting delivery: %s", err)
+		http.Error(w, "error storing delivery", 500)
+		return
+	}
+	if old == nil {
+		old = defaultDelivery(u.ID)
+	}
+	d := domain.Delivery{
+		OwnerID:         u.ID,
+		Enabled:         r.Form.Get("enabled") != "",
+		Email:           strings.TrimSpace(r.Form.Get("email")),
+		KindleEmail:     strings.TrimSpace(r.Form.Get("kindle_email")),
+		Format:          r.Form.Get("format"),
+		SendTime:        r.Form.Get("send_time"),
+		EmailConfirmed:  old.EmailConfirmed,
+		KindleConfirmed: old.KindleConfirmed,
+	}
+	if err := d.Validate(); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	err = dao.SetDelivery(ctx, &d)
+	if err != nil {
+		slog.Error(ctx, "Error storing delivery: %s", err)
+		http.Error(w, "error storing delivery", 500)
+		return
+	}
+
+	// new addresses are sent a link to confirm them, and resending asks
+	// again for any that haven't been
+	action := r.Form.Get("action")
+	var confirm []string
+	for _, target := range d.UnconfirmedTargets() {
+		if action == "resend" || d.Address(target) != old.Address(target) {
+			confirm = append(confirm, target)
+		}
+	}
+	if len(confirm) == 0 && action != "test" {
+		http.Redirect(w, r, "/settings", http.StatusSeeOther)
+		return
+	}
+
+	cfg := config.SMTP
+	if !cfg.Enabled() {
+		http.Error(w, "email isn't set up on this server", 400)
+		return
+	}
+	// every email sent from the form counts against the reader, so it
+	// can't be used to mail strangers in bulk
+	if ok, wait := deliveryLimiter.Allow(u.ID, time.Now()); !ok {
+		w.Header().Set("Retry-After", retryAfter(wait))
+		http.Error(w, "too many emails sent, try again later", http.StatusTooManyRequests)
+		return
+	}
+	for _, target := range confirm {
+		err = sendConfirmation(ctx, cfg, &d, target)
+		if err != nil {
+			slog.Error(ctx, "Error sending delivery confirmation: %s", err)
+			http.Error(w, "error sending confirmation: "+err.Error(), 500)
+			return
+		}
+	}
+
+	// sending straight away makes it easy to check the settings against a
+	// local SMTP sink, without waiting for the send time
+	if action == "test" {
+		targets := d.ConfirmedTargets()
+		if len(targets) == 0 {
+			http.Error(w, "confirm an address before sending a test, follow the link emailed to it", 400)
+			return
+		}
+		err = deliverEdition(ctx, cfg, &d, targets, nil)
+		if err != nil {
+			slog.Error(ctx, "Error sending test delivery: %s", err)
+			http.Error(w, "error sending edition: "+err.Error(), 500)
+			return
+		}
+	}
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
+
+type unsubscribePage struct {
+	// Token is posted back to confirm
+	Token string
+	// Done is set once delivery has been turned off
+	Done bool
+	base
+}
+
+// handleUnsubscribe turns off delivery from the link in an email. GET only
+// asks to confirm, since mail scanners follow links, and the confirmation
+// posts back. POST is also used by mail clients' one click unsubscribe.
+func handleUnsubscribe(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	token := r.FormValue("token")
+	ownerID, err := domain.ParseUnsubscribeToken(token)
+	if err != nil {
+		http.Error(w, "invalid or expired unsubscribe link, turn delivery off in your settings instead", 400)
+		return
+	}
+	p := unsubscribePage{
+		Token: token,
+		base: base{
+			ID:   "Unsubscribe",
+			User: domain.UserFromContext(ctx),
+		},
+	}
+	if r.Method == http.MethodPost {
+		err = dao.SetDeliveryEnabled(ctx, ownerID, false)
+		if err != nil {
+			slog.Error(ctx, "Error unsubscribing %s: %s", ownerID, err)
+			http.Error(w, "error unsubscribing", 500)
+			return
+		}
+		p.Done = true
+	}
+
+	t, err := templates.get("unsubscribe.html")
+	if err != nil {
+		slog.Error(ctx, "Error parsing template: %s", err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	err = t.Execute(w, &p)
+	if err != nil {
+		slog.Error(ctx, "Error executing template: %s", err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+}
+
+type confirmPage struct {
+	// Token is posted back to confirm
+	Token   string
+	Address string
+	// Done is set once the address has been confirmed
+	Done bool
+	base
+}
+
+// handleConfirmDelivery confirms an address from the link emailed to it.
+// Like unsubscribing, GET only asks and the confirmation posts back, so a
+// mail scanner following the link doesn't confirm it.
+func handleConfirmDelivery(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	token := r.FormValue("token")
+	c, err := domain.ParseConfirmToken(token)
+	if err != nil {
+		http.Error(w, "invalid or expired confirmation link, save your delivery settings to be sent another", 400)
+		return
+	}
+	p := confirmPage{
+		Token:   token,
+		Address: c.Address,
+		base: base{
+			ID:   "Confirm",
+			User: domain.UserFromContext(ctx),
+		},
+	}
+	if r.Method == http.MethodPost {
+		ok, err := dao.SetDeliveryConfirmed(ctx, c)
+		if err != nil {
+			slog.Error(ctx, "Error confirming delivery to %s: %s", c.OwnerID, err)
+			http.Error(w, "error confirming address", 500)
+			return
+		}
+		if !ok {
+			http.Error(w, "the address has been changed since this link was sent", 400)
+			return
+		}
+		p.Done = true
+	}
+
+	t, err := templates.get("confirm.html")
+	if err != nil {
+		slog.Error(ctx, "Error parsing template: %s", err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	err = t.Execute(w, &p)
+	if err != nil {
+		slog.Error(ctx, "Error executing template: %s", err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+}
@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/RusticPotatoes/news/dao"
+	"github.com/RusticPotatoes/news/domain"
+	"github.com/monzo/slog"
+)
+
+func handleSettingsRule(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	r.ParseForm()
+
+	u := domain.UserFromContext(ctx)
+	if u == nil {
+		http.Error(w, "not logged in", 400)
+		return
+	}
+
+	switch r.Form.Get("action") {
+	case "add":
+		rule := domain.Rule{
+			OwnerID: u.ID,
+			Field:   r.Form.Get("field"),
+			Pattern: strings.TrimSpace(r.Form.Get("pattern")),
+			Action:  r.Form.Get("rule_action"),
+			Created: time.Now(),
+		}
+		if err := rule.Validate(); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		err := dao.SetRule(ctx, &rule)
+		if err != nil {
+			slog.Error(ctx, "Error storing rule: %s", err)
+			http.Error(w, "error storing rule", 500)
+			return
+		}
+	case "delete":
+		err := dao.DeleteRule(ctx, u.ID, r.Form.Get("id"))
+		if err != nil {
+			slog.Error(ctx, "Error deleting rule: %s", err)
+			http.Error(w, "error deleting rule", 500)
+			return
+		}
+	default:
+		http.Error(w, "unknown action", 400)
+		return
+	}
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
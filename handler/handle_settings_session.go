@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/RusticPotatoes/news/dao"
+	"github.com/RusticPotatoes/news/domain"
+	"github.com/monzo/slog"
+)
+
+// handleSettingsSessions logs the reader out of one of their devices, or
+// all of them
+func handleSettingsSessions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	r.ParseForm()
+
+	u := domain.UserFromContext(ctx)
+	if u == nil {
+		http.Error(w, "not logged in", 400)
+		return
+	}
+
+	switch r.Form.Get("action") {
+	case "revoke":
+		err := dao.RevokeSession(ctx, u.ID, r.Form.Get("id"))
+		if err != nil {
+			slog.Error(ctx, "Error revoking session: %s", err)
+			http.Error(w, "error revoking session", 500)
+			return
+		}
+		if sess := domain.SessionFromContext(ctx); sess != nil && sess.ID == r.Form.Get("id") {
+			clearSessionCookie(w, r)
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+	case "revoke_all":
+		err := dao.RevokeSessions(ctx, u.ID)
+		if err != nil {
+			slog.Error(ctx, "Error revoking sessions: %s", err)
+			http.Error(w, "error revoking sessions", 500)
+			return
+		}
+		clearSessionCookie(w, r)
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	default:
+		http.Error(w, "unknown action", 400)
+		return
+	}
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
@@ -2,7 +2,9 @@ package handler
 
 import (
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/RusticPotatoes/news/dao"
@@ -28,6 +30,7 @@ func sourceSettingsData(w http.ResponseWriter, r *http.Request) (interface{}, er
 		categories = r.Form.Get("categories")
 		url        = r.Form.Get("url")
 		feedURL    = r.Form.Get("feed_url")
+		pinSection = strings.TrimSpace(r.Form.Get("pin_section"))
 
 		source *domain.Source
 		u      = domain.UserFromContext(ctx)
@@ -45,12 +48,17 @@ func sourceSettingsData(w http.ResponseWriter, r *http.Request) (interface{}, er
 		}
 		cats = strings.Join(source.Categories, ",")
 	}
+	// changes are only made on a post, which carries a csrf token, gets
+	// just show the form
+	if r.Method != http.MethodPost {
+		confirm = ""
+	}
 	if action == "delete" && confirm == "true" {
 		err = dao.DeleteSource(ctx, id)
 		if err != nil {
 			return nil, err
 		}
-		http.Redirect(w, r, "/settings", http.StatusTemporaryRedirect)
+		http.Redirect(w, r, "/settings", http.StatusSeeOther)
 	}
 	if action == "edit" && confirm == "true" {
 		// if id == "" {
@@ -60,20 +68,37 @@ func sourceSettingsData(w http.ResponseWriter, r *http.Request) (interface{}, er
 		for i := range categories {
 			categories[i] = strings.TrimSpace(categories[i])
 		}
+		weight, err := parseFormFloat(r.Form.Get("weight"), 1)
+		if err != nil {
+			return nil, fmt.Errorf("invalid weight: %s", err)
+		}
+		maxArticles, err := parseFormInt(r.Form.Get("max_articles"), 0)
+		if err != nil {
+			return nil, fmt.Errorf("invalid article cap: %s", err)
+		}
+		if weight <= 0 || maxArticles < 0 {
+			return nil, fmt.Errorf("weight and article cap can't be negative")
+		}
+		if weight > domain.MaxSourceWeight {
+			return nil, fmt.Errorf("weight can't be more than %d", domain.MaxSourceWeight)
+		}
 		src := domain.Source{
-			Name:       name,
-			ID:         id,
-			OwnerID:    u.ID,
-			URL:        url,
-			FeedURL:    feedURL,
-			Categories: categories,
+			Name:        name,
+			ID:          id,
+			OwnerID:     u.Name,
+			URL:         url,
+			FeedURL:     feedURL,
+			Categories:  categories,
+			Weight:      weight,
+			MaxArticles: maxArticles,
+			PinSection:  pinSection,
 		}
 		err = dao.SetSource(ctx, &src)
 		if err != nil {
 			return nil, err
 		}
 		source = &src
-		http.Redirect(w, r, "/settings", http.StatusTemporaryRedirect)
+		http.Redirect(w, r, "/settings", http.StatusSeeOther)
 	}
 	return sourceSettingsPage{
 		Source: func() domain.Source {
@@ -86,3 +111,25 @@ func sourceSettingsData(w http.ResponseWriter, r *http.Request) (interface{}, er
 		Action:           action,
 	}, nil
 }
+
+func parseFormFloat(v string, def float64) (float64, error) {
+	if strings.TrimSpace(v) == "" {
+		return def, nil
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+	if err != nil {
+		return 0, err
+	}
+	// ParseFloat takes NaN and Inf, which compare false with everything
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, fmt.Errorf("%q isn't a number", v)
+	}
+	return f, nil
+}
+
+func parseFormInt(v string, def int) (int, error) {
+	if strings.TrimSpace(v) == "" {
+		return def, nil
+	}
+	return strconv.Atoi(strings.TrimSpace(v))
+}
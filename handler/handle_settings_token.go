@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/RusticPotatoes/news/dao"
+	"github.com/RusticPotatoes/news/domain"
+	"github.com/monzo/slog"
+)
+
+// handleSettingsTokens makes and revokes the reader's API tokens
+func handleSettingsTokens(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	r.ParseForm()
+
+	u := domain.UserFromContext(ctx)
+	if u == nil {
+		http.Error(w, "not logged in", 400)
+		return
+	}
+
+	switch r.Form.Get("action") {
+	case "create":
+		name := strings.TrimSpace(r.Form.Get("name"))
+		if name == "" {
+			http.Error(w, "tokens need a name", 400)
+			return
+		}
+		days, err := strconv.Atoi(r.Form.Get("expires"))
+		if err != nil || days < 0 {
+			http.Error(w, "invalid expiry", 400)
+			return
+		}
+		t, secret, err := domain.NewAPIToken(u, name, r.Form["scope"], time.Duration(days)*24*time.Hour, time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		err = dao.AddAPIToken(ctx, t)
+		if err != nil {
+			slog.Error(ctx, "Error storing API token: %s", err)
+			http.Error(w, "error storing token", 500)
+			return
+		}
+		slog.Info(ctx, "%s made API token %s with scopes %v", u.Name, t.ID, t.Scopes)
+		// the secret isn't kept, so show it now rather than redirecting
+		renderSettings(w, r, secret)
+		return
+	case "revoke":
+		err := dao.RevokeAPIToken(ctx, u.ID, r.Form.Get("id"))
+		if err != nil {
+			slog.Error(ctx, "Error revoking API token: %s", err)
+			http.Error(w, "error revoking token", 500)
+			return
+		}
+	default:
+		http.Error(w, "unknown action", 400)
+		return
+	}
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/RusticPotatoes/news/dao"
+	"github.com/RusticPotatoes/news/domain"
+	"github.com/monzo/slog"
+)
+
+// webhookLogLength is how many recent deliveries are shown in settings
+const webhookLogLength = 25
+
+func handleSettingsWebhook(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	r.ParseForm()
+
+	u := domain.UserFromContext(ctx)
+	if u == nil {
+		http.Error(w, "not logged in", 400)
+		return
+	}
+
+	switch r.Form.Get("action") {
+	case "add":
+		secret, err := domain.NewWebhookSecret()
+		if err != nil {
+			slog.Error(ctx, "Error generating webhook secret: %s", err)
+			http.Error(w, "error generating secret", 500)
+			return
+		}
+		hook := domain.Webhook{
+			OwnerID: u.ID,
+			URL:     strings.TrimSpace(r.Form.Get("url")),
+			Secret:  secret,
+			Event:   r.Form.Get("event"),
+			Field:   r.Form.Get("field"),
+			Pattern: strings.TrimSpace(r.Form.Get("pattern")),
+			Created: time.Now(),
+		}
+		if hook.Event != domain.EventArticle {
+			hook.Field, hook.Pattern = "", ""
+		}
+		if err := hook.Validate(); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		err = dao.SetWebhook(ctx, &hook)
+		if err != nil {
+			slog.Error(ctx, "Error storing webhook: %s", err)
+			http.Error(w, "error storing webhook", 500)
+			return
+		}
+	case "delete":
+		err := dao.DeleteWebhook(ctx, u.ID, r.Form.Get("id"))
+		if err != nil {
+			slog.Error(ctx, "Error deleting webhook: %s", err)
+			http.Error(w, "error deleting webhook", 500)
+			return
+		}
+	default:
+		http.Error(w, "unknown action", 400)
+		return
+	}
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/monzo/slog"
+
+	"github.com/RusticPotatoes/news/dao"
+	"github.com/RusticPotatoes/news/domain"
+	"github.com/RusticPotatoes/news/pkg/jobs"
+)
+
+// signupError is a reason signup failed that can be shown to the reader
+type signupError string
+
+func (e signupError) Error() string { return string(e) }
+
+// errUsernameTaken is a wrong password for an existing account, it counts
+// as a failed login
+var errUsernameTaken = signupError("That username is taken.")
+
+// signupUser creates an account with the default sources, if the signup
+// mode allows it. If the name is taken by an account with the same
+// password that account is returned, so ticking signup twice logs in.
+func signupUser(ctx context.Context, username, password, invite string) (*domain.User, error) {
+	mode := domain.Signup()
+	if mode == domain.SignupDisabled {
+		return nil, signupError("Signing up is closed, ask an admin for an account.")
+	}
+	if username == "" || password == "" {
+		return nil, signupError("Choose a username and password.")
+	}
+	if username == domain.GuestName {
+		return nil, errUsernameTaken
+	}
+
+	u, err := dao.GetUserByName(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	if u != nil {
+		if u.ValidatePassword(password) {
+			return u, nil
+		}
+		return nil, errUsernameTaken
+	}
+	// checked after looking for the account, so one made before there was
+	// a minimum can still log in by ticking signup
+	if len(password) < domain.MinPasswordLength {
+		return nil, signupError(fmt.Sprintf("Passwords must be at least %d characters.", domain.MinPasswordLength))
+	}
+
+	if mode == domain.SignupInvite {
+		if invite == "" {
+			return nil, signupError("You need an invite code to sign up.")
+		}
+		ok, err := dao.UseInvite(ctx, invite, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, signupError("That invite code isn't valid, it may have expired or been used up.")
+		}
+	}
+
+	u = domain.NewUser(ctx, username, password, false)
+	u.Pending = mode == domain.SignupApproval
+	err = jobs.CreateUser(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info(ctx, "New user %s signed up, signup is %s", u.Name, mode)
+	return u, nil
+}
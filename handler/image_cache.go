@@ -0,0 +1,180 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"sync"
+	"time"
+
+	"github.com/monzo/slog"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/RusticPotatoes/news/dao"
+	"github.com/RusticPotatoes/news/domain"
+	"github.com/RusticPotatoes/news/pkg/diskcache"
+	"github.com/RusticPotatoes/news/pkg/metrics"
+)
+
+const (
+	// warmImageWidth is the width of images outside the layout's tiles,
+	// the width of a 6" e-reader in portrait
+	warmImageWidth = 600
+	// imageMaxAge is how long browsers may reuse a dithered image
+	imageMaxAge = 7 * 24 * time.Hour
+)
+
+var (
+	imageCacheOnce sync.Once
+	imageCacheDisk *diskcache.Cache
+	imageFetches   singleflight.Group
+)
+
+// imageCache opens the dithered image cache on first use, it's nil if the
+// cache directory can't be used
+func imageCache(ctx context.Context) *diskcache.Cache {
+	imageCacheOnce.Do(func() {
+		c, err := diskcache.Open(config.ImageCacheDir(), config.ImageCacheSize<<20)
+		if err != nil {
+			slog.Error(ctx, "Error opening image cache, images won't be cached: %s", err)
+			return
+		}
+		imageCacheDisk = c
+	})
+	return imageCacheDisk
+}
+
+func ditherCacheKey(url string, o ditherOptions) string {
+	return fmt.Sprintf("%s|%d|%s|%s|%s", url, o.Width, o.Algorithm, o.Mode, o.Format)
+}
+
+// ditherETag identifies a dithered image without having to make it
+func ditherETag(url string, o ditherOptions) string {
+	return `"` + diskcache.Key(ditherCacheKey(url, o))[:32] + `"`
+}
+
+// ditheredImage returns the encoded dithered image from the cache, making
+// it if it's not there. Concurrent requests for the same image share one
+// fetch.
+func ditheredImage(ctx context.Context, url string, o ditherOptions) ([]byte, error) {
+	key := ditherCacheKey(url, o)
+	cache := imageCache(ctx)
+	if cache != nil {
+		if data, ok := cache.Get(key); ok {
+			metrics.ImageCache.WithLabelValues("hit").Inc()
+			return data, nil
+		}
+		metrics.ImageCache.WithLabelValues("miss").Inc()
+	}
+
+	v, err, _ := imageFetches.Do(key, func() (interface{}, error) {
+		// the fetch is shared, so mustn't be cancelled when the request
+		// that started it goes away
+		fetchCtx, cancel := context.WithTimeout(context.Background(), imageTimeout)
+		defer cancel()
+		img, err := fetchImage(fetchCtx, url)
+		if err != nil {
+			return nil, err
+		}
+		dithered, err := ditherImage(img, uint(o.Width), o.Options)
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		switch o.Format {
+		case "gif":
+			err = gif.Encode(&buf, dithered, nil)
+		case "jpeg":
+			err = jpeg.Encode(&buf, dithered, nil)
+		default:
+			err = png.Encode(&buf, dithered)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if cache != nil {
+			err = cache.Set(key, buf.Bytes())
+			if err != nil {
+				slog.Warn(ctx, "Error caching image %s: %s", url, err)
+			}
+		}
+		return buf.Bytes(), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]byte), nil
+}
+
+// WarmImageCache dithers the current edition's images, for the tiles the
+// edition's pages show them in, so they're cached before anyone asks for
+// them. Readers' rules change the layout a little, so the tiles are the
+// ones the edition gets without any.
+func WarmImageCache(ctx context.Context) error {
+	e, err := dao.GetEditionForTime(ctx, time.Now(), true)
+	if err != nil {
+		slog.Error(ctx, "Error getting edition: %s", err)
+		return err
+	}
+	if e == nil {
+		return nil
+	}
+
+	type tile struct {
+		url  string
+		size int
+	}
+	tiles := make(map[tile]domain.Layout)
+	add := func(aa []domain.Article) {
+		for _, a := range domain.LayoutArticles(aa) {
+			if a.ImageURL != "" {
+				tiles[tile{a.ImageURL, a.Layout.Size}] = a.Layout
+			}
+		}
+	}
+	// the home page's tiles have no layout, the edition's front and
+	// section pages are laid out
+	for _, a := range e.Articles {
+		if a.ImageURL != "" {
+			tiles[tile{a.ImageURL, 0}] = domain.Layout{}
+		}
+	}
+	front := e.Articles
+	if len(front) > editionFrontArticles {
+		front = front[:editionFrontArticles]
+	}
+	add(front)
+	for _, s := range e.Sections() {
+		add(s.Articles)
+	}
+
+	var (
+		wg     sync.WaitGroup
+		sem    = make(chan struct{}, 4)
+		mu     sync.Mutex
+		warmed int
+	)
+	for t, l := range tiles {
+		wg.Add(1)
+		go func(imageURL string, o ditherOptions) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			_, err := ditheredImage(ctx, imageURL, o)
+			if err != nil {
+				slog.Warn(ctx, "Error warming image %s: %s", imageURL, err)
+				return
+			}
+			mu.Lock()
+			warmed++
+			mu.Unlock()
+		}(t.url, ditherDefaults(l))
+	}
+	wg.Wait()
+	slog.Info(ctx, "Warmed %d images for edition %s", warmed, e.ID)
+	return nil
+}
@@ -0,0 +1,139 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/monzo/slog"
+
+	"github.com/RusticPotatoes/news/dao"
+	"github.com/RusticPotatoes/news/domain"
+	"github.com/RusticPotatoes/news/pkg/ratelimit"
+)
+
+var (
+	// loginLimiter bounds how much bcrypt work one address can cause with
+	// login and signup posts, on top of the account and address lockouts
+	loginLimiter = ratelimit.New(20, time.Minute, 10)
+	// searchLimiter protects the full text search, which scans articles
+	searchLimiter = ratelimit.New(30, time.Minute, 10)
+	// imageLimiter is generous as an edition page loads dozens of images,
+	// but stops the dithering endpoint being used to burn CPU
+	imageLimiter = ratelimit.New(600, time.Minute, 200)
+	// apiLimiter keeps scripts from hammering the API, each token has its
+	// own budget so scripts behind one address don't share it
+	apiLimiter = ratelimit.New(120, time.Minute, 30)
+	// deliveryLimiter bounds the test editions and confirmation emails a
+	// reader can have sent, by their user ID
+	deliveryLimiter = ratelimit.New(10, 24*time.Hour, 5)
+)
+
+// rateLimit refuses requests with 429 once the client's address has used
+// up its tokens
+func rateLimit(l *ratelimit.Limiter, next http.Handler) http.Handler {
+	return rateLimitBy(l, clientIP, next)
+}
+
+// apiRateLimit limits API requests by the API token they're made with, or
+// by address for readers using the site
+func apiRateLimit(next http.Handler) http.Handler {
+	return rateLimitBy(apiLimiter, func(r *http.Request) string {
+		if t := domain.APITokenFromContext(r.Context()); t != nil {
+			return "token:" + t.ID
+		}
+		return clientIP(r)
+	}, next)
+}
+
+// rateLimitBy refuses requests with 429 once their key has used up its
+// tokens
+func rateLimitBy(l *ratelimit.Limiter, key func(*http.Request) string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ok, wait := l.Allow(key(r), time.Now())
+		if !ok {
+			w.Header().Set("Retry-After", retryAfter(wait))
+			http.Error(w, "too many requests, slow down", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// retryAfter formats a wait as whole seconds, rounding up
+func retryAfter(wait time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(wait.Seconds())))
+}
+
+// clientIP is the address the request came from. Behind a reverse proxy
+// set trust_proxy, and the address the proxy saw is taken from the end of
+// X-Forwarded-For instead, otherwise every client shares the proxy's.
+func clientIP(r *http.Request) string {
+	if config.TrustProxy {
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			parts := strings.Split(fwd, ",")
+			if ip := strings.TrimSpace(parts[len(parts)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// loginWait is how long before username can be tried from ip again, the
+// longer of the account's and the address's waits
+func loginWait(ctx context.Context, username, ip string, now time.Time) (time.Duration, error) {
+	since := now.Add(-domain.LoginWindow)
+	n, last, err := dao.FailedLoginsForUser(ctx, username, since)
+	if err != nil {
+		return 0, err
+	}
+	wait := domain.AccountLoginPolicy.Wait(n, last, now)
+
+	n, last, err = dao.FailedLoginsForIP(ctx, ip, since)
+	if err != nil {
+		return 0, err
+	}
+	if w := domain.IPLoginPolicy.Wait(n, last, now); w > wait {
+		wait = w
+	}
+	return wait, nil
+}
+
+// recordLogin keeps the outcome of a login attempt for throttling and the
+// admin console
+func recordLogin(ctx context.Context, username, ip string, success bool) {
+	err := dao.AddLoginAttempt(ctx, &domain.LoginAttempt{
+		Username: username,
+		IP:       ip,
+		Success:  success,
+		Created:  time.Now(),
+	})
+	if err != nil {
+		slog.Error(ctx, "Error recording login attempt: %s", err)
+	}
+	if !success {
+		slog.Warn(ctx, "Failed login for %s from %s", username, ip)
+	}
+}
+
+// waitMessage tells the reader how long until they can try again
+func waitMessage(wait time.Duration) string {
+	n, unit := int(math.Ceil(wait.Seconds())), "second"
+	if wait > time.Minute {
+		n, unit = int(math.Ceil(wait.Minutes())), "minute"
+	}
+	if n != 1 {
+		unit += "s"
+	}
+	return fmt.Sprintf("Too many login attempts, try again in %d %s.", n, unit)
+}
@@ -2,7 +2,7 @@ package handler
 
 import (
 	"context"
-	"html/template"
+	"io/fs"
 	"net/http"
 	"net/http/cookiejar"
 
@@ -11,6 +11,7 @@ import (
 
 	"github.com/gorilla/mux"
 	"github.com/monzo/slog"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 var (
@@ -20,35 +21,74 @@ var (
 	}
 
 	p            domain.Publisher
+	// config is the server's config, set by Init
+	config = domain.DefaultConfig()
 	// client       *firesearch.Client
 	// indexService *firesearch.IndexService
 )
 
 
 
-func Init(ctx context.Context) http.Handler {
+// Init sets up the routes, cfg must already be validated. Templates and
+// static files are read from fsys, which holds the tmpl and static
+// directories.
+func Init(ctx context.Context, cfg *domain.Config, fsys fs.FS) (http.Handler, error) {
+	config = cfg
+	var err error
+	templates, err = newTemplateRegistry(fsys, cfg.Dev)
+	if err != nil {
+		return nil, err
+	}
+	assets, err = newAssetRegistry(fsys, cfg.Dev)
+	if err != nil {
+		return nil, err
+	}
+
 	m := mux.NewRouter()
 	m.Handle("/", http.HandlerFunc(handleNews))
-	fs := http.FileServer(http.Dir("./static/"))
-	m.PathPrefix("/static/").Handler(http.StripPrefix("/static/", fs))
-	m.Handle("/image", http.HandlerFunc(handleDitherImage))
+	m.PathPrefix("/static/").Handler(assets)
+	m.Handle("/image", rateLimit(imageLimiter, http.HandlerFunc(handleDitherImage)))
 	m.Handle("/article", http.HandlerFunc(handleArticle))
 	m.Handle("/login", http.HandlerFunc(handleLogin))
+	m.Handle("/login/oidc", http.HandlerFunc(handleOIDCLogin))
+	m.Handle("/login/oidc/callback", http.HandlerFunc(handleOIDCCallback))
 	m.Handle("/settings", http.HandlerFunc(handleSettings))
-	m.Handle("/logout", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		http.SetCookie(w, &http.Cookie{
-			Name: "sess",
-		})
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
-	}))
+	m.Handle("/logout", http.HandlerFunc(handleLogout))
 	m.Handle("/favicon.ico", http.NotFoundHandler())
 	// m.Handle("/events/source", http.HandlerFunc(handlePubsubSource))
 	// m.Handle("/events/article", http.HandlerFunc(handlePubsubArticle))
 	m.Handle("/poll", http.HandlerFunc(handlePoll))
 	m.Handle("/article/debug", http.HandlerFunc(handleDebugArticle))
 	m.Handle("/article/refresh", http.HandlerFunc(handleRefreshArticle))
-	m.Handle("/settings/source", genericHandler("tmpl/settings_source.html", sourceSettingsData))
-	m.Handle("/search", genericHandler("tmpl/search.html", handleSearch))
+	m.Handle("/settings/source", genericHandler("settings_source.html", sourceSettingsData))
+	m.Handle("/settings/rule", http.HandlerFunc(handleSettingsRule))
+	m.Handle("/settings/delivery", http.HandlerFunc(handleSettingsDelivery))
+	m.Handle("/settings/webhook", http.HandlerFunc(handleSettingsWebhook))
+	m.Handle("/settings/sessions", http.HandlerFunc(handleSettingsSessions))
+	m.Handle("/settings/tokens", http.HandlerFunc(handleSettingsTokens))
+	m.Handle("/settings/account", http.HandlerFunc(handleSettingsAccount))
+	m.Handle("/settings/account/export", http.HandlerFunc(handleAccountExport))
+	m.Handle("/settings/delivery/unsubscribe", http.HandlerFunc(handleUnsubscribe))
+	m.Handle("/settings/delivery/confirm", http.HandlerFunc(handleConfirmDelivery))
+	m.Handle("/section/{category}", http.HandlerFunc(handleSection))
+	m.Handle("/edition/{id:[0-9]+}", http.HandlerFunc(handleEdition))
+	m.Handle("/edition/{id:[0-9]+}/section/{section}", http.HandlerFunc(handleEdition))
+	m.Handle("/edition/{id:[0-9]+}.pdf", http.HandlerFunc(handleEditionPDF))
+	m.Handle("/edition/{id:[0-9]+}.epub", http.HandlerFunc(handleEditionEPUB))
+	m.Handle("/search", rateLimit(searchLimiter, genericHandler("search.html", handleSearch)))
+	m.Handle("/admin", adminOnly(handleAdmin))
+	m.Handle("/admin/user", adminOnly(handleAdminUser))
+	m.Handle("/admin/job", adminOnly(handleAdminJob))
+	m.Handle("/admin/invite", adminOnly(handleAdminInvite))
+	m.Handle("/admin/edition/generate", adminOnly(handleGenerateEdition))
+	m.Handle("/api/articles", apiRateLimit(http.HandlerFunc(handleAPIArticles)))
+	m.Handle("/api/sources", apiRateLimit(http.HandlerFunc(handleAPISources)))
+	m.Handle("/api/sources/{id}", apiRateLimit(http.HandlerFunc(handleAPISource)))
+	m.Handle("/healthz", http.HandlerFunc(handleHealthz))
+	m.Handle("/readyz", http.HandlerFunc(handleReadyz))
+	m.Handle("/metrics", adminOnly(promhttp.Handler().ServeHTTP))
+	m.Use(routeMiddleware)
+	m.Use(tokenScopeMiddleware)
 	// m.Handle("/debug/fgprof", fgprof.Handler())
 	// cfg := profiler.Config{
 	// 	Service:        "news",
@@ -66,12 +106,13 @@ func Init(ctx context.Context) http.Handler {
 	// 	panic(err)
 	// }
 
-	h := util.CloudContextMiddleware(
+	h := util.CloudContextMiddleware(config.GoogleProject,
 		util.HTTPLogParamsMiddleware(
 			m,
 		),
 	)
 
+	h = csrfMiddleware(h)
 	h = sessionMiddleware(h)
 
 	// var err error
@@ -79,8 +120,8 @@ func Init(ctx context.Context) http.Handler {
 	// if os.Getenv("USER") == "alexrussell-saw" {
 	slog.Info(ctx, "Using HTTP Publisher")
 	p = &domain.HTTPPublisher{
-		SourceURL:  "http://localhost:8080/events/source",
-		ArticleURL: "http://localhost:8080/events/article",
+		SourceURL:  cfg.LocalURL() + "/events/source",
+		ArticleURL: cfg.LocalURL() + "/events/article",
 	}
 	// } else {
 	// 	slog.Info(ctx, "Using PubSub Publisher")
@@ -109,7 +150,7 @@ func Init(ctx context.Context) http.Handler {
 	// client = firesearch.NewClient("https://firesearch-3phpehgkya-ew.a.run.app/api", res.Payload.String())
 	// indexService = firesearch.NewIndexService(client)
 
-	return h
+	return h, nil
 }
 
 type genericPage struct {
@@ -117,17 +158,17 @@ type genericPage struct {
 	base
 }
 
-func genericHandler(path string, data func(w http.ResponseWriter, r *http.Request) (interface{}, error)) http.Handler {
+func genericHandler(name string, data func(w http.ResponseWriter, r *http.Request) (interface{}, error)) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 		u := domain.UserFromContext(ctx)
 		p := genericPage{
 			base: base{
-				User: u,
+				User:      u,
+				CSRFToken: csrfToken(ctx),
 			},
 		}
-		t := template.New("frame.html")
-		t, err := t.ParseFiles("tmpl/frame.html", "tmpl/meta.html", path)
+		t, err := templates.get(name)
 		if err != nil {
 			slog.Error(ctx, "Error parsing template: %s", err)
 			http.Error(w, err.Error(), 500)
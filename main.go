@@ -2,23 +2,35 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
+	"io/fs"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/go-co-op/gocron"
 	"github.com/monzo/slog"
 
-	"github.com/RusticPotatoes/news/cmd/articles"
 	"github.com/RusticPotatoes/news/dao"
+	"github.com/RusticPotatoes/news/domain"
 	"github.com/RusticPotatoes/news/handler"
+	"github.com/RusticPotatoes/news/pkg/jobs"
+	"github.com/RusticPotatoes/news/pkg/lifecycle"
 	"github.com/RusticPotatoes/news/pkg/util"
+	"github.com/RusticPotatoes/news/pkg/webhooks"
 )
 
 
 func main() {
-	ctx := context.Background()
+	// SIGINT or SIGTERM starts a graceful shutdown, everything started from
+	// here is cancelled with ctx
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	var logger slog.Logger
 	logger = util.ContextParamLogger{Logger: &util.StackDriverLogger{}}
@@ -26,57 +38,191 @@ func main() {
 	slog.SetDefaultLogger(logger)
 
 
-    // Initialize the database
-    err := dao.Init(context.Background())
-    if err != nil {
-        log.Fatalf("failed to initialize database: %v", err)
-    }
+	configPath := flag.String("config", os.Getenv("NEWS_CONFIG"), "JSON config file, see config.example.json")
+	flag.Parse()
 
+	// the environment overrides the file, a bad config stops the server
+	// rather than running half set up
+	cfg, err := domain.LoadConfig(*configPath)
 	if err != nil {
-		slog.Critical(ctx, "Error setting up dao: %s", err)
-		return
+		log.Fatalf("refusing to start: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("refusing to start: %v", err)
+	}
+	domain.SetConfig(cfg)
+
+	// set up the handlers before anything's scheduled, jobs use their config.
+	// In dev mode templates and static files are read from disk instead,
+	// so changes show up without a rebuild.
+	var fsys fs.FS = assets
+	if cfg.Dev {
+		fsys = os.DirFS(".")
+	}
+	h, err := handler.Init(ctx, cfg, fsys)
+	if err != nil {
+		log.Fatalf("failed to set up handlers: %v", err)
 	}
-	ownerID := "admin" 
 
 	// Create a new scheduler
 	s := gocron.NewScheduler(time.UTC)
 
 
-	_, err = s.Every(1).Day().At("2:00").Do(articles.FetchArticles, ctx, ownerID)
+	for _, at := range cfg.FetchTimes {
+		_, err = s.Every(1).Day().At(at).Do(jobs.FetchAllSources, ctx)
+		if err != nil {
+			slog.Critical(ctx, "Error scheduling task: %s", err)
+			return
+		}
+	}
+
+	// export the current edition for e-readers, this skips editions that
+	// have already been exported
+	_, err = s.Every(1).Hour().Do(handler.WriteEditionArtifacts, ctx)
 	if err != nil {
 		slog.Critical(ctx, "Error scheduling task: %s", err)
 		return
 	}
 
-	_, err = s.Every(1).Day().At("10:00").Do(articles.FetchArticles, ctx, ownerID)
+	// dither the current edition's images ahead of time
+	_, err = s.Every(1).Hour().Do(handler.WarmImageCache, ctx)
 	if err != nil {
 		slog.Critical(ctx, "Error scheduling task: %s", err)
 		return
 	}
 
-	_, err = s.Every(1).Day().At("17:00").Do(articles.FetchArticles, ctx, ownerID)
+	// email editions to readers once their send time has passed
+	_, err = s.Every(5).Minutes().Do(handler.SendDeliveries, ctx)
 	if err != nil {
 		slog.Critical(ctx, "Error scheduling task: %s", err)
 		return
 	}
 
-	// Run tasks immediately
-	go articles.FetchArticles(ctx, ownerID)
+	// retry webhook deliveries that failed, with backoff
+	_, err = s.Every(1).Minute().Do(webhooks.RetryDeliveries, ctx)
+	if err != nil {
+		slog.Critical(ctx, "Error scheduling task: %s", err)
+		return
+	}
 
-	go func() {
-		s.StartBlocking()
-	}()
+	// clear out sessions that have expired or been revoked
+	_, err = s.Every(1).Day().At("3:00").Do(dao.DeleteExpiredSessions, ctx)
+	if err != nil {
+		slog.Critical(ctx, "Error scheduling task: %s", err)
+		return
+	}
+
+	// login attempts are kept a while for admins, only the last few minutes
+	// are used for throttling
+	_, err = s.Every(1).Day().At("3:00").Do(dao.DeleteLoginAttempts, ctx, 30*24*time.Hour)
+	if err != nil {
+		slog.Critical(ctx, "Error scheduling task: %s", err)
+		return
+	}
 
-	var addr string
-	if os.Getenv("NEWS_ENV") == "debug" {
-		addr = ":8081"
-	} else {
-		addr = ":8080"
+	// fetch errors are only useful while they're recent
+	_, err = s.Every(1).Day().At("3:00").Do(dao.DeleteFetchErrors, ctx, 30*24*time.Hour)
+	if err != nil {
+		slog.Critical(ctx, "Error scheduling task: %s", err)
+		return
 	}
 
-	slog.Info(ctx, "ready, listening on addr: %s", addr)
-	slog.Error(ctx, "serving: %s", http.ListenAndServe(addr, handler.Init(ctx)))
-	// Keep the main function running
+	// the server's parts are started in this order and stopped in
+	// reverse: requests drain first, then scheduled and background jobs are
+	// waited for, and the database is closed last
+	lc := lifecycle.New()
+	lc.Add(lifecycle.Component{
+		Name: "database",
+		Start: func(ctx context.Context) error {
+			err := dao.Open(cfg.DatabasePath())
+			if err != nil {
+				return err
+			}
+			// bring an older database up to date before anything uses
+			// the schema
+			applied, err := dao.Migrate(ctx)
+			for _, name := range applied {
+				slog.Info(ctx, "Applied migration: %s", name)
+			}
+			if err != nil {
+				return err
+			}
+			return dao.Setup(ctx)
+		},
+		Stop: func(ctx context.Context) error {
+			dao.Close()
+			return nil
+		},
+	})
+
+	// work that isn't scheduled, like the fetch at startup, jobs started
+	// from the admin console and first attempts at webhook deliveries
+	workers := lifecycle.NewGroup(ctx)
+	lc.Add(lifecycle.Component{
+		Name: "workers",
+		Start: func(ctx context.Context) error {
+			// Run tasks immediately
+			workers.Go(func(ctx context.Context) {
+				jobs.FetchAllSources(ctx)
+			})
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			// webhooks go last, the others publish to them
+			return errors.Join(workers.Stop(ctx), handler.StopJobs(ctx), webhooks.Stop(ctx))
+		},
+	})
+
+	// jobs were scheduled with ctx, so running ones are already cancelled
+	// by the time the scheduler stops and waits for them
+	lc.Add(lifecycle.Component{
+		Name: "scheduler",
+		Start: func(ctx context.Context) error {
+			s.StartAsync()
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			return lifecycle.Within(ctx, s.Stop)
+		},
+	})
+	handler.AddReadyCheck("scheduler", func(ctx context.Context) error {
+		if !s.IsRunning() {
+			return errors.New("scheduler isn't running")
+		}
+		return nil
+	})
+
+	srv := &http.Server{
+		Addr:    cfg.Addr,
+		Handler: h,
+	}
+	lc.Add(lifecycle.Component{
+		Name: "http server",
+		Start: func(ctx context.Context) error {
+			ln, err := net.Listen("tcp", cfg.Addr)
+			if err != nil {
+				return err
+			}
+			go func() {
+				if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+					lc.Fail("http server", err)
+				}
+			}()
+			slog.Info(ctx, "ready, listening on addr: %s", cfg.Addr)
+			return nil
+		},
+		Stop: srv.Shutdown,
+	})
+
+	// a second signal skips draining
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
 
-	select {}
-}
\ No newline at end of file
+	if err := lc.Run(ctx, cfg.DrainTimeout()); err != nil {
+		slog.Critical(context.Background(), "Stopped with errors: %s", err)
+		os.Exit(1)
+	}
+	slog.Info(context.Background(), "Stopped")
+}
@@ -0,0 +1,301 @@
+// Package articles fetches sources' feeds and extracts, classifies and
+// stores their articles.
+package articles
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-shiori/go-readability"
+
+	"github.com/RusticPotatoes/news/dao"
+	"github.com/RusticPotatoes/news/domain"
+	"github.com/RusticPotatoes/news/pkg/classify"
+	"github.com/RusticPotatoes/news/pkg/metrics"
+	"github.com/RusticPotatoes/news/pkg/webhooks"
+	"github.com/mmcdole/gofeed"
+	"github.com/monzo/slog"
+)
+
+// extractTimeout bounds fetching a single article's page
+const extractTimeout = 15 * time.Second
+
+// publisher tells readers' webhooks about new articles
+var publisher domain.Publisher = webhooks.New()
+
+// FetchArticles fetches articles from all sources for a user
+func FetchArticles(ctx context.Context, ownerID string) {
+	sources, err := dao.GetAllSourcesForOwner(ctx, ownerID)
+	if err != nil {
+		slog.Critical(ctx, "Error getting sources: %s", err)
+		return
+	}
+
+	classifier := trainClassifier(ctx, ownerID)
+
+	fp := gofeed.NewParser()
+	metrics.FetchQueue.Set(float64(len(sources)))
+	defer metrics.FetchQueue.Set(0)
+	for i, source := range sources {
+		if ctx.Err() != nil {
+			slog.Info(ctx, "Stopping fetch for %s, %d sources left", ownerID, len(sources)-i)
+			return
+		}
+		err := fetchSource(ctx, fp, classifier, source)
+		if err != nil && !errors.Is(err, ctx.Err()) {
+			slog.Critical(ctx, "Error fetching %s: %s", source.Name, err)
+		}
+		metrics.FetchQueue.Dec()
+	}
+}
+
+// FetchSource fetches a single source now, rather than waiting for the
+// next scheduled fetch
+func FetchSource(ctx context.Context, source domain.Source) error {
+	classifier := trainClassifier(ctx, source.OwnerID)
+	return fetchSource(ctx, gofeed.NewParser(), classifier, source)
+}
+
+// Preview extracts the article at link the way a fetch would, with its
+// summaries and the tags ownerID's classifier gives it, without storing it
+func Preview(ctx context.Context, ownerID, link string) (*domain.Article, error) {
+	content, err := extractArticle(ctx, link)
+	if err != nil && !strings.Contains(err.Error(), "failed to parse date") {
+		return nil, err
+	}
+	article := &domain.Article{
+		Title:       removeHTMLTag(content.Title),
+		Description: removeHTMLTag(content.Excerpt),
+		Link:        link,
+		Author:      content.Byline,
+		Content:     content,
+		ImageURL:    content.Image,
+	}
+	if content.PublishedTime != nil {
+		article.Timestamp = *content.PublishedTime
+		article.TS = article.Timestamp.Format("Mon Jan 2 15:04")
+	}
+	article.Summarize()
+	article.InferTags(trainClassifier(ctx, ownerID), nil)
+	return article, nil
+}
+
+// fetchSource stores the source's new articles. Failures are recorded
+// against the source so they show up in the admin console. When ctx is
+// cancelled it stops between articles, so none is left half written.
+func fetchSource(ctx context.Context, fp *gofeed.Parser, classifier *classify.Classifier, source domain.Source) error {
+	metrics.Fetches.WithLabelValues(source.Name).Inc()
+	feed, err := fp.ParseURLWithContext(source.FeedURL, ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		recordFetchError(ctx, source, source.FeedURL, err)
+		return err
+	}
+
+	sourceID, err := strconv.Atoi(source.ID)
+	if err != nil {
+		return err
+	}
+	err = dao.SetLastFetchTimeForSource(ctx, sourceID, time.Now())
+	if err != nil {
+		slog.Error(ctx, "Error setting fetch time for %s: %s", source.Name, err)
+	}
+
+	// Get the articles that were published by the source in the last 24 hours
+	old_articles, err := dao.GetArticlesBySourceAndTime(ctx, source.ID, time.Now().Add(-24*time.Hour), time.Now())
+	if err != nil {
+		return err
+	}
+	for _, item := range feed.Items {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		// Check if the article has already been fetched
+		existingArticle := findArticleByLink(old_articles, item.Link)
+		if existingArticle != nil {
+			// If the article already exists, skip it
+			continue
+		}
+		var authorName string
+		if item.Author != nil {
+			authorName = item.Author.Name
+		}
+		var published time.Time
+		if item.PublishedParsed != nil {
+			published = *item.PublishedParsed
+		}
+
+		// Skip the item if it was not published in the last 24 hours
+		if time.Since(published) > 24*time.Hour {
+			continue
+		}
+
+		start := time.Now()
+		read_article, err := extractArticle(ctx, item.Link)
+		metrics.ExtractionDuration.Observe(time.Since(start).Seconds())
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			if !strings.Contains(err.Error(), "failed to parse date") {
+				log.Printf("failed to parse %s, %v\n", item.Link, err)
+				recordFetchError(ctx, source, item.Link, err)
+				continue
+			}
+			// If it's a date parsing error, ignore it and continue
+			log.Printf("failed to parse date in %s, ignoring: %v\n", item.Link, err)
+		}
+
+		compressedContent, err := domain.CompressContent(read_article)
+		if err != nil {
+			compressedContent = []byte("")
+		}
+
+		// Create an Article from the feed item
+		article := &domain.Article{
+			Title:       removeHTMLTag(item.Title),
+			Description: removeHTMLTag(read_article.Excerpt),
+			Link:        item.Link,
+			Author:      authorName, // This assumes that the item's Author field is not nil
+			Source:    	 source, // This assumes that the source.Name is a string
+			SourceID:    int64(sourceID), // This assumes that the source.Name is a string
+			Timestamp:   published, // This assumes that the item's PublishedParsed field is not nil
+			// Fill in the other Article fields as needed
+			Content: read_article,
+			CompressedContent: compressedContent,
+			ImageURL: read_article.Image,
+			TS:       published.Format("Mon Jan 2 15:04"),
+		}
+
+		article.Summarize()
+		article.InferTags(classifier, item.Categories)
+
+		// article.SetHTMLContent(body_text.Body)
+
+		// sa, err := swan.FromHTML(article.Link, []byte(body_text.Body))
+		// if err != nil {
+		// 	return
+		// }
+		// if sa.Img != nil {
+		// 	article.ImageURL = sa.Img.Src
+		// }
+
+		// Save the Article to the database
+		err = dao.SetArticle(ctx, article)
+		if err != nil {
+			slog.Critical(ctx, "Error saving article: %s", err)
+			recordFetchError(ctx, source, item.Link, err)
+			continue
+		}
+
+		err = publisher.Publish(ctx, domain.EventArticle, article)
+		if err != nil {
+			slog.Error(ctx, "Error publishing article: %s", err)
+		}
+	}
+	return nil
+}
+
+// extractArticle fetches the page at link and pulls out its text, like
+// readability.FromURL but giving up when ctx is cancelled
+func extractArticle(ctx context.Context, link string) (readability.Article, error) {
+	u, err := url.ParseRequestURI(link)
+	if err != nil {
+		return readability.Article{}, fmt.Errorf("failed to parse URL: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, extractTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return readability.Article{}, err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return readability.Article{}, fmt.Errorf("failed to fetch the page: %w", err)
+	}
+	defer res.Body.Close()
+	if !strings.Contains(res.Header.Get("Content-Type"), "text/html") {
+		return readability.Article{}, errors.New("URL is not a HTML document")
+	}
+	return readability.FromReader(res.Body, u)
+}
+
+func recordFetchError(ctx context.Context, source domain.Source, link string, err error) {
+	metrics.FetchErrors.WithLabelValues(source.Name).Inc()
+	ferr := dao.AddFetchError(ctx, &domain.FetchError{
+		SourceID: source.ID,
+		Link:     link,
+		Error:    err.Error(),
+		Created:  time.Now(),
+	})
+	if ferr != nil {
+		slog.Error(ctx, "Error recording fetch error: %s", ferr)
+	}
+}
+
+// trainClassifier builds a topic classifier from the last couple of weeks of
+// articles, labelled with the categories of the source they came from
+func trainClassifier(ctx context.Context, ownerID string) *classify.Classifier {
+	c := classify.New()
+	articles, _, err := dao.GetArticlesForOwner(ctx, ownerID, time.Now().Add(-14*24*time.Hour), time.Now())
+	if err != nil {
+		slog.Error(ctx, "Error getting training articles: %s", err)
+		return c
+	}
+	for _, a := range articles {
+		c.Train(a.Title+" "+a.Content.TextContent, a.Source.Categories)
+	}
+	slog.Info(ctx, "Trained topic classifier on %d articles", len(articles))
+	return c
+}
+
+func findArticleByLink(articles []domain.Article, link string) *domain.Article {
+    for _, article := range articles {
+        if article.Link == link {
+            return &article
+        }
+    }
+    return nil
+}
+
+func removeHTMLTag(in string) string {
+	// regex to match html tag
+	const pattern = `(<\/?[a-zA-A]+?[^>]*\/?>)*`
+	r := regexp.MustCompile(pattern)
+	groups := r.FindAllString(in, -1)
+	// should replace long string first
+	sort.Slice(groups, func(i, j int) bool {
+		return len(groups[i]) > len(groups[j])
+	})
+	for _, group := range groups {
+		if strings.TrimSpace(group) != "" {
+			in = strings.ReplaceAll(in, group, "")
+		}
+	}
+	return in
+}
+
+// func compressContent(content string) ([]byte, error) {
+// 	buf := new(bytes.Buffer)
+// 	w := gzip.NewWriter(buf)
+// 	_, err := w.Write([]byte(content))
+// 	if err != nil {
+// 		return nil, err
+// 	}
+// 	err = w.Close()
+// 	if err != nil {
+// 		return nil, err
+// 	}
+// 	return buf.Bytes(), nil
+// }
@@ -0,0 +1,181 @@
+// Package classify implements a small multinomial naive Bayes text
+// classifier used to infer topic tags for articles.
+package classify
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/RusticPotatoes/news/pkg/goose"
+)
+
+var stopwords = goose.NewStopwords()
+
+const (
+	// maxTokens caps how much of a document is looked at, long texts make
+	// naive Bayes posteriors extremely peaked and aren't any more telling
+	maxTokens = 400
+	// minDocs is the amount of training data needed before the classifier
+	// will make any predictions
+	minDocs = 20
+	// maxLabels is the most labels returned for a single document
+	maxLabels = 3
+	// relativeThreshold is how close to the best label's probability
+	// another label must be to also be returned
+	relativeThreshold = 0.5
+	// minKnownTokens is how many of a document's words the model has to
+	// have seen before it says anything about it
+	minKnownTokens = 5
+	// minMargin is how far, in nats per known word, the best label has to
+	// stand out from the average label. Posteriors from a few hundred
+	// words are near certain either way, so it's the margin per word that
+	// says whether the text is really about the label. It's not measured
+	// against the runner up, since labels that are always trained together
+	// score about the same.
+	minMargin = 0.1
+)
+
+// Classifier is a multi-label naive Bayes classifier, it is safe for
+// concurrent use.
+type Classifier struct {
+	mu sync.RWMutex
+
+	docs   int
+	labels map[string]int
+	words  map[string]map[string]int
+	totals map[string]int
+	vocab  map[string]struct{}
+}
+
+// New returns an untrained classifier.
+func New() *Classifier {
+	return &Classifier{
+		labels: make(map[string]int),
+		words:  make(map[string]map[string]int),
+		totals: make(map[string]int),
+		vocab:  make(map[string]struct{}),
+	}
+}
+
+// Train adds a document with its labels to the model.
+func (c *Classifier) Train(text string, labels []string) {
+	tokens := Tokenize(text)
+	if len(tokens) == 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.docs++
+	for _, l := range labels {
+		l = strings.TrimSpace(l)
+		if l == "" {
+			continue
+		}
+		c.labels[l]++
+		if c.words[l] == nil {
+			c.words[l] = make(map[string]int)
+		}
+		for _, t := range tokens {
+			c.words[l][t]++
+			c.totals[l]++
+		}
+	}
+	for _, t := range tokens {
+		c.vocab[t] = struct{}{}
+	}
+}
+
+// Classify returns the labels that best describe text, most likely first.
+// It returns nothing until the model has seen enough training data, or if
+// no label stands out from the rest.
+func (c *Classifier) Classify(text string) []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.docs < minDocs || len(c.labels) < 2 {
+		return nil
+	}
+
+	// words the model has never seen say nothing about any label, but
+	// smoothing would favour whichever label has seen the fewest words
+	var tokens []string
+	for _, t := range Tokenize(text) {
+		if _, ok := c.vocab[t]; ok {
+			tokens = append(tokens, t)
+		}
+	}
+	if len(tokens) < minKnownTokens {
+		return nil
+	}
+
+	type scored struct {
+		label string
+		score float64
+	}
+	var (
+		scores = make([]scored, 0, len(c.labels))
+		vocab  = float64(len(c.vocab))
+	)
+	for l, n := range c.labels {
+		score := math.Log(float64(n) / float64(c.docs))
+		total := float64(c.totals[l])
+		for _, t := range tokens {
+			// laplace smoothing so unseen words don't zero out a label
+			score += math.Log((float64(c.words[l][t]) + 1) / (total + vocab))
+		}
+		scores = append(scores, scored{label: l, score: score})
+	}
+	sort.Slice(scores, func(i, j int) bool {
+		if scores[i].score == scores[j].score {
+			return scores[i].label < scores[j].label
+		}
+		return scores[i].score > scores[j].score
+	})
+
+	best := scores[0].score
+	var mean float64
+	for _, s := range scores {
+		mean += s.score / float64(len(scores))
+	}
+	if (best-mean)/float64(len(tokens)) < minMargin {
+		return nil
+	}
+
+	// normalise log scores into probabilities relative to the best label
+	var out []string
+	for _, s := range scores {
+		if math.Exp(s.score-best) < relativeThreshold {
+			break
+		}
+		out = append(out, s.label)
+		if len(out) == maxLabels {
+			break
+		}
+	}
+	return out
+}
+
+// Tokenize lowercases text and splits it into words, dropping stop words,
+// numbers and very short tokens.
+func Tokenize(text string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	out := make([]string, 0, len(fields))
+	for _, f := range fields {
+		if len([]rune(f)) < 3 || stopwords.IsStopWord("en", f) {
+			continue
+		}
+		if strings.IndexFunc(f, unicode.IsLetter) == -1 {
+			continue
+		}
+		out = append(out, f)
+		if len(out) == maxTokens {
+			break
+		}
+	}
+	return out
+}
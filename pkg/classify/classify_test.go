@@ -0,0 +1,85 @@
+package classify
+
+import (
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var (
+	topicWords = map[string][]string{
+		"food":        strings.Fields("recipe bread bake flour oven chef kitchen dinner butter cheese sauce pasta garlic tomato dessert"),
+		"tech":        strings.Fields("chip processor laptop smartphone battery silicon display memory benchmark semiconductor wireless device hardware"),
+		"games":       strings.Fields("console player level boss quest multiplayer controller nintendo playstation speedrun esports gameplay trailer"),
+		"programming": strings.Fields("compiler golang python function library github release code developer syntax runtime module"),
+	}
+	commonWords = strings.Fields("people said year week company report today time first last million could would also market country city government world")
+)
+
+// document is n words, frac of them about topic and the rest common to
+// every topic
+func document(r *rand.Rand, topic string, frac float64, n int) string {
+	words := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		pool := commonWords
+		if r.Float64() < frac {
+			pool = topicWords[topic]
+		}
+		words = append(words, pool[r.Intn(len(pool))])
+	}
+	return strings.Join(words, " ")
+}
+
+// trained is a classifier trained on docs documents per topic, tech
+// articles are also labelled electronics as a source with both categories
+// would be
+func trained(r *rand.Rand, docs int) *Classifier {
+	c := New()
+	for _, topic := range []string{"food", "games", "programming", "tech"} {
+		for i := 0; i < docs; i++ {
+			labels := []string{topic}
+			if topic == "tech" {
+				labels = append(labels, "electronics")
+			}
+			c.Train(document(r, topic, 0.3, 80), labels)
+		}
+	}
+	return c
+}
+
+func TestClassify(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	c := trained(r, 10)
+
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{"on topic", document(r, "food", 0.3, 80), []string{"food"}},
+		{"short but on topic", document(r, "games", 0.3, 20), []string{"games"}},
+		{"labels trained together", document(r, "tech", 0.3, 80), []string{"electronics", "tech"}},
+		{"about nothing in particular", document(r, "food", 0, 300), nil},
+		{"barely on topic", document(r, "programming", 0.02, 80), nil},
+		{"unknown words", "quantum entanglement photons observed laboratory physicists measured", nil},
+		{"too few known words", "recipe people", nil},
+		{"empty", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := c.Classify(tt.text)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Classify() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClassifyNeedsTraining(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	c := trained(r, 4)
+	if got := c.Classify(document(r, "food", 0.3, 80)); got != nil {
+		t.Errorf("Classify() with %d documents = %v, want nothing", c.docs, got)
+	}
+}
@@ -0,0 +1,175 @@
+// Package diskcache is a size bounded cache of files in a directory, the
+// least recently used files are removed once it's over its limit.
+package diskcache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cache stores values as files named by the hash of their key. Recency is
+// kept in each file's modification time, so survives restarts.
+type Cache struct {
+	dir string
+	max int64
+
+	mu      sync.Mutex
+	size    int64
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+type entry struct {
+	name string
+	size int64
+}
+
+// Open loads the cache in dir, creating it if needed, and evicts files
+// until it's within max bytes
+func Open(dir string, max int64) (*Cache, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	type file struct {
+		entry
+		used time.Time
+	}
+	var found []file
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		// left over from a write that didn't finish
+		if strings.HasSuffix(f.Name(), ".tmp") {
+			os.Remove(filepath.Join(dir, f.Name()))
+			continue
+		}
+		info, err := f.Info()
+		if err != nil {
+			continue
+		}
+		found = append(found, file{entry{f.Name(), info.Size()}, info.ModTime()})
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].used.After(found[j].used) })
+
+	c := &Cache{
+		dir:     dir,
+		max:     max,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+	for _, f := range found {
+		c.entries[f.name] = c.lru.PushBack(&entry{f.name, f.size})
+		c.size += f.size
+	}
+	c.mu.Lock()
+	c.evict()
+	c.mu.Unlock()
+	return c, nil
+}
+
+// Key is the file name a key is stored under, it's also a stable validator
+// for the value, for example as an ETag
+func Key(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Get returns the value for key and marks it as recently used
+func (c *Cache) Get(key string) ([]byte, bool) {
+	name := Key(key)
+	c.mu.Lock()
+	el, ok := c.entries[name]
+	if ok {
+		c.lru.MoveToFront(el)
+	}
+	c.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+
+	path := filepath.Join(c.dir, name)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		// removed from under us
+		c.mu.Lock()
+		c.remove(name)
+		c.mu.Unlock()
+		return nil, false
+	}
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return data, true
+}
+
+// Set stores the value for key, evicting the least recently used values if
+// the cache is then over its limit
+func (c *Cache) Set(key string, data []byte) error {
+	name := Key(key)
+	path := filepath.Join(c.dir, name)
+	tmp := path + ".tmp"
+	err := os.WriteFile(tmp, data, 0o644)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp, path)
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.remove(name)
+	c.entries[name] = c.lru.PushFront(&entry{name, int64(len(data))})
+	c.size += int64(len(data))
+	c.evict()
+	return nil
+}
+
+// Size is the total size of the cached values in bytes
+func (c *Cache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+// Len is the number of cached values
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+func (c *Cache) remove(name string) {
+	el, ok := c.entries[name]
+	if !ok {
+		return
+	}
+	c.lru.Remove(el)
+	delete(c.entries, name)
+	c.size -= el.Value.(*entry).size
+}
+
+// evict removes files until the cache is within its limit, c.mu must be
+// held
+func (c *Cache) evict() {
+	for c.size > c.max && c.lru.Len() > 0 {
+		e := c.lru.Back().Value.(*entry)
+		c.remove(e.name)
+		os.Remove(filepath.Join(c.dir, e.name))
+	}
+}
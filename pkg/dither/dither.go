@@ -0,0 +1,212 @@
+// Package dither reduces images to a small palette with error diffusion or
+// ordered dithering, for the look of print and for e-ink screens.
+package dither
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+)
+
+// Algorithm is how the difference between a pixel and the nearest palette
+// colour is spread
+type Algorithm string
+
+const (
+	FloydSteinberg Algorithm = "floyd-steinberg"
+	// Atkinson only spreads three quarters of the error, which keeps
+	// highlights and shadows clean at the cost of detail
+	Atkinson Algorithm = "atkinson"
+	// Stucki spreads the error further than Floyd-Steinberg, for smoother
+	// gradients
+	Stucki Algorithm = "stucki"
+	// Bayer is ordered dithering with an 8x8 threshold map, it gives a
+	// regular crosshatch that holds up when images are small
+	Bayer Algorithm = "bayer"
+	// Threshold maps every pixel to its nearest colour, with no dithering
+	Threshold Algorithm = "threshold"
+)
+
+var Algorithms = []Algorithm{FloydSteinberg, Atkinson, Stucki, Bayer, Threshold}
+
+// Mode is the palette images are reduced to
+type Mode string
+
+const (
+	// Color is the eight corners of the RGB cube
+	Color Mode = "color"
+	// Gray is 16 levels of gray, what most e-ink screens can show
+	Gray Mode = "gray"
+	// Mono is 1-bit ink on newsprint
+	Mono Mode = "mono"
+)
+
+var Modes = []Mode{Color, Gray, Mono}
+
+var (
+	ink   = color.RGBA{0x1c, 0x1a, 0x17, 0xff}
+	paper = color.RGBA{0xf4, 0xf0, 0xe6, 0xff}
+)
+
+// Options picks the algorithm and palette, the zero value is
+// Floyd-Steinberg in colour
+type Options struct {
+	Algorithm Algorithm
+	Mode      Mode
+}
+
+func (o Options) withDefaults() Options {
+	if o.Algorithm == "" {
+		o.Algorithm = FloydSteinberg
+	}
+	if o.Mode == "" {
+		o.Mode = Color
+	}
+	return o
+}
+
+func (o Options) Validate() error {
+	o = o.withDefaults()
+	if _, ok := kernels[o.Algorithm]; !ok && o.Algorithm != Bayer && o.Algorithm != Threshold {
+		return fmt.Errorf("unknown dithering algorithm: %s", o.Algorithm)
+	}
+	switch o.Mode {
+	case Color, Gray, Mono:
+	default:
+		return fmt.Errorf("unknown dithering mode: %s", o.Mode)
+	}
+	return nil
+}
+
+// Palette is the colours a mode reduces images to
+func (m Mode) Palette() color.Palette {
+	switch m {
+	case Gray:
+		p := make(color.Palette, 16)
+		for i := range p {
+			p[i] = color.Gray{Y: uint8(i * 17)}
+		}
+		return p
+	case Mono:
+		return color.Palette{ink, paper}
+	}
+	p := make(color.Palette, 0, 8)
+	for i := 0; i < 8; i++ {
+		p = append(p, color.RGBA{uint8(i>>2&1) * 255, uint8(i>>1&1) * 255, uint8(i&1) * 255, 0xff})
+	}
+	return p
+}
+
+// levels is how many values each channel can take, which sets how strong
+// ordered dithering has to be
+func (m Mode) levels() int {
+	if m == Gray {
+		return 16
+	}
+	return 2
+}
+
+// weight is the share of the error passed to the pixel dx across and dy
+// down
+type weight struct {
+	dx, dy int
+	w      float32
+}
+
+var kernels = map[Algorithm][]weight{
+	FloydSteinberg: {
+		{1, 0, 7.0 / 16}, {-1, 1, 3.0 / 16}, {0, 1, 5.0 / 16}, {1, 1, 1.0 / 16},
+	},
+	Atkinson: {
+		{1, 0, 1.0 / 8}, {2, 0, 1.0 / 8},
+		{-1, 1, 1.0 / 8}, {0, 1, 1.0 / 8}, {1, 1, 1.0 / 8},
+		{0, 2, 1.0 / 8},
+	},
+	Stucki: {
+		{1, 0, 8.0 / 42}, {2, 0, 4.0 / 42},
+		{-2, 1, 2.0 / 42}, {-1, 1, 4.0 / 42}, {0, 1, 8.0 / 42}, {1, 1, 4.0 / 42}, {2, 1, 2.0 / 42},
+		{-2, 2, 1.0 / 42}, {-1, 2, 2.0 / 42}, {0, 2, 4.0 / 42}, {1, 2, 2.0 / 42}, {2, 2, 1.0 / 42},
+	},
+}
+
+var bayer = [8][8]float32{
+	{0, 32, 8, 40, 2, 34, 10, 42},
+	{48, 16, 56, 24, 50, 18, 58, 26},
+	{12, 44, 4, 36, 14, 46, 6, 38},
+	{60, 28, 52, 20, 62, 30, 54, 22},
+	{3, 35, 11, 43, 1, 33, 9, 41},
+	{51, 19, 59, 27, 49, 17, 57, 25},
+	{15, 47, 7, 39, 13, 45, 5, 37},
+	{63, 31, 55, 23, 61, 29, 53, 21},
+}
+
+// Dither reduces img to the mode's palette
+func Dither(img image.Image, o Options) *image.Paletted {
+	o = o.withDefaults()
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	palette := o.Mode.Palette()
+	out := image.NewPaletted(image.Rect(0, 0, w, h), palette)
+
+	// the image as floats, so error can push channels out of range
+	px := make([][3]float32, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			r, g, bl, _ := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+			c := [3]float32{float32(r >> 8), float32(g >> 8), float32(bl >> 8)}
+			if o.Mode != Color {
+				l := 0.299*c[0] + 0.587*c[1] + 0.114*c[2]
+				c = [3]float32{l, l, l}
+			}
+			px[y*w+x] = c
+		}
+	}
+
+	pal := make([][3]float32, len(palette))
+	for i, c := range palette {
+		r, g, bl, _ := c.RGBA()
+		pal[i] = [3]float32{float32(r >> 8), float32(g >> 8), float32(bl >> 8)}
+	}
+
+	kernel := kernels[o.Algorithm]
+	spread := 255 / float32(o.Mode.levels()-1)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := px[y*w+x]
+			if o.Algorithm == Bayer {
+				t := ((bayer[y%8][x%8]+0.5)/64 - 0.5) * spread
+				c = [3]float32{c[0] + t, c[1] + t, c[2] + t}
+			}
+			i := nearest(pal, c)
+			out.Pix[y*out.Stride+x] = uint8(i)
+
+			if kernel == nil {
+				continue
+			}
+			e := [3]float32{c[0] - pal[i][0], c[1] - pal[i][1], c[2] - pal[i][2]}
+			for _, k := range kernel {
+				nx, ny := x+k.dx, y+k.dy
+				if nx < 0 || nx >= w || ny >= h {
+					continue
+				}
+				n := &px[ny*w+nx]
+				n[0] += e[0] * k.w
+				n[1] += e[1] * k.w
+				n[2] += e[2] * k.w
+			}
+		}
+	}
+	return out
+}
+
+func nearest(pal [][3]float32, c [3]float32) int {
+	best, bestDist := 0, float32(-1)
+	for i, p := range pal {
+		dr, dg, db := c[0]-p[0], c[1]-p[1], c[2]-p[2]
+		d := dr*dr + dg*dg + db*db
+		if bestDist < 0 || d < bestDist {
+			best, bestDist = i, d
+		}
+	}
+	return best
+}
@@ -0,0 +1,385 @@
+// Package epub writes EPUB 3 books, with an EPUB 2 table of contents for
+// older readers.
+package epub
+
+import (
+	"archive/zip"
+	"fmt"
+	"html"
+	"io"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Book is an EPUB being assembled in memory
+type Book struct {
+	ID       string
+	Title    string
+	Author   string
+	Language string
+	Modified time.Time
+	// CSS is linked from every chapter
+	CSS string
+
+	cover     *resource
+	chapters  []chapter
+	resources []resource
+	names     map[string]bool
+}
+
+// chapter is a single XHTML document in the book, chapters sharing a group
+// are listed together in the table of contents
+type chapter struct {
+	ID    string
+	Href  string
+	Group string
+	Title string
+	Body  string
+}
+
+type resource struct {
+	ID        string
+	Href      string
+	MediaType string
+	Data      []byte
+}
+
+// New starts an empty book, id should be stable across rebuilds of the
+// same book so readers recognise it
+func New(id, title string) *Book {
+	return &Book{
+		ID:       id,
+		Title:    title,
+		Language: "en",
+		Modified: time.Now(),
+		CSS:      defaultCSS,
+		names:    make(map[string]bool),
+	}
+}
+
+// AddImage embeds an image and returns its href, for use in chapter bodies
+func (b *Book) AddImage(name string, data []byte, mediaType string) string {
+	r := resource{
+		ID:        b.uniqueID("img"),
+		Href:      b.uniqueName("images/" + name),
+		MediaType: mediaType,
+		Data:      data,
+	}
+	b.resources = append(b.resources, r)
+	return r.Href
+}
+
+// SetCover sets the cover image, which also gets its own page at the start
+// of the book
+func (b *Book) SetCover(data []byte, mediaType string) {
+	b.cover = &resource{
+		ID:        "cover-image",
+		Href:      b.uniqueName("images/cover" + extension(mediaType)),
+		MediaType: mediaType,
+		Data:      data,
+	}
+}
+
+// AddChapter appends a chapter, body must be an XHTML fragment such as the
+// output of XHTML
+func (b *Book) AddChapter(group, title, body string) {
+	n := len(b.chapters) + 1
+	b.chapters = append(b.chapters, chapter{
+		ID:    fmt.Sprintf("chapter-%d", n),
+		Href:  fmt.Sprintf("chapter-%d.xhtml", n),
+		Group: group,
+		Title: title,
+		Body:  body,
+	})
+}
+
+// Write packages the book, the mimetype entry has to be first and
+// uncompressed for readers to detect the format
+func (b *Book) Write(w io.Writer) error {
+	z := zip.NewWriter(w)
+	mt, err := z.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(mt, "application/epub+zip"); err != nil {
+		return err
+	}
+
+	files := []struct {
+		name string
+		tmpl *template.Template
+		data interface{}
+	}{
+		{"META-INF/container.xml", containerTmpl, nil},
+		{"OEBPS/content.opf", opfTmpl, b},
+		{"OEBPS/nav.xhtml", navTmpl, b},
+		{"OEBPS/toc.ncx", ncxTmpl, b},
+	}
+	if b.cover != nil {
+		files = append(files, struct {
+			name string
+			tmpl *template.Template
+			data interface{}
+		}{"OEBPS/cover.xhtml", coverTmpl, b})
+	}
+	for _, f := range files {
+		fw, err := z.Create(f.name)
+		if err != nil {
+			return err
+		}
+		if err := f.tmpl.Execute(fw, f.data); err != nil {
+			return fmt.Errorf("writing %s: %w", f.name, err)
+		}
+	}
+	for _, c := range b.chapters {
+		fw, err := z.Create("OEBPS/" + c.Href)
+		if err != nil {
+			return err
+		}
+		if err := chapterTmpl.Execute(fw, c); err != nil {
+			return fmt.Errorf("writing %s: %w", c.Href, err)
+		}
+	}
+
+	fw, err := z.Create("OEBPS/style.css")
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(fw, b.CSS); err != nil {
+		return err
+	}
+	resources := b.resources
+	if b.cover != nil {
+		resources = append(resources, *b.cover)
+	}
+	for _, r := range resources {
+		fw, err := z.Create("OEBPS/" + r.Href)
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(r.Data); err != nil {
+			return err
+		}
+	}
+	return z.Close()
+}
+
+// tocGroup is a heading in the table of contents and the chapters under it
+type tocGroup struct {
+	Title    string
+	Chapters []chapter
+}
+
+// TOC groups chapters in the order their groups first appear, chapters
+// without a group are listed at the top level
+func (b *Book) TOC() []tocGroup {
+	var groups []tocGroup
+	index := make(map[string]int)
+	for _, c := range b.chapters {
+		if c.Group == "" {
+			groups = append(groups, tocGroup{Chapters: []chapter{c}})
+			continue
+		}
+		i, ok := index[c.Group]
+		if !ok {
+			i = len(groups)
+			index[c.Group] = i
+			groups = append(groups, tocGroup{Title: c.Group})
+		}
+		groups[i].Chapters = append(groups[i].Chapters, c)
+	}
+	return groups
+}
+
+func (b *Book) uniqueID(prefix string) string {
+	return fmt.Sprintf("%s-%d", prefix, len(b.resources)+1)
+}
+
+func (b *Book) uniqueName(name string) string {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	candidate := name
+	for i := 2; b.names[candidate]; i++ {
+		candidate = fmt.Sprintf("%s-%d%s", base, i, ext)
+	}
+	b.names[candidate] = true
+	return candidate
+}
+
+func extension(mediaType string) string {
+	switch mediaType {
+	case "image/png":
+		return ".png"
+	case "image/gif":
+		return ".gif"
+	default:
+		return ".jpg"
+	}
+}
+
+var funcs = template.FuncMap{
+	"esc": html.EscapeString,
+	"date": func(t time.Time) string {
+		return t.UTC().Format("2006-01-02T15:04:05Z")
+	},
+	"inc": func(i int) int { return i + 1 },
+}
+
+var containerTmpl = template.Must(template.New("container").Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`))
+
+var opfTmpl = template.Must(template.New("opf").Funcs(funcs).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="book-id">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:identifier id="book-id">{{esc .ID}}</dc:identifier>
+    <dc:title>{{esc .Title}}</dc:title>
+    <dc:language>{{esc .Language}}</dc:language>
+    {{- if .Author}}
+    <dc:creator>{{esc .Author}}</dc:creator>
+    {{- end}}
+    <meta property="dcterms:modified">{{date .Modified}}</meta>
+    {{- if .HasCover}}
+    <meta name="cover" content="cover-image"/>
+    {{- end}}
+  </metadata>
+  <manifest>
+    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+    <item id="ncx" href="toc.ncx" media-type="application/x-dtbncx+xml"/>
+    <item id="style" href="style.css" media-type="text/css"/>
+    {{- with .Cover}}
+    <item id="cover" href="cover.xhtml" media-type="application/xhtml+xml"/>
+    <item id="{{.ID}}" href="{{esc .Href}}" media-type="{{.MediaType}}" properties="cover-image"/>
+    {{- end}}
+    {{- range .Chapters}}
+    <item id="{{.ID}}" href="{{.Href}}" media-type="application/xhtml+xml"/>
+    {{- end}}
+    {{- range .Resources}}
+    <item id="{{.ID}}" href="{{esc .Href}}" media-type="{{.MediaType}}"/>
+    {{- end}}
+  </manifest>
+  <spine toc="ncx">
+    {{- if .HasCover}}
+    <itemref idref="cover"/>
+    {{- end}}
+    <itemref idref="nav"/>
+    {{- range .Chapters}}
+    <itemref idref="{{.ID}}"/>
+    {{- end}}
+  </spine>
+</package>
+`))
+
+var navTmpl = template.Must(template.New("nav").Funcs(funcs).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" lang="{{esc .Language}}">
+<head>
+  <title>{{esc .Title}}</title>
+  <link rel="stylesheet" type="text/css" href="style.css"/>
+</head>
+<body>
+  <nav epub:type="toc" id="toc">
+    <h1>{{esc .Title}}</h1>
+    <ol>
+    {{- range .TOC}}
+      {{- if .Title}}
+      <li><span>{{esc .Title}}</span>
+        <ol>
+        {{- range .Chapters}}
+          <li><a href="{{.Href}}">{{esc .Title}}</a></li>
+        {{- end}}
+        </ol>
+      </li>
+      {{- else}}
+      {{- range .Chapters}}
+      <li><a href="{{.Href}}">{{esc .Title}}</a></li>
+      {{- end}}
+      {{- end}}
+    {{- end}}
+    </ol>
+  </nav>
+</body>
+</html>
+`))
+
+var ncxTmpl = template.Must(template.New("ncx").Funcs(funcs).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<ncx xmlns="http://www.daisy.org/z3986/2005/ncx/" version="2005-1">
+  <head>
+    <meta name="dtb:uid" content="{{esc .ID}}"/>
+  </head>
+  <docTitle><text>{{esc .Title}}</text></docTitle>
+  <navMap>
+  {{- range $i, $g := .TOC}}
+    {{- if $g.Title}}
+    <navPoint id="group-{{inc $i}}">
+      <navLabel><text>{{esc $g.Title}}</text></navLabel>
+      <content src="{{(index $g.Chapters 0).Href}}"/>
+      {{- range $g.Chapters}}
+      <navPoint id="nav-{{.ID}}">
+        <navLabel><text>{{esc .Title}}</text></navLabel>
+        <content src="{{.Href}}"/>
+      </navPoint>
+      {{- end}}
+    </navPoint>
+    {{- else}}
+    {{- range $g.Chapters}}
+    <navPoint id="nav-{{.ID}}">
+      <navLabel><text>{{esc .Title}}</text></navLabel>
+      <content src="{{.Href}}"/>
+    </navPoint>
+    {{- end}}
+    {{- end}}
+  {{- end}}
+  </navMap>
+</ncx>
+`))
+
+var coverTmpl = template.Must(template.New("cover").Funcs(funcs).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" lang="{{esc .Language}}">
+<head>
+  <title>{{esc .Title}}</title>
+  <link rel="stylesheet" type="text/css" href="style.css"/>
+</head>
+<body class="cover">
+  <img src="{{esc .Cover.Href}}" alt="{{esc .Title}}"/>
+</body>
+</html>
+`))
+
+var chapterTmpl = template.Must(template.New("chapter").Funcs(funcs).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml">
+<head>
+  <title>{{esc .Title}}</title>
+  <link rel="stylesheet" type="text/css" href="style.css"/>
+</head>
+<body>
+{{.Body}}
+</body>
+</html>
+`))
+
+// accessors for the templates
+
+func (b *Book) HasCover() bool        { return b.cover != nil }
+func (b *Book) Cover() *resource      { return b.cover }
+func (b *Book) Chapters() []chapter   { return b.chapters }
+func (b *Book) Resources() []resource { return b.resources }
+
+const defaultCSS = `body { font-family: serif; line-height: 1.4; margin: 0 0.5em; }
+h1 { font-size: 1.5em; line-height: 1.2; margin-bottom: 0.2em; }
+.byline { font-style: italic; font-size: 0.85em; margin-top: 0; }
+img { max-width: 100%; height: auto; }
+figure { margin: 1em 0; }
+figcaption { font-size: 0.8em; font-style: italic; }
+blockquote { margin-left: 1em; font-style: italic; }
+body.cover { margin: 0; padding: 0; text-align: center; }
+body.cover img { max-height: 100%; }
+`
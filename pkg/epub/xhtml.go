@@ -0,0 +1,165 @@
+package epub
+
+import (
+	"bytes"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// allowed are the elements kept when converting HTML to XHTML, anything else
+// is replaced by its children
+var allowed = map[atom.Atom]bool{
+	atom.P: true, atom.Br: true, atom.Hr: true, atom.Div: true, atom.Span: true,
+	atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true, atom.H5: true, atom.H6: true,
+	atom.Ul: true, atom.Ol: true, atom.Li: true, atom.Dl: true, atom.Dt: true, atom.Dd: true,
+	atom.Blockquote: true, atom.Pre: true, atom.Code: true, atom.Q: true, atom.Cite: true,
+	atom.Em: true, atom.Strong: true, atom.B: true, atom.I: true, atom.U: true, atom.S: true,
+	atom.Sub: true, atom.Sup: true, atom.Small: true, atom.Mark: true, atom.Abbr: true, atom.Time: true,
+	atom.A: true, atom.Img: true, atom.Figure: true, atom.Figcaption: true,
+	atom.Table: true, atom.Thead: true, atom.Tbody: true, atom.Tfoot: true,
+	atom.Tr: true, atom.Th: true, atom.Td: true, atom.Caption: true,
+	atom.Section: true, atom.Article: true, atom.Header: true, atom.Footer: true,
+}
+
+// dropped are removed along with everything inside them
+var dropped = map[atom.Atom]bool{
+	atom.Script: true, atom.Style: true, atom.Noscript: true, atom.Iframe: true,
+	atom.Object: true, atom.Embed: true, atom.Form: true, atom.Input: true,
+	atom.Button: true, atom.Select: true, atom.Textarea: true, atom.Svg: true,
+	atom.Video: true, atom.Audio: true, atom.Canvas: true, atom.Template: true,
+}
+
+// attributes kept on allowed elements
+var attributes = map[string]bool{
+	"href": true, "src": true, "alt": true, "title": true,
+	"colspan": true, "rowspan": true,
+}
+
+// ImageSources lists the src of every image in an HTML fragment
+func ImageSources(fragment string) []string {
+	nodes, err := parse(fragment)
+	if err != nil {
+		return nil
+	}
+	var srcs []string
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.DataAtom == atom.Img {
+			if src := attr(n, "src"); src != "" {
+				srcs = append(srcs, src)
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	for _, n := range nodes {
+		walk(n)
+	}
+	return srcs
+}
+
+// XHTML cleans up an HTML fragment, such as readability's article content,
+// so that it's valid in an XHTML chapter. Scripts, embeds and unknown
+// elements are removed, and each image's src is passed through image, which
+// returns the embedded image's href or "" to drop the image.
+func XHTML(fragment string, image func(src string) string) string {
+	nodes, err := parse(fragment)
+	if err != nil {
+		return "<p>" + html.EscapeString(fragment) + "</p>"
+	}
+	var buf bytes.Buffer
+	for _, n := range nodes {
+		for _, c := range clean(n, image) {
+			html.Render(&buf, c)
+		}
+	}
+	return buf.String()
+}
+
+func parse(fragment string) ([]*html.Node, error) {
+	body := &html.Node{Type: html.ElementNode, DataAtom: atom.Body, Data: "body"}
+	return html.ParseFragment(strings.NewReader(fragment), body)
+}
+
+// clean returns the nodes that should replace n
+func clean(n *html.Node, image func(string) string) []*html.Node {
+	switch n.Type {
+	case html.TextNode:
+		return []*html.Node{{Type: html.TextNode, Data: stripControl(n.Data)}}
+	case html.ElementNode:
+	default:
+		return nil
+	}
+	if dropped[n.DataAtom] {
+		return nil
+	}
+
+	var children []*html.Node
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		children = append(children, clean(c, image)...)
+	}
+	if !allowed[n.DataAtom] {
+		return children
+	}
+
+	out := &html.Node{Type: html.ElementNode, DataAtom: n.DataAtom, Data: n.Data}
+	for _, a := range n.Attr {
+		if a.Namespace != "" || !attributes[a.Key] {
+			continue
+		}
+		switch a.Key {
+		case "href":
+			if !strings.HasPrefix(a.Val, "http://") && !strings.HasPrefix(a.Val, "https://") {
+				continue
+			}
+		case "src":
+			if n.DataAtom != atom.Img {
+				continue
+			}
+			a.Val = image(a.Val)
+			if a.Val == "" {
+				return nil
+			}
+		}
+		a.Val = stripControl(a.Val)
+		out.Attr = append(out.Attr, a)
+	}
+	if n.DataAtom == atom.Img {
+		if attr(out, "src") == "" {
+			return nil
+		}
+		if attr(out, "alt") == "" {
+			// alt is required in XHTML
+			out.Attr = append(out.Attr, html.Attribute{Key: "alt", Val: ""})
+		}
+	}
+	for _, c := range children {
+		out.AppendChild(c)
+	}
+	return []*html.Node{out}
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// stripControl removes characters that aren't allowed in XML
+func stripControl(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 && r != '\t' && r != '\n' && r != '\r' {
+			return -1
+		}
+		if r == 0xFFFE || r == 0xFFFF {
+			return -1
+		}
+		return r
+	}, s)
+}
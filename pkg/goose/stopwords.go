@@ -108,6 +108,15 @@ func (stop StopWords) SimpleLanguageDetector(text string) string {
 	return currentLang
 }
 
+// IsStopWord reports whether the word is a stop word in the given language
+func (stop StopWords) IsStopWord(lang string, word string) bool {
+	stops := stop.cachedStopWords[lang]
+	if stops == nil {
+		return false
+	}
+	return stops.Has(strings.ToLower(word))
+}
+
 // ReadLinesOfFile returns the lines from a file as a slice of strings
 func ReadLinesOfFile(filename string) []string {
 	content, err := ioutil.ReadFile(filename)
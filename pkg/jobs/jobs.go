@@ -0,0 +1,109 @@
+// Package jobs is the work the server does on a schedule or from the admin
+// console, that newsctl can also run by hand: fetching sources, generating
+// editions and making accounts.
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/monzo/slog"
+
+	"github.com/RusticPotatoes/news/dao"
+	"github.com/RusticPotatoes/news/domain"
+	"github.com/RusticPotatoes/news/pkg/articles"
+	"github.com/RusticPotatoes/news/pkg/webhooks"
+)
+
+// publisher tells readers' webhooks about new editions
+var publisher domain.Publisher = webhooks.New()
+
+// FetchAllSources fetches every owner's sources, it's run on the fetch
+// schedule, from the admin console and by newsctl
+func FetchAllSources(ctx context.Context) (string, error) {
+	sources, err := dao.GetAllSources(ctx)
+	if err != nil {
+		return "", err
+	}
+	owners := make(map[string]bool)
+	for _, s := range sources {
+		if owners[s.OwnerID] {
+			continue
+		}
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		owners[s.OwnerID] = true
+		articles.FetchArticles(ctx, s.OwnerID)
+	}
+	return fmt.Sprintf("%d sources for %d owners", len(sources), len(owners)), nil
+}
+
+// GenerateEdition selects the last three days' articles into a new
+// edition. If there's already an edition for the current window it's
+// returned instead, unless force is set.
+func GenerateEdition(ctx context.Context, force bool) (*domain.Edition, error) {
+	e, err := dao.GetEditionForTime(ctx, time.Now(), false)
+	if err != nil {
+		return nil, err
+	}
+	if e != nil && !force {
+		slog.Info(ctx, "Edition %s - %s already exists and is within window", e.ID, e.Name)
+		return e, nil
+	}
+
+	e, err = domain.NewEdition(ctx, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now().Add(-72 * time.Hour)
+	end := time.Now()
+	articles, _, err := dao.GetArticlesForOwner(ctx, "", start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	newArticles := []domain.Article{}
+	for _, a := range articles {
+		if a.Content.Title == "" || a.Content.TextContent == "" {
+			continue
+		}
+		if !utf8.Valid([]byte(a.Content.Title)) || !utf8.Valid([]byte(a.Content.TextContent)) {
+			continue
+		}
+		newArticles = append(newArticles, a)
+	}
+	e.Articles = domain.SelectArticles(newArticles)
+
+	err = dao.SetEdition(ctx, e)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info(ctx, "Created new edition: %s - %s", e.ID, e.Name)
+	err = publisher.Publish(ctx, domain.EventEdition, e)
+	if err != nil {
+		slog.Error(ctx, "Error publishing edition: %s", err)
+	}
+	return e, nil
+}
+
+// CreateUser stores a new account and gives it the default sources
+func CreateUser(ctx context.Context, u *domain.User) error {
+	err := dao.SetUser(ctx, u)
+	if err != nil {
+		return err
+	}
+	for _, src := range domain.GetSources() {
+		src := src
+		// readers' sources are looked up by name
+		src.OwnerID = u.Name
+		err := dao.SetSource(ctx, &src)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
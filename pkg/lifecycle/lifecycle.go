@@ -0,0 +1,144 @@
+// Package lifecycle starts the server's components in order and stops them
+// in reverse, so requests finish before the jobs behind them are stopped and
+// nothing writes to the database after it's closed.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/monzo/slog"
+)
+
+// Component is a part of the server that's started and stopped with it
+type Component struct {
+	Name string
+	// Start starts the component and returns, anything long running goes
+	// in its own goroutine and reports failure with Manager.Fail
+	Start func(ctx context.Context) error
+	// Stop waits for the component's work to finish, giving up when ctx is
+	// done
+	Stop func(ctx context.Context) error
+}
+
+// Manager runs components until its context is cancelled or one of them
+// fails
+type Manager struct {
+	components []Component
+	failed     chan error
+}
+
+// New returns a manager with no components
+func New() *Manager {
+	return &Manager{failed: make(chan error, 1)}
+}
+
+// Add adds a component, it's started after and stopped before the ones
+// already added
+func (m *Manager) Add(c Component) {
+	m.components = append(m.components, c)
+}
+
+// Fail reports that a component stopped by itself, which shuts the rest
+// down. Only the first failure is kept.
+func (m *Manager) Fail(name string, err error) {
+	select {
+	case m.failed <- fmt.Errorf("%s failed: %w", name, err):
+	default:
+	}
+}
+
+// Run starts every component then blocks until ctx is done or one fails.
+// The components that started are then stopped in reverse, sharing drain
+// for the whole shutdown.
+func (m *Manager) Run(ctx context.Context, drain time.Duration) error {
+	var (
+		started int
+		errs    []error
+	)
+	for _, c := range m.components {
+		if c.Start != nil {
+			if err := c.Start(ctx); err != nil {
+				errs = append(errs, fmt.Errorf("error starting %s: %w", c.Name, err))
+				break
+			}
+		}
+		started++
+		slog.Info(ctx, "Started %s", c.Name)
+	}
+
+	if len(errs) == 0 {
+		select {
+		case <-ctx.Done():
+			slog.Info(ctx, "Shutting down, waiting up to %s for work to finish", drain)
+		case err := <-m.failed:
+			slog.Error(ctx, "Shutting down: %s", err)
+			errs = append(errs, err)
+		}
+	}
+
+	// ctx is usually cancelled by now, stopping gets its own deadline
+	stopCtx, cancel := context.WithTimeout(context.Background(), drain)
+	defer cancel()
+	for i := started - 1; i >= 0; i-- {
+		c := m.components[i]
+		if c.Stop == nil {
+			continue
+		}
+		if err := c.Stop(stopCtx); err != nil {
+			slog.Error(ctx, "Error stopping %s: %s", c.Name, err)
+			errs = append(errs, fmt.Errorf("error stopping %s: %w", c.Name, err))
+			continue
+		}
+		slog.Info(ctx, "Stopped %s", c.Name)
+	}
+	return errors.Join(errs...)
+}
+
+// Within runs f, which can't be cancelled, and returns early with ctx's
+// error if ctx is done first. f carries on in the background.
+func Within(ctx context.Context, f func()) error {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f()
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Group runs background work that should finish before the server exits
+type Group struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewGroup returns a group whose work is cancelled along with ctx
+func NewGroup(ctx context.Context) *Group {
+	ctx, cancel := context.WithCancel(ctx)
+	return &Group{ctx: ctx, cancel: cancel}
+}
+
+// Go runs f in the background with the group's context
+func (g *Group) Go(f func(ctx context.Context)) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		f(g.ctx)
+	}()
+}
+
+// Stop cancels the group's work and waits for it to return, or for ctx to
+// be done
+func (g *Group) Stop(ctx context.Context) error {
+	g.cancel()
+	return Within(ctx, g.wg.Wait)
+}
@@ -0,0 +1,68 @@
+// Package metrics holds the server's Prometheus metrics, they're served
+// from /metrics along with the Go runtime's.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "news"
+
+var (
+	// HTTPDuration is how long requests take, by route template so URLs
+	// with IDs in them don't make a series each
+	HTTPDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "How long HTTP requests take, by route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "code"})
+
+	// Fetches counts feed fetches by source
+	Fetches = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "fetches_total",
+		Help:      "Feed fetches, by source.",
+	}, []string{"source"})
+
+	// FetchErrors counts feeds and articles that couldn't be fetched or
+	// stored, by source
+	FetchErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "fetch_errors_total",
+		Help:      "Feeds and articles that failed to fetch or store, by source.",
+	}, []string{"source"})
+
+	// ExtractionDuration is how long it takes to fetch an article and pull
+	// its text out
+	ExtractionDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "extraction_duration_seconds",
+		Help:      "How long fetching an article and extracting its text takes.",
+		Buckets:   []float64{.1, .25, .5, 1, 2.5, 5, 10, 15, 30},
+	})
+
+	// FetchQueue is how many sources are left to fetch in the current run
+	FetchQueue = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "fetch_queue_depth",
+		Help:      "Sources waiting to be fetched in the current run.",
+	})
+
+	// ImageCache counts dithered image lookups, result is hit or miss
+	ImageCache = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "image_cache_requests_total",
+		Help:      "Dithered image cache lookups, by hit or miss.",
+	}, []string{"result"})
+
+	// DBQueryDuration is how long database queries take, by the dao
+	// function that made them
+	DBQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "How long database queries take, by query.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"query"})
+)
@@ -0,0 +1,111 @@
+// Package opml reads and writes subscription lists in OPML 2.0, the format
+// feed readers import and export.
+package opml
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Document is an OPML file
+type Document struct {
+	XMLName xml.Name  `xml:"opml"`
+	Version string    `xml:"version,attr"`
+	Head    Head      `xml:"head"`
+	Outline []Outline `xml:"body>outline"`
+}
+
+type Head struct {
+	Title       string `xml:"title,omitempty"`
+	DateCreated string `xml:"dateCreated,omitempty"`
+}
+
+// Outline is a feed, or a folder of feeds when it has children
+type Outline struct {
+	Text     string    `xml:"text,attr"`
+	Title    string    `xml:"title,attr,omitempty"`
+	Type     string    `xml:"type,attr,omitempty"`
+	XMLURL   string    `xml:"xmlUrl,attr,omitempty"`
+	HTMLURL  string    `xml:"htmlUrl,attr,omitempty"`
+	Category string    `xml:"category,attr,omitempty"`
+	Outline  []Outline `xml:"outline,omitempty"`
+}
+
+// New starts a document with no feeds
+func New(title string, created time.Time) *Document {
+	return &Document{
+		Version: "2.0",
+		Head: Head{
+			Title:       title,
+			DateCreated: created.UTC().Format(time.RFC1123Z),
+		},
+	}
+}
+
+// Feed adds an RSS or Atom feed. Categories are joined with commas as OPML
+// expects.
+func (d *Document) Feed(name, feedURL, siteURL string, categories []string) {
+	d.Outline = append(d.Outline, Outline{
+		Text:     name,
+		Title:    name,
+		Type:     "rss",
+		XMLURL:   feedURL,
+		HTMLURL:  siteURL,
+		Category: strings.Join(categories, ","),
+	})
+}
+
+// Write encodes the document with an XML declaration
+func (d *Document) Write(w io.Writer) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(d); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// Read decodes an OPML document, older versions decode the same way
+func Read(r io.Reader) (*Document, error) {
+	var d Document
+	if err := xml.NewDecoder(r).Decode(&d); err != nil {
+		return nil, fmt.Errorf("error reading OPML: %w", err)
+	}
+	return &d, nil
+}
+
+// Feeds returns every outline with a feed URL, including ones inside
+// folders. A feed with no categories of its own takes its folder's name.
+func (d *Document) Feeds() []Outline {
+	var feeds []Outline
+	var walk func(outlines []Outline, folder string)
+	walk = func(outlines []Outline, folder string) {
+		for _, o := range outlines {
+			if o.XMLURL == "" {
+				walk(o.Outline, o.Text)
+				continue
+			}
+			if o.Category == "" {
+				o.Category = folder
+			}
+			feeds = append(feeds, o)
+		}
+	}
+	walk(d.Outline, "")
+	return feeds
+}
+
+// Name is the feed's title, or its text if it has no title
+func (o Outline) Name() string {
+	if o.Title != "" {
+		return o.Title
+	}
+	return o.Text
+}
@@ -0,0 +1,85 @@
+// Package ratelimit is a token bucket rate limiter with a bucket per key,
+// such as a client's IP address.
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Limiter allows each key Rate requests a second on average, with bursts
+// of up to Burst. Buckets are kept in memory, so limits reset when the
+// process restarts.
+type Limiter struct {
+	rate  float64
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	// calls counts Allow calls since idle buckets were last removed
+	calls int
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// pruneEvery is how many calls pass between sweeps for idle buckets
+const pruneEvery = 1024
+
+// New makes a limiter allowing n requests every per, in bursts of up to
+// burst
+func New(n int, per time.Duration, burst int) *Limiter {
+	return &Limiter{
+		rate:    float64(n) / per.Seconds(),
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Allow takes a token from key's bucket. If there isn't one it returns
+// false, and how long until there will be.
+func (l *Limiter) Allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.calls++
+	if l.calls >= pruneEvery {
+		l.prune(now)
+		l.calls = 0
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := (1 - b.tokens) / l.rate
+	return false, time.Duration(wait * float64(time.Second))
+}
+
+// Len is how many keys have a bucket
+func (l *Limiter) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.buckets)
+}
+
+// prune removes buckets that would have refilled, they're the same as no
+// bucket at all
+func (l *Limiter) prune(now time.Time) {
+	full := time.Duration(l.burst / l.rate * float64(time.Second))
+	for k, b := range l.buckets {
+		if now.Sub(b.last) >= full {
+			delete(l.buckets, k)
+		}
+	}
+}
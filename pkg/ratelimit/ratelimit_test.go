@@ -0,0 +1,101 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAllow(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// each step is a call for key after the given time since start
+	type step struct {
+		after    time.Duration
+		key      string
+		want     bool
+		wantWait time.Duration
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"burst then refused", []step{
+			{0, "a", true, 0},
+			{0, "a", true, 0},
+			{0, "a", true, 0},
+			{0, "a", false, 2 * time.Second},
+		}},
+		{"refills over time", []step{
+			{0, "a", true, 0},
+			{0, "a", true, 0},
+			{0, "a", true, 0},
+			{time.Second, "a", false, time.Second},
+			{2 * time.Second, "a", true, 0},
+			{2 * time.Second, "a", false, 2 * time.Second},
+		}},
+		{"refill is capped at the burst", []step{
+			{0, "a", true, 0},
+			{time.Hour, "a", true, 0},
+			{time.Hour, "a", true, 0},
+			{time.Hour, "a", true, 0},
+			{time.Hour, "a", false, 2 * time.Second},
+		}},
+		{"keys have their own buckets", []step{
+			{0, "a", true, 0},
+			{0, "a", true, 0},
+			{0, "a", true, 0},
+			{0, "b", true, 0},
+			{0, "a", false, 2 * time.Second},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// a token every 2 seconds, in bursts of 3
+			l := New(1, 2*time.Second, 3)
+			for i, s := range tt.steps {
+				ok, wait := l.Allow(s.key, start.Add(s.after))
+				if ok != s.want || wait != s.wantWait {
+					t.Errorf("step %d: Allow(%q) = %v, %v, want %v, %v", i, s.key, ok, wait, s.want, s.wantWait)
+				}
+			}
+		})
+	}
+}
+
+func TestPrune(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		after time.Duration
+		want  int
+	}{
+		{"just used", 0, 1},
+		{"partly refilled", 5 * time.Second, 1},
+		{"refilled", 6 * time.Second, 0},
+		{"long idle", time.Hour, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 3 tokens at one every 2 seconds refill in 6 seconds
+			l := New(1, 2*time.Second, 3)
+			l.Allow("a", start)
+			l.prune(start.Add(tt.after))
+			if got := l.Len(); got != tt.want {
+				t.Errorf("Len() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllowPrunes(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := New(1, 2*time.Second, 3)
+	l.Allow("idle", start)
+	for i := 1; i < pruneEvery; i++ {
+		l.Allow("busy", start.Add(time.Minute))
+	}
+	if got := l.Len(); got != 1 {
+		t.Errorf("Len() after %d calls = %d, want 1", pruneEvery, got)
+	}
+}
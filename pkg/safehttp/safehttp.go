@@ -0,0 +1,86 @@
+// Package safehttp makes requests to URLs readers give us, such as images
+// and webhooks, without letting them reach the server's own network.
+package safehttp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrForbidden is returned for URLs and addresses that aren't public
+var ErrForbidden = errors.New("address not allowed")
+
+// maxRedirects is how many redirects a client follows, each is checked
+const maxRedirects = 5
+
+// carrierNAT is the shared address space used inside ISPs and some cloud
+// networks, which net.IP doesn't count as private
+var carrierNAT = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// PublicIP reports whether ip is on the public internet, rather than
+// loopback, private, link local or multicast
+func PublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() ||
+		carrierNAT.Contains(ip))
+}
+
+// CheckURL only allows plain http and https URLs, and refuses hosts that
+// are obviously internal. Names are only checked when they're dialled, by
+// a client from NewClient.
+func CheckURL(u *url.URL) error {
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil {
+		return fmt.Errorf("%w: %s", ErrForbidden, u.Redacted())
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("%w: %s", ErrForbidden, host)
+	}
+	if ip := net.ParseIP(host); ip != nil && !PublicIP(ip) {
+		return fmt.Errorf("%w: %s is not a public address", ErrForbidden, host)
+	}
+	return nil
+}
+
+// NewClient returns a client that refuses to connect to addresses that
+// aren't public. The check is made on the resolved address as it's
+// dialled, so DNS can't be used to point a public name at an internal
+// host, and every redirect is checked too.
+func NewClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext: (&net.Dialer{
+				Timeout: 5 * time.Second,
+				Control: func(network, address string, _ syscall.RawConn) error {
+					host, _, err := net.SplitHostPort(address)
+					if err != nil {
+						return err
+					}
+					ip := net.ParseIP(host)
+					if ip == nil || !PublicIP(ip) {
+						return fmt.Errorf("%w: %s is not a public address", ErrForbidden, host)
+					}
+					return nil
+				},
+			}).DialContext,
+			TLSHandshakeTimeout:   5 * time.Second,
+			ResponseHeaderTimeout: 10 * time.Second,
+			MaxIdleConns:          20,
+			IdleConnTimeout:       90 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return errors.New("too many redirects")
+			}
+			return CheckURL(req.URL)
+		},
+	}
+}
@@ -0,0 +1,287 @@
+// Package summarize builds short extractive summaries of article text by
+// ranking sentences with TextRank and picking the most central ones.
+package summarize
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/RusticPotatoes/news/pkg/goose"
+)
+
+var (
+	stopwords = goose.NewStopwords()
+
+	// boilerplate phrases that show up in extracted text but never make a
+	// useful summary sentence
+	boilerplate = []string{
+		"all rights reserved",
+		"click here",
+		"cookie",
+		"newsletter",
+		"sign up",
+		"subscribe",
+		"advertisement",
+		"read more",
+		"follow us",
+		"share this",
+	}
+)
+
+const (
+	damping    = 0.85
+	iterations = 30
+	tolerance  = 1e-4
+
+	minWords = 5
+	maxWords = 80
+)
+
+// Ranked holds the sentences of a text along with their TextRank order, so
+// summaries of several lengths can be cut from a single ranking pass.
+type Ranked struct {
+	sentences []string
+	// order holds sentence indexes, highest ranked first
+	order []int
+}
+
+// Rank splits text into sentences and ranks them by centrality.
+func Rank(text string) *Ranked {
+	var (
+		all       = splitSentences(text)
+		sentences []string
+		tokens    [][]string
+		lang      = stopwords.SimpleLanguageDetector(sample(text))
+	)
+	for _, s := range all {
+		words := tokenize(s)
+		if len(words) < minWords || len(words) > maxWords || isBoilerplate(s) {
+			continue
+		}
+		var content []string
+		for _, w := range words {
+			if stopwords.IsStopWord(lang, w) {
+				continue
+			}
+			content = append(content, w)
+		}
+		if len(content) == 0 {
+			continue
+		}
+		sentences = append(sentences, s)
+		tokens = append(tokens, content)
+	}
+
+	r := &Ranked{sentences: sentences}
+	if len(sentences) == 0 {
+		return r
+	}
+
+	scores := textRank(tokens)
+	r.order = make([]int, len(sentences))
+	for i := range r.order {
+		r.order[i] = i
+	}
+	sort.SliceStable(r.order, func(i, j int) bool {
+		return scores[r.order[i]] > scores[r.order[j]]
+	})
+	return r
+}
+
+// Sentences returns the n highest ranked sentences in the order they appear
+// in the original text.
+func (r *Ranked) Sentences(n int) []string {
+	if n > len(r.order) {
+		n = len(r.order)
+	}
+	picked := append([]int{}, r.order[:n]...)
+	sort.Ints(picked)
+	out := make([]string, 0, n)
+	for _, i := range picked {
+		out = append(out, r.sentences[i])
+	}
+	return out
+}
+
+// Text returns an n sentence summary joined into a single paragraph.
+func (r *Ranked) Text(n int) string {
+	return strings.Join(r.Sentences(n), " ")
+}
+
+// Summarize returns an n sentence extractive summary of text.
+func Summarize(text string, n int) string {
+	return Rank(text).Text(n)
+}
+
+// textRank scores each sentence using the similarity graph described in
+// Mihalcea & Tarau's TextRank paper, with a small bias towards the lede.
+func textRank(tokens [][]string) []float64 {
+	n := len(tokens)
+	sets := make([]map[string]struct{}, n)
+	for i, ws := range tokens {
+		sets[i] = make(map[string]struct{}, len(ws))
+		for _, w := range ws {
+			sets[i][w] = struct{}{}
+		}
+	}
+
+	weights := make([][]float64, n)
+	totals := make([]float64, n)
+	for i := range weights {
+		weights[i] = make([]float64, n)
+	}
+	for i := 0; i < n; i++ {
+		for j := i + 1; j < n; j++ {
+			w := similarity(sets[i], sets[j])
+			weights[i][j] = w
+			weights[j][i] = w
+			totals[i] += w
+			totals[j] += w
+		}
+	}
+
+	scores := make([]float64, n)
+	for i := range scores {
+		scores[i] = 1
+	}
+	for it := 0; it < iterations; it++ {
+		var delta float64
+		next := make([]float64, n)
+		for i := 0; i < n; i++ {
+			var sum float64
+			for j := 0; j < n; j++ {
+				if weights[j][i] == 0 || totals[j] == 0 {
+					continue
+				}
+				sum += weights[j][i] / totals[j] * scores[j]
+			}
+			next[i] = (1 - damping) + damping*sum
+			delta += math.Abs(next[i] - scores[i])
+		}
+		scores = next
+		if delta < tolerance {
+			break
+		}
+	}
+
+	// news writing front-loads the important facts, so nudge early
+	// sentences up to break ties between similarly central ones
+	for i := range scores {
+		scores[i] *= 1 + 0.2/float64(i+1)
+	}
+	return scores
+}
+
+func similarity(a, b map[string]struct{}) float64 {
+	if len(a) < 2 || len(b) < 2 {
+		return 0
+	}
+	var overlap int
+	for w := range a {
+		if _, ok := b[w]; ok {
+			overlap++
+		}
+	}
+	if overlap == 0 {
+		return 0
+	}
+	return float64(overlap) / (math.Log(float64(len(a))) + math.Log(float64(len(b))))
+}
+
+// splitSentences breaks text on sentence terminators followed by whitespace
+// and on blank lines, which readability uses between paragraphs.
+func splitSentences(text string) []string {
+	var (
+		out []string
+		buf strings.Builder
+		rs  = []rune(text)
+	)
+	flush := func() {
+		s := strings.Join(strings.Fields(buf.String()), " ")
+		if s != "" {
+			out = append(out, s)
+		}
+		buf.Reset()
+	}
+	for i := 0; i < len(rs); i++ {
+		r := rs[i]
+		if r == '\n' && i+1 < len(rs) && rs[i+1] == '\n' {
+			flush()
+			continue
+		}
+		buf.WriteRune(r)
+		if r != '.' && r != '!' && r != '?' {
+			continue
+		}
+		// swallow closing quotes and brackets
+		for i+1 < len(rs) && strings.ContainsRune(`"'”’)]`, rs[i+1]) {
+			i++
+			buf.WriteRune(rs[i])
+		}
+		if i+1 >= len(rs) {
+			break
+		}
+		if !unicode.IsSpace(rs[i+1]) {
+			continue
+		}
+		// don't split on initials and abbreviations such as "U.S. officials"
+		// or "Mr. Smith", which are followed by a lowercase word or are short
+		j := i + 1
+		for j < len(rs) && unicode.IsSpace(rs[j]) {
+			j++
+		}
+		if j < len(rs) && unicode.IsLower(rs[j]) {
+			continue
+		}
+		if isAbbreviation(buf.String()) {
+			continue
+		}
+		flush()
+	}
+	flush()
+	return out
+}
+
+func isAbbreviation(s string) bool {
+	s = strings.TrimRight(s, ".")
+	idx := strings.LastIndexFunc(s, unicode.IsSpace)
+	last := s[idx+1:]
+	switch strings.ToLower(last) {
+	case "mr", "mrs", "ms", "dr", "st", "prof", "sr", "jr", "vs", "inc", "ltd", "co", "no":
+		return true
+	}
+	return len([]rune(last)) == 1 && unicode.IsUpper([]rune(last)[0])
+}
+
+func tokenize(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+func isBoilerplate(s string) bool {
+	l := strings.ToLower(s)
+	for _, b := range boilerplate {
+		if strings.Contains(l, b) {
+			return true
+		}
+	}
+	return false
+}
+
+// sample trims text for language detection, which only needs a few
+// paragraphs to pick up on stop words
+func sample(text string) string {
+	const n = 2000
+	if len(text) <= n {
+		return text
+	}
+	for i := n; i > 0; i-- {
+		if text[i] == ' ' {
+			return text[:i]
+		}
+	}
+	return text[:n]
+}
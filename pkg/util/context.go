@@ -8,9 +8,11 @@ import (
 	"sync"
 )
 
-func CloudContextMiddleware(h http.Handler) http.Handler {
+// CloudContextMiddleware ties each request's logs to its Google Cloud
+// trace in project, traces aren't linked if project is empty
+func CloudContextMiddleware(project string, h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ctx := WithTrace(r.Context(), r)
+		ctx := WithTrace(r.Context(), r, project)
 		r = r.WithContext(ctx)
 		h.ServeHTTP(w, r)
 	})
@@ -18,14 +20,14 @@ func CloudContextMiddleware(h http.Handler) http.Handler {
 
 type traceKey string
 
-func WithTrace(ctx context.Context, r *http.Request) context.Context {
+func WithTrace(ctx context.Context, r *http.Request, project string) context.Context {
 	var trace string
 
 	traceHeader := r.Header.Get("X-Cloud-Trace-Context")
 
 	traceParts := strings.Split(traceHeader, "/")
-	if len(traceParts) > 0 && len(traceParts[0]) > 0 {
-		trace = fmt.Sprintf("projects/russellsaw/traces/%s", traceParts[0])
+	if project != "" && len(traceParts) > 0 && len(traceParts[0]) > 0 {
+		trace = fmt.Sprintf("projects/%s/traces/%s", project, traceParts[0])
 	}
 
 	return context.WithValue(ctx, traceKey("trace"), trace)
@@ -47,11 +49,11 @@ type paramContainer struct {
 }
 
 func WithParams(ctx context.Context, params map[string]string) context.Context {
-	return context.WithValue(ctx, paramKey("params"), paramContainer{params: params})
+	return context.WithValue(ctx, paramKey("params"), &paramContainer{params: params})
 }
 
 func SetParam(ctx context.Context, key, value string) context.Context {
-	v, ok := ctx.Value(paramKey("params")).(paramContainer)
+	v, ok := ctx.Value(paramKey("params")).(*paramContainer)
 	if !ok {
 		return WithParams(ctx, map[string]string{key: value})
 	}
@@ -64,7 +66,7 @@ func SetParam(ctx context.Context, key, value string) context.Context {
 }
 
 func Params(ctx context.Context) map[string]interface{} {
-	container, ok := ctx.Value(paramKey("params")).(paramContainer)
+	container, ok := ctx.Value(paramKey("params")).(*paramContainer)
 	if !ok {
 		return nil
 	}
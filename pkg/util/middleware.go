@@ -2,11 +2,18 @@ package util
 
 import (
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/monzo/slog"
+
+	"github.com/RusticPotatoes/news/pkg/metrics"
 )
 
+// RouteParam is the request param the router sets to the matched route's
+// template, requests that don't match a route are recorded as unmatched
+const RouteParam = "route"
+
 func HTTPLogParamsMiddleware(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
@@ -14,7 +21,40 @@ func HTTPLogParamsMiddleware(h http.Handler) http.Handler {
 		r = r.WithContext(ctx)
 		slog.Info(ctx, "Handling request: %s %s", r.Method, r.URL.Path)
 		start := time.Now()
-		h.ServeHTTP(w, r)
-		slog.Debug(ctx, "Request %s handled in %s", r.URL.Path, time.Since(start))
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(sw, r)
+		took := time.Since(start)
+		slog.Debug(ctx, "Request %s handled in %s", r.URL.Path, took)
+
+		route, _ := Params(ctx)[RouteParam].(string)
+		if route == "" {
+			route = "unmatched"
+		}
+		metrics.HTTPDuration.WithLabelValues(route, r.Method, strconv.Itoa(sw.status)).Observe(took.Seconds())
 	})
 }
+
+// statusWriter remembers the status code written
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	wrote  bool
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if !w.wrote {
+		w.status = code
+		w.wrote = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wrote = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
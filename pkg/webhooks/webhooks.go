@@ -0,0 +1,258 @@
+// Package webhooks delivers new article and edition events to readers'
+// webhooks, retrying failed deliveries with backoff.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/monzo/slog"
+
+	"github.com/RusticPotatoes/news/dao"
+	"github.com/RusticPotatoes/news/domain"
+	"github.com/RusticPotatoes/news/pkg/lifecycle"
+	"github.com/RusticPotatoes/news/pkg/safehttp"
+)
+
+// Publisher sends events to every webhook subscribed to them, it's a
+// domain.Publisher where the topic is the event name
+type Publisher struct {
+	Client *http.Client
+}
+
+// attempts are the first attempts at deliveries, made in the background so
+// publishing doesn't wait on readers' servers. Shutting down waits for them.
+var attempts = lifecycle.NewGroup(context.Background())
+
+// Stop cancels first attempts that are still running and waits for them,
+// deliveries they didn't finish are picked up by RetryDeliveries
+func Stop(ctx context.Context) error {
+	return attempts.Stop(ctx)
+}
+
+// New returns a publisher whose client only connects to public addresses,
+// since readers choose the URLs
+func New() *Publisher {
+	return &Publisher{
+		Client: safehttp.NewClient(10 * time.Second),
+	}
+}
+
+// event is the JSON body of every webhook request. Text is a one line
+// description, which chat services such as Slack and Mattermost show as the
+// message.
+type event struct {
+	Event   string      `json:"event"`
+	Created time.Time   `json:"created"`
+	Text    string      `json:"text"`
+	Data    interface{} `json:"data"`
+}
+
+type articleData struct {
+	ID        string    `json:"id"`
+	Title     string    `json:"title"`
+	URL       string    `json:"url"`
+	Link      string    `json:"link"`
+	Source    string    `json:"source"`
+	Author    string    `json:"author,omitempty"`
+	Tags      []string  `json:"tags,omitempty"`
+	Summary   string    `json:"summary,omitempty"`
+	Published time.Time `json:"published"`
+}
+
+type editionData struct {
+	ID       string   `json:"id"`
+	Name     string   `json:"name"`
+	Date     string   `json:"date"`
+	URL      string   `json:"url"`
+	Articles int      `json:"articles"`
+	Sections []string `json:"sections"`
+}
+
+// Publish queues an event for each matching webhook and makes the first
+// attempt straight away, payload must be an article or an edition
+func (p *Publisher) Publish(ctx context.Context, topic string, payload interface{}) error {
+	hooks, err := dao.GetWebhooksForEvent(ctx, topic)
+	if err != nil {
+		return err
+	}
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	var (
+		e     event
+		match func(h *domain.Webhook) bool
+	)
+	switch v := payload.(type) {
+	case *domain.Article:
+		e = articleEvent(v)
+		owners := make(map[string]*domain.User)
+		match = func(h *domain.Webhook) bool {
+			u, ok := owners[h.OwnerID]
+			if !ok {
+				user, err := dao.GetUser(ctx, h.OwnerID)
+				if err != nil {
+					slog.Error(ctx, "Error getting webhook owner %s: %s", h.OwnerID, err)
+				}
+				u = user
+				owners[h.OwnerID] = u
+			}
+			// readers only hear about articles from their own sources
+			if u == nil || v.Source.OwnerID != u.Name {
+				return false
+			}
+			return h.Matches(v)
+		}
+	case *domain.Edition:
+		e = editionEvent(v)
+		match = func(*domain.Webhook) bool { return true }
+	default:
+		return fmt.Errorf("can't publish %T to webhooks", payload)
+	}
+	e.Event = topic
+	e.Created = time.Now()
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	for i := range hooks {
+		h := hooks[i]
+		if !match(&h) {
+			continue
+		}
+		now := time.Now()
+		d := domain.WebhookDelivery{
+			WebhookID: h.ID,
+			OwnerID:   h.OwnerID,
+			Event:     topic,
+			Payload:   body,
+			Status:    domain.WebhookPending,
+			// leave time for the first attempt before the retry job
+			// would pick it up
+			NextAttempt: now.Add(time.Minute),
+			Created:     now,
+			Updated:     now,
+		}
+		err := dao.SetWebhookDelivery(ctx, &d)
+		if err != nil {
+			slog.Error(ctx, "Error storing webhook delivery: %s", err)
+			continue
+		}
+		attempts.Go(func(ctx context.Context) {
+			p.attempt(ctx, &h, &d)
+		})
+	}
+	return nil
+}
+
+// RetryDeliveries makes another attempt at every delivery that's due a
+// retry
+func RetryDeliveries(ctx context.Context) error {
+	deliveries, err := dao.GetDueWebhookDeliveries(ctx, time.Now())
+	if err != nil {
+		slog.Error(ctx, "Error getting webhook deliveries: %s", err)
+		return err
+	}
+	p := New()
+	for i := range deliveries {
+		d := &deliveries[i]
+		h, err := dao.GetWebhook(ctx, d.WebhookID)
+		if err != nil {
+			slog.Error(ctx, "Error getting webhook %s: %s", d.WebhookID, err)
+			continue
+		}
+		if h == nil {
+			d.Status = domain.WebhookFailed
+			d.Error = "webhook was deleted"
+			d.Updated = time.Now()
+			dao.SetWebhookDelivery(ctx, d)
+			continue
+		}
+		p.attempt(ctx, h, d)
+	}
+	return nil
+}
+
+// attempt posts the delivery's payload, signed with the webhook's secret,
+// and records the outcome
+func (p *Publisher) attempt(ctx context.Context, h *domain.Webhook, d *domain.WebhookDelivery) {
+	err := p.post(ctx, h, d)
+	if err != nil {
+		slog.Warn(ctx, "Webhook delivery %s to %s failed: %s", d.ID, h.URL, err)
+	}
+	err = dao.SetWebhookDelivery(ctx, d)
+	if err != nil {
+		slog.Error(ctx, "Error storing webhook delivery %s: %s", d.ID, err)
+	}
+}
+
+func (p *Publisher) post(ctx context.Context, h *domain.Webhook, d *domain.WebhookDelivery) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		d.Failed(time.Now(), 0, err.Error())
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "The Webpage Webhooks")
+	req.Header.Set("X-Webhook-Event", d.Event)
+	req.Header.Set("X-Webhook-Delivery", d.ID)
+	req.Header.Set("X-Webhook-Signature", h.Sign(d.Payload))
+
+	res, err := p.Client.Do(req)
+	if err != nil {
+		d.Failed(time.Now(), 0, err.Error())
+		return err
+	}
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+	res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		d.Failed(time.Now(), res.StatusCode, res.Status)
+		return fmt.Errorf("unexpected status: %s", res.Status)
+	}
+	d.Delivered(time.Now(), res.StatusCode)
+	return nil
+}
+
+func articleEvent(a *domain.Article) event {
+	u := fmt.Sprintf("%s/article?id=%s", domain.PublicURL(), url.QueryEscape(a.ID))
+	return event{
+		Text: fmt.Sprintf("%s - %s %s", a.Title, a.Source.Name, a.Link),
+		Data: articleData{
+			ID:        a.ID,
+			Title:     a.Title,
+			URL:       u,
+			Link:      a.Link,
+			Source:    a.Source.Name,
+			Author:    a.Author,
+			Tags:      a.Tags,
+			Summary:   a.Summary(500),
+			Published: a.Timestamp,
+		},
+	}
+}
+
+func editionEvent(e *domain.Edition) event {
+	d := editionData{
+		ID:       e.ID,
+		Name:     e.Name,
+		Date:     e.Date,
+		URL:      fmt.Sprintf("%s/edition/%s", domain.PublicURL(), e.ID),
+		Articles: len(e.Articles),
+	}
+	for _, s := range e.Sections() {
+		d.Sections = append(d.Sections, s.Name)
+	}
+	return event{
+		Text: fmt.Sprintf("%s - %s is out, %d stories %s", e.Name, e.Date, len(e.Articles), d.URL),
+		Data: d,
+	}
+}
@@ -4,23 +4,29 @@ import (
 	"database/sql"
 	"log"
 	"os"
+	"path/filepath"
 
+	"github.com/RusticPotatoes/news/domain"
 	_ "github.com/mattn/go-sqlite3"
 )
 
 func main() {
+    c, err := domain.LoadConfig(os.Getenv("NEWS_CONFIG"))
+    if err != nil {
+        log.Fatal(err)
+    }
+    dbPath := c.DatabasePath()
+
     // Check if the data directory exists
-    _, err := os.Stat("./data")
+    _, err = os.Stat(filepath.Dir(dbPath))
     if os.IsNotExist(err) {
         // Create the data directory
-        errDir := os.MkdirAll("./data", 0755)
+        errDir := os.MkdirAll(filepath.Dir(dbPath), 0755)
         if errDir != nil {
             log.Fatal(err)
         }
     }
 
-    dbPath := "./data/news.db"
-
     // Check if the database file exists
     _, err = os.Stat(dbPath)
     if os.IsNotExist(err) {
@@ -30,10 +30,14 @@ CREATE TABLE IF NOT EXISTS articles (
     layout_id INTEGER,
     timestamp DATETIME,
     ts TEXT,
+    summaries TEXT,
+    tags TEXT,
     FOREIGN KEY(source_id) REFERENCES sources(id),
     FOREIGN KEY(layout_id) REFERENCES layouts(id)
 );
 
+CREATE INDEX IF NOT EXISTS articles_image_url ON articles (image_url);
+
 CREATE TABLE IF NOT EXISTS sources (
     id INTEGER PRIMARY KEY AUTOINCREMENT,
     owner_id TEXT DEFAULT 'admin',
@@ -43,17 +47,137 @@ CREATE TABLE IF NOT EXISTS sources (
     categories TEXT,
     disable_fetch BOOLEAN,
     last_fetch_time DATETIME DEFAULT CURRENT_TIMESTAMP,
+    weight REAL DEFAULT 1,
+    max_articles INTEGER DEFAULT 0,
+    pin_section TEXT DEFAULT '',
     UNIQUE(owner_id, url)
 );
 
+CREATE TABLE IF NOT EXISTS fetch_errors (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    source_id INTEGER,
+    link TEXT,
+    error TEXT,
+    created DATETIME,
+    FOREIGN KEY(source_id) REFERENCES sources(id)
+);
+
+CREATE INDEX IF NOT EXISTS fetch_errors_source ON fetch_errors (source_id, created);
+
 CREATE TABLE IF NOT EXISTS users (
     id INTEGER PRIMARY KEY AUTOINCREMENT,
     name TEXT UNIQUE,
     created DATETIME,
     password_hash BLOB,
-    is_admin BOOLEAN
+    is_admin BOOLEAN,
+    last_login DATETIME,
+    pending BOOLEAN,
+    email TEXT
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_email ON users (email);
+
+CREATE TABLE IF NOT EXISTS api_tokens (
+    id TEXT PRIMARY KEY,
+    user_id TEXT,
+    name TEXT,
+    hash BLOB,
+    scopes TEXT,
+    created DATETIME,
+    last_used DATETIME,
+    expires DATETIME,
+    revoked BOOLEAN DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS api_tokens_user ON api_tokens (user_id);
+
+CREATE TABLE IF NOT EXISTS login_attempts (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    username TEXT,
+    ip TEXT,
+    success BOOLEAN,
+    created DATETIME
+);
+
+CREATE INDEX IF NOT EXISTS login_attempts_username ON login_attempts (username, created);
+CREATE INDEX IF NOT EXISTS login_attempts_ip ON login_attempts (ip, created);
+
+CREATE TABLE IF NOT EXISTS invites (
+    code TEXT PRIMARY KEY,
+    created_by INTEGER,
+    created DATETIME,
+    expires DATETIME,
+    max_uses INTEGER,
+    uses INTEGER DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS sessions (
+    id TEXT PRIMARY KEY,
+    user_id TEXT,
+    created DATETIME,
+    expires DATETIME,
+    last_seen DATETIME,
+    user_agent TEXT DEFAULT '',
+    revoked BOOLEAN DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS sessions_user_id ON sessions (user_id);
+
+CREATE TABLE IF NOT EXISTS rules (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    owner_id TEXT,
+    field TEXT,
+    pattern TEXT,
+    action TEXT,
+    created DATETIME
+);
+
+CREATE TABLE IF NOT EXISTS deliveries (
+    owner_id TEXT PRIMARY KEY,
+    enabled BOOLEAN DEFAULT 0,
+    email TEXT DEFAULT '',
+    kindle_email TEXT DEFAULT '',
+    format TEXT DEFAULT 'html',
+    send_time TEXT DEFAULT '07:00',
+    last_sent DATETIME,
+    email_sent DATETIME,
+    kindle_sent DATETIME,
+    failures INTEGER DEFAULT 0,
+    retry_at DATETIME,
+    last_error TEXT DEFAULT '',
+    email_confirmed TEXT DEFAULT '',
+    kindle_confirmed TEXT DEFAULT ''
 );
 
+CREATE TABLE IF NOT EXISTS webhooks (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    owner_id TEXT,
+    url TEXT,
+    secret TEXT,
+    event TEXT,
+    field TEXT DEFAULT '',
+    pattern TEXT DEFAULT '',
+    created DATETIME
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    webhook_id INTEGER,
+    owner_id TEXT,
+    event TEXT,
+    payload BLOB,
+    status TEXT,
+    attempts INTEGER DEFAULT 0,
+    status_code INTEGER DEFAULT 0,
+    error TEXT DEFAULT '',
+    next_attempt DATETIME,
+    created DATETIME,
+    updated DATETIME,
+    FOREIGN KEY(webhook_id) REFERENCES webhooks(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_status ON webhook_deliveries (status, next_attempt);
+
 CREATE TABLE IF NOT EXISTS layouts (
     id INTEGER PRIMARY KEY,
     size INTEGER,